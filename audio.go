@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Path to the list of ALSA sound cards
+	asoundCardsPath = "/proc/asound/cards"
+
+	// Matches card header line, i.e. " 1 [Device         ]: USB-Audio - USB Audio Device"
+	asoundCardRe = regexp.MustCompile(`^\s*(\d+)\s+\[(\S+)\s*\]:\s+(.+?)\s+-\s+(.+)$`)
+
+	// Audio outputs built into omxplayer
+	omxAudioDevices = []AudioDevice{
+		{Name: "hdmi", Description: "HDMI"},
+		{Name: "local", Description: "Analog audio jack"},
+		{Name: "both", Description: "HDMI and analog audio jack"},
+	}
+)
+
+type AudioDevice struct {
+	Name        string `json:"name"`             // Device name accepted by /play, i.e. "alsa:hw:CARD=Device"
+	Description string `json:"description"`      // Human readable device name
+	Card        int    `json:"card"`             // ALSA card index
+	Driver      string `json:"driver,omitempty"` // ALSA card driver
+}
+
+// Parse ALSA card list. Every card takes two lines, only the first one is used.
+func parseAsoundCards(input string) []AudioDevice {
+	devices := []AudioDevice{}
+
+	for _, line := range strings.Split(input, "\n") {
+		result := asoundCardRe.FindStringSubmatch(line)
+		if result == nil {
+			continue
+		}
+
+		device := AudioDevice{
+			Name:        "alsa:hw:CARD=" + result[2],
+			Description: strings.TrimSpace(result[4]),
+			Driver:      result[3],
+		}
+		fmt.Sscanf(result[1], "%d", &device.Card)
+
+		devices = append(devices, device)
+	}
+
+	return devices
+}
+
+// List ALSA sound cards of the host
+func alsaAudioDevices() ([]AudioDevice, error) {
+	data, err := ioutil.ReadFile(asoundCardsPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseAsoundCards(string(data)), nil
+}
+
+// Check if audio device name is supported: hdmi, local, both or alsa[:device]
+func validateAudioDevice(name string) error {
+	switch name {
+	case "hdmi", "local", "both", "alsa":
+		return nil
+	}
+
+	if strings.HasPrefix(name, "alsa:") && len(name) > len("alsa:") {
+		return nil
+	}
+
+	return fmt.Errorf("Invalid audio device: %s", name)
+}
+
+// Get ALSA device name from "alsa:<device>" audio device, empty if not an ALSA device
+func alsaDeviceName(name string) string {
+	if name == "alsa" {
+		return "default"
+	}
+	if strings.HasPrefix(name, "alsa:") {
+		return strings.TrimPrefix(name, "alsa:")
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var exampleAsoundCards = ` 0 [ALSA           ]: bcm2835_alsa - bcm2835 ALSA
+                      bcm2835 ALSA
+ 1 [Device         ]: USB-Audio - USB Audio Device
+                      C-Media Electronics Inc. USB Audio Device at usb-3f980000.usb-1.4, full speed
+`
+
+func Test_parseAsoundCards(t *testing.T) {
+	assert.Equal(t, []AudioDevice{}, parseAsoundCards("--- no soundcards ---"))
+
+	assert.Equal(t, []AudioDevice{
+		{Name: "alsa:hw:CARD=ALSA", Description: "bcm2835 ALSA", Card: 0, Driver: "bcm2835_alsa"},
+		{Name: "alsa:hw:CARD=Device", Description: "USB Audio Device", Card: 1, Driver: "USB-Audio"},
+	}, parseAsoundCards(exampleAsoundCards))
+
+	data, err := json.Marshal(parseAsoundCards(exampleAsoundCards)[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"card":0`)
+}
+
+func Test_validateAudioDevice(t *testing.T) {
+	for _, name := range []string{"hdmi", "local", "both", "alsa", "alsa:hw:1,0"} {
+		assert.NoError(t, validateAudioDevice(name))
+	}
+
+	assert.EqualError(t, validateAudioDevice("alsa:"), "Invalid audio device: alsa:")
+	assert.EqualError(t, validateAudioDevice("spdif"), "Invalid audio device: spdif")
+}
+
+func Test_alsaDeviceName(t *testing.T) {
+	assert.Equal(t, "", alsaDeviceName("hdmi"))
+	assert.Equal(t, "default", alsaDeviceName("alsa"))
+	assert.Equal(t, "hw:CARD=Device", alsaDeviceName("alsa:hw:CARD=Device"))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// Windows-1251 (Cyrillic) characters for bytes 0x80-0xFF, 0xFFFD is undefined
+var cp1251Table = [128]rune{
+	0x0402, 0x0403, 0x201A, 0x0453, 0x201E, 0x2026, 0x2020, 0x2021, 0x20AC, 0x2030, 0x0409, 0x2039, 0x040A, 0x040C, 0x040B, 0x040F,
+	0x0452, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014, 0xFFFD, 0x2122, 0x0459, 0x203A, 0x045A, 0x045C, 0x045B, 0x045F,
+	0x00A0, 0x040E, 0x045E, 0x0408, 0x00A4, 0x0490, 0x00A6, 0x00A7, 0x0401, 0x00A9, 0x0404, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x0407,
+	0x00B0, 0x00B1, 0x0406, 0x0456, 0x0491, 0x00B5, 0x00B6, 0x00B7, 0x0451, 0x2116, 0x0454, 0x00BB, 0x0458, 0x0405, 0x0455, 0x0457,
+	0x0410, 0x0411, 0x0412, 0x0413, 0x0414, 0x0415, 0x0416, 0x0417, 0x0418, 0x0419, 0x041A, 0x041B, 0x041C, 0x041D, 0x041E, 0x041F,
+	0x0420, 0x0421, 0x0422, 0x0423, 0x0424, 0x0425, 0x0426, 0x0427, 0x0428, 0x0429, 0x042A, 0x042B, 0x042C, 0x042D, 0x042E, 0x042F,
+	0x0430, 0x0431, 0x0432, 0x0433, 0x0434, 0x0435, 0x0436, 0x0437, 0x0438, 0x0439, 0x043A, 0x043B, 0x043C, 0x043D, 0x043E, 0x043F,
+	0x0440, 0x0441, 0x0442, 0x0443, 0x0444, 0x0445, 0x0446, 0x0447, 0x0448, 0x0449, 0x044A, 0x044B, 0x044C, 0x044D, 0x044E, 0x044F,
+}
+
+// Windows-1252 characters for bytes 0x80-0x9F, the rest of the range matches
+// Latin-1 and Unicode code points
+var cp1252Table = [32]rune{
+	0x20AC, 0xFFFD, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021, 0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0xFFFD, 0x017D, 0xFFFD,
+	0xFFFD, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014, 0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0xFFFD, 0x017E, 0x0178,
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Convert text to UTF-8. Text that is not valid UTF-8 is decoded as
+// Windows-1251 when most letters are Cyrillic, Windows-1252 (Latin-1) otherwise.
+func toUTF8(data []byte) []byte {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	if utf8.Valid(data) {
+		return data
+	}
+
+	table := func(b byte) rune {
+		if b >= 0xA0 {
+			return rune(b)
+		}
+		return cp1252Table[b-0x80]
+	}
+	if isCyrillic(data) {
+		table = func(b byte) rune { return cp1251Table[b-0x80] }
+	}
+
+	out := make([]byte, 0, len(data)*2)
+	for _, b := range data {
+		if b < 0x80 {
+			out = append(out, b)
+			continue
+		}
+		out = append(out, string(table(b))...)
+	}
+	return out
+}
+
+// Guess if 8-bit text is Cyrillic. Accented letters of western languages are
+// rare among ASCII letters, while Cyrillic text is written with high bytes only.
+func isCyrillic(data []byte) bool {
+	ascii, high := 0, 0
+	for _, b := range data {
+		switch {
+		case b >= 0xC0:
+			high++
+		case b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z':
+			ascii++
+		}
+	}
+	return high > ascii
+}
@@ -0,0 +1,958 @@
+package main
+
+// Minimal D-Bus client implementing just enough of the wire protocol to control
+// omxplayer: EXTERNAL authentication, method calls, replies and errors.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// D-Bus message types
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusErrorReply   = 3
+	dbusSignal       = 4
+)
+
+// D-Bus header field codes
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSender      = 7
+	dbusFieldSignature   = 8
+)
+
+const dbusFlagNoReply = 0x1
+
+var (
+	// How long to wait for a method reply
+	dbusCallTimeout = 2 * time.Second
+
+	errDbusClosed = errors.New("D-Bus connection is closed")
+)
+
+const (
+	// Largest accepted message, replies of omxplayer and bus signals are tiny
+	dbusMaxMessage = 1 << 20
+
+	// Deepest accepted nesting of containers, same as the specification
+	dbusMaxDepth = 64
+)
+
+type ObjectPath string
+
+type dbusSignature string
+
+// Variant holds a value along with its D-Bus signature
+type Variant struct {
+	Sig   string
+	Value interface{}
+}
+
+// DbusError is returned when the remote side replies with an error message
+type DbusError struct {
+	Name    string
+	Message string
+}
+
+func (e *DbusError) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+type dbusMessage struct {
+	Type        byte
+	Flags       byte
+	Serial      uint32
+	Path        ObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+	Signature   string
+	Body        []interface{}
+}
+
+// DbusHandler serves incoming method calls. Returned values are sent as reply body.
+type DbusHandler func(msg *dbusMessage) ([]interface{}, error)
+
+type DbusConn struct {
+	Name string // Unique connection name assigned by the bus
+
+	conn      net.Conn
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	serial  uint32
+	calls   map[uint32]chan *dbusMessage
+	handler DbusHandler
+	closed  bool
+}
+
+// Connect to the bus at the given address, i.e. "unix:path=/tmp/bus,guid=..."
+func dbusDial(address string) (*DbusConn, error) {
+	path, err := dbusSocketPath(address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", path, dbusCallTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dbusAuth(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &DbusConn{
+		conn:  conn,
+		calls: map[uint32]chan *dbusMessage{},
+	}
+	go c.read()
+
+	reply, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello")
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if len(reply) > 0 {
+		c.Name, _ = reply[0].(string)
+	}
+
+	return c, nil
+}
+
+// Extract unix socket path from the bus address. Abstract sockets are prefixed with "@".
+func dbusSocketPath(address string) (string, error) {
+	for _, addr := range strings.Split(strings.TrimSpace(address), ";") {
+		if !strings.HasPrefix(addr, "unix:") {
+			continue
+		}
+
+		for _, pair := range strings.Split(addr[5:], ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			value, err := dbusUnescape(kv[1])
+			if err != nil {
+				return "", err
+			}
+
+			switch kv[0] {
+			case "path":
+				return value, nil
+			case "abstract":
+				return "@" + value, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("Unsupported D-Bus address: %q", address)
+}
+
+func dbusUnescape(value string) (string, error) {
+	out := bytes.NewBuffer(nil)
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			out.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("Invalid D-Bus address escape: %q", value)
+		}
+		b, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", err
+		}
+		out.Write(b)
+		i += 2
+	}
+
+	return out.String(), nil
+}
+
+// Perform SASL EXTERNAL authentication using current user id
+func dbusAuth(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(dbusCallTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := conn.Write([]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+		return err
+	}
+
+	// Read byte by byte so nothing past the auth response is consumed
+	line := bytes.NewBuffer(nil)
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return err
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		line.WriteByte(buf[0])
+	}
+
+	if !strings.HasPrefix(line.String(), "OK ") {
+		return fmt.Errorf("D-Bus authentication failed: %s", strings.TrimSpace(line.String()))
+	}
+
+	_, err := conn.Write([]byte("BEGIN\r\n"))
+	return err
+}
+
+// Call a remote method and wait for the reply
+func (c *DbusConn) Call(dest string, path ObjectPath, iface, member string, args ...interface{}) ([]interface{}, error) {
+	msg := &dbusMessage{
+		Type:        dbusMethodCall,
+		Path:        path,
+		Interface:   iface,
+		Member:      member,
+		Destination: dest,
+		Body:        args,
+	}
+
+	reply := make(chan *dbusMessage, 1)
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil, errDbusClosed
+	}
+	c.serial++
+	msg.Serial = c.serial
+	c.calls[msg.Serial] = reply
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.calls, msg.Serial)
+		c.lock.Unlock()
+	}()
+
+	if err := c.send(msg); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(dbusCallTimeout)
+	defer timer.Stop()
+
+	select {
+	case resp, ok := <-reply:
+		if !ok {
+			return nil, errDbusClosed
+		}
+		if resp.Type == dbusErrorReply {
+			dbusErr := &DbusError{Name: resp.ErrorName}
+			if len(resp.Body) > 0 {
+				dbusErr.Message, _ = resp.Body[0].(string)
+			}
+			return nil, dbusErr
+		}
+		return resp.Body, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("D-Bus call %s.%s timed out", iface, member)
+	}
+}
+
+// Request a well-known name on the bus
+func (c *DbusConn) RequestName(name string) error {
+	reply, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "RequestName", name, uint32(0))
+	if err != nil {
+		return err
+	}
+
+	// 1 = primary owner, 4 = already owner
+	if len(reply) == 0 || (reply[0] != uint32(1) && reply[0] != uint32(4)) {
+		return fmt.Errorf("Unable to acquire D-Bus name %s", name)
+	}
+	return nil
+}
+
+// Set handler for incoming method calls
+func (c *DbusConn) Handle(handler DbusHandler) {
+	c.lock.Lock()
+	c.handler = handler
+	c.lock.Unlock()
+}
+
+func (c *DbusConn) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	for serial, reply := range c.calls {
+		close(reply)
+		delete(c.calls, serial)
+	}
+	c.lock.Unlock()
+
+	return c.conn.Close()
+}
+
+func (c *DbusConn) send(msg *dbusMessage) error {
+	data, err := msg.marshal()
+	if err != nil {
+		return err
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	_, err = c.conn.Write(data)
+	return err
+}
+
+// Read incoming messages and dispatch them until connection is closed
+func (c *DbusConn) read() {
+	reader := bufio.NewReader(c.conn)
+	defer c.Close()
+
+	for {
+		msg, err := readDbusMessage(reader)
+		if err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case dbusMethodReturn, dbusErrorReply:
+			// Deliver under the lock so Close does not close the channel mid-send
+			c.lock.Lock()
+			if reply, ok := c.calls[msg.ReplySerial]; ok {
+				select {
+				case reply <- msg:
+				default:
+				}
+			}
+			c.lock.Unlock()
+		case dbusMethodCall:
+			go c.serve(msg)
+		}
+	}
+}
+
+// Run method call handler and send the reply
+func (c *DbusConn) serve(msg *dbusMessage) {
+	c.lock.Lock()
+	handler := c.handler
+	c.serial++
+	serial := c.serial
+	c.lock.Unlock()
+
+	reply := &dbusMessage{
+		Type:        dbusMethodReturn,
+		Serial:      serial,
+		ReplySerial: msg.Serial,
+		Destination: msg.Sender,
+	}
+
+	var err error
+	if handler == nil {
+		err = &DbusError{Name: "org.freedesktop.DBus.Error.UnknownMethod", Message: "No handler"}
+	} else {
+		reply.Body, err = handler(msg)
+	}
+
+	if err != nil {
+		reply.Type = dbusErrorReply
+		reply.ErrorName = "org.freedesktop.DBus.Error.Failed"
+		reply.Body = []interface{}{err.Error()}
+		if dbusErr, ok := err.(*DbusError); ok {
+			reply.ErrorName = dbusErr.Name
+			reply.Body = []interface{}{dbusErr.Message}
+		}
+	}
+
+	if msg.Flags&dbusFlagNoReply == 0 {
+		c.send(reply)
+	}
+}
+
+// Encode message into wire format, always little-endian
+func (m *dbusMessage) marshal() ([]byte, error) {
+	body := newDbusEncoder()
+	sig := ""
+
+	for _, arg := range m.Body {
+		argSig, err := dbusSignatureOf(arg)
+		if err != nil {
+			return nil, err
+		}
+		if err := body.encode(argSig, arg); err != nil {
+			return nil, err
+		}
+		sig += argSig
+	}
+
+	fields := []interface{}{}
+	addField := func(code byte, sig string, value interface{}) {
+		fields = append(fields, []interface{}{code, Variant{sig, value}})
+	}
+
+	if m.Path != "" {
+		addField(dbusFieldPath, "o", m.Path)
+	}
+	if m.Interface != "" {
+		addField(dbusFieldInterface, "s", m.Interface)
+	}
+	if m.Member != "" {
+		addField(dbusFieldMember, "s", m.Member)
+	}
+	if m.ErrorName != "" {
+		addField(dbusFieldErrorName, "s", m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		addField(dbusFieldReplySerial, "u", m.ReplySerial)
+	}
+	if m.Destination != "" {
+		addField(dbusFieldDestination, "s", m.Destination)
+	}
+	if sig != "" {
+		addField(dbusFieldSignature, "g", dbusSignature(sig))
+	}
+
+	header := newDbusEncoder()
+	header.buf.Write([]byte{'l', m.Type, m.Flags, 1})
+	header.encode("u", uint32(body.buf.Len()))
+	header.encode("u", m.Serial)
+	if err := header.encode("a(yv)", fields); err != nil {
+		return nil, err
+	}
+	header.align(8)
+
+	return append(header.buf.Bytes(), body.buf.Bytes()...), nil
+}
+
+func readDbusMessage(r io.Reader) (*dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("Invalid D-Bus byte order: %q", fixed[0])
+	}
+
+	// Lengths come from the peer, do not allocate more than a sane message
+	bodyLen := order.Uint32(fixed[4:8])
+	fieldsLen := order.Uint32(fixed[12:16])
+	if uint64(bodyLen)+uint64(fieldsLen) > dbusMaxMessage {
+		return nil, fmt.Errorf("D-Bus message is too large: %d bytes", uint64(bodyLen)+uint64(fieldsLen))
+	}
+
+	// Header fields array is padded to 8 bytes before the body starts
+	headerLen := 16 + int(fieldsLen)
+	if pad := headerLen % 8; pad != 0 {
+		headerLen += 8 - pad
+	}
+
+	data := make([]byte, headerLen+int(bodyLen))
+	copy(data, fixed)
+	if _, err := io.ReadFull(r, data[16:]); err != nil {
+		return nil, err
+	}
+
+	msg := &dbusMessage{
+		Type:   fixed[1],
+		Flags:  fixed[2],
+		Serial: order.Uint32(fixed[8:12]),
+	}
+
+	header := &dbusDecoder{data: data[:headerLen], pos: 12, order: order}
+	fields, err := header.decode("a(yv)")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range fields.([]interface{}) {
+		pair := field.([]interface{})
+		value := pair[1].(Variant).Value
+
+		switch pair[0].(byte) {
+		case dbusFieldPath:
+			msg.Path, _ = value.(ObjectPath)
+		case dbusFieldInterface:
+			msg.Interface, _ = value.(string)
+		case dbusFieldMember:
+			msg.Member, _ = value.(string)
+		case dbusFieldErrorName:
+			msg.ErrorName, _ = value.(string)
+		case dbusFieldReplySerial:
+			msg.ReplySerial, _ = value.(uint32)
+		case dbusFieldDestination:
+			msg.Destination, _ = value.(string)
+		case dbusFieldSender:
+			msg.Sender, _ = value.(string)
+		case dbusFieldSignature:
+			sig, _ := value.(dbusSignature)
+			msg.Signature = string(sig)
+		}
+	}
+
+	body := &dbusDecoder{data: data[headerLen:], order: order}
+	sig := msg.Signature
+
+	for sig != "" {
+		next, rest, err := dbusNextType(sig)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := body.decode(next)
+		if err != nil {
+			return nil, err
+		}
+
+		msg.Body = append(msg.Body, value)
+		sig = rest
+	}
+
+	return msg, nil
+}
+
+// Infer D-Bus signature of a Go value
+func dbusSignatureOf(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case byte:
+		return "y", nil
+	case bool:
+		return "b", nil
+	case int16:
+		return "n", nil
+	case uint16:
+		return "q", nil
+	case int32:
+		return "i", nil
+	case uint32:
+		return "u", nil
+	case int64:
+		return "x", nil
+	case uint64:
+		return "t", nil
+	case float64:
+		return "d", nil
+	case string:
+		return "s", nil
+	case ObjectPath:
+		return "o", nil
+	case dbusSignature:
+		return "g", nil
+	case Variant:
+		return "v", nil
+	case []string:
+		return "as", nil
+	case []interface{}:
+		sig := "("
+		for _, item := range v {
+			itemSig, err := dbusSignatureOf(item)
+			if err != nil {
+				return "", err
+			}
+			sig += itemSig
+		}
+		return sig + ")", nil
+	}
+
+	return "", fmt.Errorf("Unsupported D-Bus value type: %T", value)
+}
+
+// Split signature into the first complete type and the rest
+func dbusNextType(sig string) (string, string, error) {
+	if sig == "" {
+		return "", "", errors.New("Empty D-Bus signature")
+	}
+
+	switch sig[0] {
+	case 'a':
+		elem, rest, err := dbusNextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return "a" + elem, rest, nil
+	case '(', '{':
+		closing := byte(')')
+		if sig[0] == '{' {
+			closing = '}'
+		}
+
+		depth := 0
+		for i := 0; i < len(sig); i++ {
+			switch sig[i] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				depth--
+				if depth == 0 {
+					if sig[i] != closing {
+						return "", "", fmt.Errorf("Invalid D-Bus signature: %q", sig)
+					}
+					return sig[:i+1], sig[i+1:], nil
+				}
+			}
+		}
+		return "", "", fmt.Errorf("Invalid D-Bus signature: %q", sig)
+	}
+
+	return sig[:1], sig[1:], nil
+}
+
+// Alignment of a type in the wire format
+func dbusAlignment(sig byte) int {
+	switch sig {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 's', 'o', 'a':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 1
+}
+
+type dbusEncoder struct {
+	buf *bytes.Buffer
+}
+
+func newDbusEncoder() *dbusEncoder {
+	return &dbusEncoder{buf: bytes.NewBuffer(nil)}
+}
+
+func (e *dbusEncoder) align(n int) {
+	for e.buf.Len()%n != 0 {
+		e.buf.WriteByte(0)
+	}
+}
+
+func (e *dbusEncoder) write(n int, value interface{}) {
+	e.align(n)
+	binary.Write(e.buf, binary.LittleEndian, value)
+}
+
+func (e *dbusEncoder) writeString(value string) {
+	e.write(4, uint32(len(value)))
+	e.buf.WriteString(value)
+	e.buf.WriteByte(0)
+}
+
+func (e *dbusEncoder) encode(sig string, value interface{}) error {
+	invalid := fmt.Errorf("Cant encode %T as D-Bus type %q", value, sig)
+
+	switch sig[0] {
+	case 'y':
+		v, ok := value.(byte)
+		if !ok {
+			return invalid
+		}
+		e.buf.WriteByte(v)
+	case 'b':
+		v, ok := value.(bool)
+		if !ok {
+			return invalid
+		}
+		n := uint32(0)
+		if v {
+			n = 1
+		}
+		e.write(4, n)
+	case 'n', 'q', 'i', 'u', 'x', 't', 'd':
+		sigOf, err := dbusSignatureOf(value)
+		if err != nil || sigOf != sig {
+			return invalid
+		}
+		e.write(dbusAlignment(sig[0]), value)
+	case 's':
+		v, ok := value.(string)
+		if !ok {
+			return invalid
+		}
+		e.writeString(v)
+	case 'o':
+		v, ok := value.(ObjectPath)
+		if !ok {
+			return invalid
+		}
+		e.writeString(string(v))
+	case 'g':
+		v, ok := value.(dbusSignature)
+		if !ok {
+			return invalid
+		}
+		e.buf.WriteByte(byte(len(v)))
+		e.buf.WriteString(string(v))
+		e.buf.WriteByte(0)
+	case 'v':
+		v, ok := value.(Variant)
+		if !ok {
+			return invalid
+		}
+		if err := e.encode("g", dbusSignature(v.Sig)); err != nil {
+			return err
+		}
+		return e.encode(v.Sig, v.Value)
+	case 'a':
+		var items []interface{}
+		switch v := value.(type) {
+		case []string:
+			for _, item := range v {
+				items = append(items, item)
+			}
+		case []interface{}:
+			items = v
+		default:
+			return invalid
+		}
+
+		// Array length excludes padding before the first element
+		e.write(4, uint32(0))
+		lenPos := e.buf.Len() - 4
+		e.align(dbusAlignment(sig[1]))
+		start := e.buf.Len()
+
+		for _, item := range items {
+			if err := e.encode(sig[1:], item); err != nil {
+				return err
+			}
+		}
+
+		binary.LittleEndian.PutUint32(e.buf.Bytes()[lenPos:], uint32(e.buf.Len()-start))
+	case '(', '{':
+		v, ok := value.([]interface{})
+		if !ok {
+			return invalid
+		}
+		e.align(8)
+
+		inner := sig[1 : len(sig)-1]
+		for _, item := range v {
+			next, rest, err := dbusNextType(inner)
+			if err != nil {
+				return err
+			}
+			if err := e.encode(next, item); err != nil {
+				return err
+			}
+			inner = rest
+		}
+	default:
+		return invalid
+	}
+
+	return nil
+}
+
+type dbusDecoder struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+	depth int // Nesting of containers being decoded
+}
+
+var errDbusShortRead = errors.New("D-Bus message is truncated")
+
+func (d *dbusDecoder) align(n int) {
+	for d.pos%n != 0 {
+		d.pos++
+	}
+}
+
+func (d *dbusDecoder) read(n int) ([]byte, error) {
+	if d.pos+n > len(d.data) {
+		return nil, errDbusShortRead
+	}
+	data := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return data, nil
+}
+
+func (d *dbusDecoder) readUint32() (uint32, error) {
+	d.align(4)
+	data, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(data), nil
+}
+
+func (d *dbusDecoder) readString(size int) (string, error) {
+	data, err := d.read(size + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(data[:size]), nil
+}
+
+// Decode a single complete type. Signatures come from the peer, so they are
+// validated before anything is indexed.
+func (d *dbusDecoder) decode(sig string) (interface{}, error) {
+	if next, rest, err := dbusNextType(sig); err != nil || next != sig || rest != "" {
+		return nil, fmt.Errorf("Invalid D-Bus signature: %q", sig)
+	}
+
+	switch sig[0] {
+	case 'v', 'a', '(', '{':
+		d.depth++
+		defer func() { d.depth-- }()
+		if d.depth > dbusMaxDepth {
+			return nil, errors.New("D-Bus message is nested too deep")
+		}
+	}
+
+	switch sig[0] {
+	case 'y':
+		data, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return data[0], nil
+	case 'b':
+		v, err := d.readUint32()
+		return v == 1, err
+	case 'n', 'q':
+		d.align(2)
+		data, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'n' {
+			return int16(d.order.Uint16(data)), nil
+		}
+		return d.order.Uint16(data), nil
+	case 'i':
+		v, err := d.readUint32()
+		return int32(v), err
+	case 'u':
+		return d.readUint32()
+	case 'x', 't', 'd':
+		d.align(8)
+		data, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		v := d.order.Uint64(data)
+		switch sig[0] {
+		case 'x':
+			return int64(v), nil
+		case 'd':
+			return math.Float64frombits(v), nil
+		}
+		return v, nil
+	case 's', 'o':
+		size, err := d.readUint32()
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.readString(int(size))
+		if sig[0] == 'o' {
+			return ObjectPath(s), err
+		}
+		return s, err
+	case 'g':
+		data, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.readString(int(data[0]))
+		return dbusSignature(s), err
+	case 'v':
+		sigValue, err := d.decode("g")
+		if err != nil {
+			return nil, err
+		}
+		inner := string(sigValue.(dbusSignature))
+		if inner == "" {
+			return nil, errors.New("Empty D-Bus variant signature")
+		}
+		value, err := d.decode(inner)
+		return Variant{inner, value}, err
+	case 'a':
+		size, err := d.readUint32()
+		if err != nil {
+			return nil, err
+		}
+		d.align(dbusAlignment(sig[1]))
+		end := d.pos + int(size)
+
+		if sig == "as" {
+			items := []string{}
+			for d.pos < end {
+				item, err := d.decode("s")
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item.(string))
+			}
+			return items, nil
+		}
+
+		items := []interface{}{}
+		for d.pos < end {
+			start := d.pos
+			item, err := d.decode(sig[1:])
+			if err != nil {
+				return nil, err
+			}
+			// Empty structs take no space, the array would never end
+			if d.pos == start {
+				return nil, errors.New("Invalid D-Bus array element")
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case '(', '{':
+		d.align(8)
+
+		items := []interface{}{}
+		inner := sig[1 : len(sig)-1]
+		for inner != "" {
+			next, rest, err := dbusNextType(inner)
+			if err != nil {
+				return nil, err
+			}
+			item, err := d.decode(next)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			inner = rest
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported D-Bus type: %q", sig)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_dbusSocketPath(t *testing.T) {
+	path, err := dbusSocketPath("unix:abstract=/tmp/dbus-XaDHxMmlT3,guid=bd5e7a1bd6b1f6f0bd0a7a1a5a4e3c9f")
+	assert.NoError(t, err)
+	assert.Equal(t, "@/tmp/dbus-XaDHxMmlT3", path)
+
+	path, err = dbusSocketPath("tcp:host=localhost;unix:path=/run/user/1000/bus%2c1")
+	assert.NoError(t, err)
+	assert.Equal(t, "/run/user/1000/bus,1", path)
+
+	_, err = dbusSocketPath("tcp:host=localhost,port=1234")
+	assert.Error(t, err)
+}
+
+func Test_dbusNextType(t *testing.T) {
+	examples := [][]string{
+		{"sx", "s", "x"},
+		{"a(yv)s", "a(yv)", "s"},
+		{"a{sv}", "a{sv}", ""},
+		{"(i(ss))as", "(i(ss))", "as"},
+		{"aas", "aas", ""},
+	}
+
+	for _, example := range examples {
+		next, rest, err := dbusNextType(example[0])
+		assert.NoError(t, err)
+		assert.Equal(t, example[1], next)
+		assert.Equal(t, example[2], rest)
+	}
+
+	_, _, err := dbusNextType("(ss")
+	assert.Error(t, err)
+}
+
+func Test_dbusMessageMarshal(t *testing.T) {
+	msg := &dbusMessage{
+		Type:        dbusMethodCall,
+		Serial:      42,
+		Path:        "/org/mpris/MediaPlayer2",
+		Interface:   "org.mpris.MediaPlayer2.Player",
+		Member:      "SetPosition",
+		Destination: "org.mpris.MediaPlayer2.omxplayer",
+		Body: []interface{}{
+			ObjectPath("/not/used"),
+			int64(30000000),
+			byte(1),
+			true,
+			int32(-5),
+			uint32(7),
+			1.5,
+			"hello",
+			[]string{"0:eng:English:aac:active", "1:jpn:Japanese:aac:"},
+			Variant{"s", "value"},
+			[]interface{}{int16(-1), uint16(2), uint64(3)},
+		},
+	}
+
+	data, err := msg.marshal()
+	assert.NoError(t, err)
+
+	decoded, err := readDbusMessage(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(dbusMethodCall), decoded.Type)
+	assert.Equal(t, uint32(42), decoded.Serial)
+	assert.Equal(t, msg.Path, decoded.Path)
+	assert.Equal(t, msg.Interface, decoded.Interface)
+	assert.Equal(t, msg.Member, decoded.Member)
+	assert.Equal(t, msg.Destination, decoded.Destination)
+	assert.Equal(t, "oxybiudsasv(nqt)", decoded.Signature)
+	assert.Equal(t, msg.Body, decoded.Body)
+}
+
+func Test_dbusMessageMarshalError(t *testing.T) {
+	msg := &dbusMessage{
+		Type: dbusMethodCall,
+		Body: []interface{}{struct{}{}},
+	}
+
+	_, err := msg.marshal()
+	assert.Error(t, err)
+}
+
+func Test_dbusDecodeMalformed(t *testing.T) {
+	decode := func(sig string, data []byte) error {
+		d := &dbusDecoder{data: data, order: binary.LittleEndian}
+		_, err := d.decode(sig)
+		return err
+	}
+
+	// Variant signatures come from the peer
+	for _, sig := range []string{"a", "(", "{", "a{", "(}", "ss"} {
+		data := append([]byte{byte(len(sig))}, sig...)
+		data = append(data, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+		assert.Error(t, decode("v", data), sig)
+	}
+
+	assert.EqualError(t, decode("a()", []byte{8, 0, 0, 0, 0, 0, 0, 0}), "Invalid D-Bus array element")
+	assert.EqualError(t, decode("v", bytes.Repeat([]byte{1, 'v', 0}, 100)), "D-Bus message is nested too deep")
+
+	// Lengths are checked before anything is allocated
+	header := []byte{'l', dbusMethodCall, 0, 1, 0xff, 0xff, 0xff, 0xff, 1, 0, 0, 0, 0, 0, 0, 0}
+	_, err := readDbusMessage(bytes.NewReader(header))
+	assert.EqualError(t, err, "D-Bus message is too large: 4294967295 bytes")
+
+	header[0] = 'x'
+	_, err = readDbusMessage(bytes.NewReader(header))
+	assert.Error(t, err)
+}
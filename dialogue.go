@@ -0,0 +1,326 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// How often the library is scanned for changed subtitles
+	dialogueRefreshInterval = time.Minute
+
+	// Number of cues included before and after a match
+	dialogueContext = 2
+
+	// Markup removed from indexed subtitle text
+	dialogueTagRegexp = regexp.MustCompile(`<[^>]*>|\{[^}]*\}`)
+)
+
+// Single subtitle cue with parsed timing
+type dialogueCue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Indexed SRT sidecar of a media file
+type dialogueFile struct {
+	media     string // Media file path relative to media directory
+	subtitles string // Subtitles path relative to media directory
+	size      int64
+	modTime   time.Time
+	cues      []dialogueCue
+}
+
+// DialogueMatch is a subtitle cue matching the search query
+type DialogueMatch struct {
+	File      string   `json:"file"`      // Media file relative to media directory, empty if unknown
+	Subtitles string   `json:"subtitles"` // Subtitles file relative to media directory
+	Position  string   `json:"position"`  // Cue start, i.e. "01:12:30"
+	Seconds   uint64   `json:"seconds"`   // Cue start in seconds
+	Text      string   `json:"text"`      // Matching cue text
+	Before    []string `json:"before"`    // Text of preceding cues
+	After     []string `json:"after"`     // Text of following cues
+}
+
+// DialogueIndex keeps text of SRT sidecars in the library for searching.
+// Only subtitles that changed since the last refresh are parsed again.
+type DialogueIndex struct {
+	sync.Mutex
+	files map[string]*dialogueFile // Indexed files by full subtitles path
+	scan  sync.Mutex               // Held while the library is scanned
+}
+
+// Names of files and subdirectories of a scanned directory
+type dialogueListing struct {
+	files []os.FileInfo            // Regular files
+	dirs  map[string][]os.FileInfo // Regular files of subdirectories by name
+}
+
+// Refresh the index in the background, starting right away
+func (d *DialogueIndex) Watch(interval time.Duration) {
+	d.Refresh()
+	for range time.Tick(interval) {
+		d.Refresh()
+	}
+}
+
+// Scan the library and replace the index. Searches are not blocked while the
+// library is scanned.
+func (d *DialogueIndex) Refresh() {
+	d.scan.Lock()
+	defer d.scan.Unlock()
+
+	d.Lock()
+	previous := d.files
+	d.Unlock()
+
+	files := map[string]*dialogueFile{}
+	d.scanDir(MediaPath, previous, files)
+
+	d.Lock()
+	d.files = files
+	d.Unlock()
+}
+
+// Index subtitles of media files in the directory and its subdirectories.
+// Every directory is listed once, the listing is returned so the parent can
+// match its media files with subtitles in "Subs" folders.
+func (d *DialogueIndex) scanDir(dir string, previous, files map[string]*dialogueFile) dialogueListing {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return dialogueListing{}
+	}
+
+	listing := dialogueListing{dirs: map[string][]os.FileInfo{}}
+	subfolders := map[string]dialogueListing{}
+	for _, entry := range entries {
+		switch {
+		case entry.IsDir() && !strings.HasPrefix(entry.Name(), "."):
+			sub := d.scanDir(filepath.Join(dir, entry.Name()), previous, files)
+			listing.dirs[entry.Name()] = sub.files
+			subfolders[entry.Name()] = sub
+		case entry.Mode().IsRegular():
+			listing.files = append(listing.files, entry)
+		}
+	}
+
+	add := func(path string, info os.FileInfo, media string) {
+		if strings.ToLower(filepath.Ext(path)) != ".srt" {
+			return
+		}
+		if file := loadDialogue(path, info, previous); file != nil {
+			rel, _ := filepath.Rel(MediaPath, path)
+			file.media = media
+			file.subtitles = rel
+			files[path] = file
+		}
+	}
+
+	// Sidecars and "Subs/<media name>" folders belong to the media file
+	media := []os.FileInfo{}
+	for _, entry := range listing.files {
+		if !omxCanPlay(entry.Name()) {
+			continue
+		}
+		media = append(media, entry)
+
+		rel, _ := filepath.Rel(MediaPath, filepath.Join(dir, entry.Name()))
+		base := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+
+		for _, sub := range listing.files {
+			if _, ok := sidecarSuffix(strings.TrimSuffix(sub.Name(), filepath.Ext(sub.Name())), base); ok {
+				add(filepath.Join(dir, sub.Name()), sub, rel)
+			}
+		}
+
+		for name, folder := range subfolders {
+			if !containsFold(subtitleFolders, name) {
+				continue
+			}
+			for inner, subs := range folder.dirs {
+				if !strings.EqualFold(inner, base) {
+					continue
+				}
+				for _, sub := range subs {
+					add(filepath.Join(dir, name, inner, sub.Name()), sub, rel)
+				}
+			}
+		}
+	}
+
+	// Subtitles directly in "Subs" folders belong to the media file they are named
+	// after, or to the only media file of the directory. Others, i.e. in a season
+	// folder, are indexed without media.
+	if len(media) == 0 {
+		return listing
+	}
+	for name, folder := range subfolders {
+		if !containsFold(subtitleFolders, name) {
+			continue
+		}
+		for _, sub := range folder.files {
+			add(filepath.Join(dir, name, sub.Name()), sub, subtitlesMedia(dir, sub.Name(), media))
+		}
+	}
+
+	return listing
+}
+
+// Media file relative to media directory that subtitles in a "Subs" folder of
+// the directory belong to, empty if it is unknown
+func subtitlesMedia(dir, name string, media []os.FileInfo) string {
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+
+	// Longest name wins, "Movie.Extended.srt" belongs to "Movie.Extended.mkv"
+	match, matchLen := "", 0
+	for _, entry := range media {
+		base := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if _, ok := sidecarSuffix(stem, base); ok && len(base) > matchLen {
+			match, matchLen = entry.Name(), len(base)
+		}
+	}
+	if match == "" && len(media) == 1 {
+		match = media[0].Name()
+	}
+	if match == "" {
+		return ""
+	}
+
+	rel, _ := filepath.Rel(MediaPath, filepath.Join(dir, match))
+	return rel
+}
+
+// Get subtitles from the previous index, parsing the file if it is new or has changed
+func loadDialogue(path string, info os.FileInfo, previous map[string]*dialogueFile) *dialogueFile {
+	if file, ok := previous[path]; ok && file.size == info.Size() && file.modTime.Equal(info.ModTime()) {
+		// Copy, the previous index may still be searched
+		reused := *file
+		return &reused
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	return &dialogueFile{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		cues:    parseSRT(string(toUTF8(data))),
+	}
+}
+
+// Find cues containing the query, case-insensitive. At most limit matches are returned.
+func (d *DialogueIndex) Search(query string, limit int) []DialogueMatch {
+	query = strings.ToLower(strings.Join(strings.Fields(query), " "))
+	matches := []DialogueMatch{}
+
+	d.Lock()
+	defer d.Unlock()
+
+	files := make([]*dialogueFile, 0, len(d.files))
+	for _, file := range d.files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].media != files[j].media {
+			return naturalLess(files[i].media, files[j].media)
+		}
+		return files[i].subtitles < files[j].subtitles
+	})
+
+	for _, file := range files {
+		for i, cue := range file.cues {
+			if !strings.Contains(strings.ToLower(cue.Text), query) {
+				continue
+			}
+			if len(matches) == limit {
+				return matches
+			}
+
+			seconds := uint64(cue.Start / time.Second)
+			matches = append(matches, DialogueMatch{
+				File:      file.media,
+				Subtitles: file.subtitles,
+				Position:  durationFromSeconds(seconds),
+				Seconds:   seconds,
+				Text:      cue.Text,
+				Before:    cueTexts(file.cues[maxInt(i-dialogueContext, 0):i]),
+				After:     cueTexts(file.cues[i+1 : minInt(i+1+dialogueContext, len(file.cues))]),
+			})
+		}
+	}
+
+	return matches
+}
+
+func cueTexts(cues []dialogueCue) []string {
+	texts := make([]string, len(cues))
+	for i, cue := range cues {
+		texts[i] = cue.Text
+	}
+	return texts
+}
+
+// Parse SRT subtitles. Markup is removed and cue lines are joined with spaces.
+func parseSRT(text string) []dialogueCue {
+	cues := []dialogueCue{}
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		m := srtTimingRegexp.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+
+		body := []string{}
+		for i++; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+			if line := strings.TrimSpace(dialogueTagRegexp.ReplaceAllString(lines[i], "")); line != "" {
+				body = append(body, line)
+			}
+		}
+		if len(body) == 0 {
+			continue
+		}
+
+		cues = append(cues, dialogueCue{
+			Start: srtTime(m[1:5]),
+			End:   srtTime(m[5:9]),
+			Text:  strings.Join(body, " "),
+		})
+	}
+
+	return cues
+}
+
+// Convert hours, minutes, seconds and milliseconds of SRT timing into duration
+func srtTime(parts []string) time.Duration {
+	return time.Duration(atoi(parts[0]))*time.Hour +
+		time.Duration(atoi(parts[1]))*time.Minute +
+		time.Duration(atoi(parts[2]))*time.Second +
+		time.Duration(atoi(parts[3]))*time.Millisecond
+}
+
+// Check if the list contains the name, case-insensitive
+func containsFold(list []string, name string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseSRT(t *testing.T) {
+	srt := "\xEF\xBB\xBF1\r\n00:00:01,000 --> 00:00:02,500\r\n<i>Hello</i>\r\nthere\r\n\r\n" +
+		"2\r\n01:02:03,004 --> 01:02:05,000\r\n{\\an8}General Kenobi\r\n\r\n3\r\n00:10:00,000 --> 00:10:01,000\r\n\r\n"
+
+	assert.Equal(t, []dialogueCue{
+		{Start: time.Second, End: 2500 * time.Millisecond, Text: "Hello there"},
+		{Start: time.Hour + 2*time.Minute + 3004*time.Millisecond, End: time.Hour + 2*time.Minute + 5*time.Second, Text: "General Kenobi"},
+	}, parseSRT(string(toUTF8([]byte(srt)))))
+}
+
+func Test_DialogueIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { MediaPath = path }(MediaPath)
+	MediaPath = dir
+
+	os.MkdirAll(filepath.Join(dir, "Show", "Subs", "S01E01"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".hidden"), 0755)
+	for name, text := range map[string]string{
+		"Movie.mkv":                "",
+		"Movie.srt":                "1\n00:00:01,000 --> 00:00:02,000\nOne\n\n2\n00:00:03,000 --> 00:00:04,000\nTwo\n\n3\n00:00:05,000 --> 00:00:06,000\nThree\n",
+		"Movie.vtt":                "WEBVTT\n\n00:01.000 --> 00:02.000\nTwo\n",
+		"Orphan.srt":               "1\n00:00:01,000 --> 00:00:02,000\nTwo\n",
+		"Show/S01E01.mp4":          "",
+		"Show/Subs/Rus.srt":        "1\n00:00:10,000 --> 00:00:11,000\n\xC4\xE2\xE0\n",
+		"Show/Subs/S01E01/Eng.srt": "1\n00:00:12,000 --> 00:00:13,000\nHi there\n",
+		"Movie 2.srt":              "1\n00:00:12,000 --> 00:00:13,000\nHi there\n",
+		".hidden/Secret.mkv":       "",
+		".hidden/Secret.srt":       "1\n00:00:01,000 --> 00:00:02,000\nTwo\n",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+	}
+
+	index := &DialogueIndex{}
+	assert.Empty(t, index.Search("two", 10))
+
+	index.Refresh()
+	assert.Equal(t, []DialogueMatch{{
+		File:      "Movie.mkv",
+		Subtitles: "Movie.srt",
+		Position:  "00:00:03",
+		Seconds:   3,
+		Text:      "Two",
+		Before:    []string{"One"},
+		After:     []string{"Three"},
+	}}, index.Search("two", 10))
+
+	// Legacy encodings are indexed as UTF-8
+	matches := index.Search("два", 10)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, "Show/S01E01.mp4", matches[0].File)
+
+	// Subtitles of other media files sharing the name prefix are not indexed
+	matches = index.Search("hi there", 10)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, "Show/Subs/S01E01/Eng.srt", matches[0].Subtitles)
+
+	assert.Len(t, index.Search("o", 1), 1)
+	assert.Len(t, index.Search("o", 10), 2)
+
+	// Changed and removed files are picked up by the next refresh
+	ioutil.WriteFile(filepath.Join(dir, "Movie.srt"), []byte("1\n00:00:07,000 --> 00:00:08,000\nFour\n"), 0644)
+	os.Remove(filepath.Join(dir, "Show", "Subs", "Rus.srt"))
+	assert.Len(t, index.Search("два", 10), 1)
+
+	// Searches are served from the previous index during refresh
+	done := make(chan bool)
+	go func() {
+		index.Search("два", 10)
+		done <- true
+	}()
+	index.Refresh()
+	<-done
+
+	assert.Empty(t, index.Search("two", 10))
+	assert.Empty(t, index.Search("два", 10))
+	assert.Len(t, index.Search("four", 10), 1)
+}
+
+func Test_DialogueIndexSharedSubs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { MediaPath = path }(MediaPath)
+	MediaPath = dir
+
+	os.MkdirAll(filepath.Join(dir, "Season 1", "Subs"), 0755)
+	os.MkdirAll(filepath.Join(dir, "Movie", "Subs"), 0755)
+	for name, text := range map[string]string{
+		"Season 1/S01E01.mkv":         "",
+		"Season 1/S01E02.mkv":         "",
+		"Season 1/Subs/S01E01.en.srt": "1\n00:00:01,000 --> 00:00:02,000\nFirst episode\n",
+		"Season 1/Subs/S01E02.en.srt": "1\n00:00:01,000 --> 00:00:02,000\nSecond episode\n",
+		"Season 1/Subs/English.srt":   "1\n00:00:01,000 --> 00:00:02,000\nUnknown episode\n",
+		"Movie/Movie.mkv":             "",
+		"Movie/Subs/English.srt":      "1\n00:00:01,000 --> 00:00:02,000\nOnly movie\n",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+	}
+
+	index := &DialogueIndex{}
+	index.Refresh()
+
+	// Subtitles are attributed to the episode they are named after
+	matches := index.Search("episode", 10)
+	assert.Len(t, matches, 3)
+	files := map[string]string{}
+	for _, match := range matches {
+		files[match.Text] = match.File
+	}
+	assert.Equal(t, map[string]string{
+		"First episode":   "Season 1/S01E01.mkv",
+		"Second episode":  "Season 1/S01E02.mkv",
+		"Unknown episode": "",
+	}, files)
+
+	// Folder of a single media file belongs to it
+	matches = index.Search("only movie", 10)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, "Movie/Movie.mkv", matches[0].File)
+}
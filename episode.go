@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// Season and episode in "S05E01" or "1x02" format
+	RegexSeasonEpisode = regexp.MustCompile(`(?i)\bS(\d{1,2})[ ._-]?E(\d{1,3})|\b(\d{1,2})x(\d{2,3})\b`)
+
+	// Episode without season, i.e. "Episode 2" or "Ep.02"
+	RegexEpisodeNumber = regexp.MustCompile(`(?i)\b(?:Episode|Ep)[ ._-]?(\d{1,3})\b`)
+
+	// Season folder, i.e. "Season 1" or "S01"
+	RegexSeasonFolder = regexp.MustCompile(`(?i)^(?:Season|Series|S)[ ._-]?(\d{1,2})$`)
+)
+
+// Episode identifies an episode of a TV series
+type Episode struct {
+	Season  int `json:"season"`  // Season number, 0 if unknown
+	Episode int `json:"episode"` // Episode number
+}
+
+func (e Episode) Less(other Episode) bool {
+	if e.Season != other.Season {
+		return e.Season < other.Season
+	}
+	return e.Episode < other.Episode
+}
+
+// Parse season and episode numbers from the file path. Season of files named
+// only with episode number is taken from the season folder.
+func parseEpisode(path string) (Episode, bool) {
+	name := filepath.Base(path)
+
+	if m := RegexSeasonEpisode.FindStringSubmatch(name); m != nil {
+		if m[1] != "" {
+			return Episode{Season: atoi(m[1]), Episode: atoi(m[2])}, true
+		}
+		return Episode{Season: atoi(m[3]), Episode: atoi(m[4])}, true
+	}
+
+	if m := RegexEpisodeNumber.FindStringSubmatch(name); m != nil {
+		return Episode{Season: folderSeason(filepath.Dir(path)), Episode: atoi(m[1])}, true
+	}
+
+	return Episode{}, false
+}
+
+// Get season number from the folder name, 0 if it's not a season folder
+func folderSeason(dir string) int {
+	if m := RegexSeasonFolder.FindStringSubmatch(filepath.Base(dir)); m != nil {
+		return atoi(m[1])
+	}
+	return 0
+}
+
+func atoi(value string) int {
+	n, _ := strconv.Atoi(value)
+	return n
+}
+
+// Find the episode following the given one. Looks in the same folder first,
+// then in sibling season folders for the first episode of the next season.
+func findNextEpisode(path string) (string, Episode, bool) {
+	current, ok := parseEpisode(path)
+	if !ok {
+		return "", Episode{}, false
+	}
+
+	dir := filepath.Dir(path)
+
+	// Same folder may contain multiple seasons
+	next, episode, ok := firstEpisode(dir, func(e Episode) bool {
+		return current.Less(e) && e.Season <= current.Season+1
+	})
+	if ok {
+		return next, episode, true
+	}
+
+	siblings, err := ioutil.ReadDir(filepath.Dir(dir))
+	if err != nil {
+		return "", Episode{}, false
+	}
+
+	for _, sibling := range siblings {
+		siblingDir := filepath.Join(filepath.Dir(dir), sibling.Name())
+		if !sibling.IsDir() || siblingDir == dir {
+			continue
+		}
+
+		next, episode, ok := firstEpisode(siblingDir, func(e Episode) bool {
+			return e.Season == current.Season+1
+		})
+		if ok {
+			return next, episode, true
+		}
+	}
+
+	return "", Episode{}, false
+}
+
+// Find the earliest episode in the folder matching the filter
+func firstEpisode(dir string, filter func(Episode) bool) (string, Episode, bool) {
+	files, err := scanMediaFiles(dir, false)
+	if err != nil {
+		return "", Episode{}, false
+	}
+
+	var (
+		found   string
+		episode Episode
+	)
+
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+
+		e, ok := parseEpisode(path)
+		if !ok || !filter(e) {
+			continue
+		}
+		if found == "" || e.Less(episode) {
+			found = path
+			episode = e
+		}
+	}
+
+	return found, episode, found != ""
+}
+
+// NextUp is the episode offered to play after the current one has finished
+type NextUp struct {
+	sync.Mutex
+	file    string      // Path relative to the media directory
+	episode Episode     // Season and episode of the file
+	playAt  time.Time   // Time of automatic playback, zero if disabled
+	timer   *time.Timer // Automatic playback timer
+}
+
+// Offer the file to play next. With non-zero delay the play function is
+// called after the delay unless the offer is cancelled.
+func (n *NextUp) Offer(file string, episode Episode, delay time.Duration, play func()) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.cancel()
+	n.file = file
+	n.episode = episode
+
+	if delay > 0 {
+		n.playAt = time.Now().Add(delay)
+		n.timer = time.AfterFunc(delay, play)
+	}
+}
+
+// Get offered file, its episode and time left until automatic playback
+func (n *NextUp) Get() (string, Episode, time.Duration) {
+	n.Lock()
+	defer n.Unlock()
+
+	var left time.Duration
+	if !n.playAt.IsZero() {
+		left = time.Until(n.playAt)
+		if left < 0 {
+			left = 0
+		}
+	}
+
+	return n.file, n.episode, left
+}
+
+// Withdraw the offer and return the offered file
+func (n *NextUp) Take() string {
+	n.Lock()
+	defer n.Unlock()
+
+	file := n.file
+	n.cancel()
+	return file
+}
+
+// Withdraw the offer
+func (n *NextUp) Cancel() {
+	n.Take()
+}
+
+// Caller must hold the lock
+func (n *NextUp) cancel() {
+	if n.timer != nil {
+		n.timer.Stop()
+		n.timer = nil
+	}
+	n.file = ""
+	n.episode = Episode{}
+	n.playAt = time.Time{}
+}
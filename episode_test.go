@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseEpisode(t *testing.T) {
+	examples := map[string]Episode{
+		"Show.Name.S05E01.720p.HDTV.x264.mkv":  {5, 1},
+		"Show Name - s01e12 - Title.mp4":       {1, 12},
+		"Show.Name.S02.E03.mkv":                {2, 3},
+		"Show Name 1x02.avi":                   {1, 2},
+		"Shows/Season 3/Episode 2.mkv":         {3, 2},
+		"Shows/Show Name/Ep.07.mkv":            {0, 7},
+		"Shows/S04/Show Name Episode 11.mkv":   {4, 11},
+		"Show.Name.S01E09E10.1080p.WEBRip.mkv": {1, 9},
+	}
+
+	for path, expected := range examples {
+		episode, ok := parseEpisode(path)
+		assert.True(t, ok, path)
+		assert.Equal(t, expected, episode, path)
+	}
+
+	for _, path := range []string{"Movie.Name.2010.1920x1080.mkv", "Movie Name (2014).mp4", "Episodes.mkv"} {
+		_, ok := parseEpisode(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func Test_findNextEpisode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		"Show/Season 1/Show.S01E01.mkv",
+		"Show/Season 1/Show.S01E02.mkv",
+		"Show/Season 1/Show.S01E10.mkv",
+		"Show/Season 1/Show.S01E02.srt",
+		"Show/Season 2/Show.S02E02.mkv",
+		"Show/Season 2/Show.S02E01.mkv",
+		"Other/Episode 1.mkv",
+		"Other/Episode 3.mkv",
+	}
+	for _, file := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, file), []byte("data"), 0644)
+	}
+
+	examples := map[string]string{
+		"Show/Season 1/Show.S01E01.mkv": "Show/Season 1/Show.S01E02.mkv",
+		"Show/Season 1/Show.S01E02.mkv": "Show/Season 1/Show.S01E10.mkv",
+		"Show/Season 1/Show.S01E10.mkv": "Show/Season 2/Show.S02E01.mkv",
+		"Other/Episode 1.mkv":           "Other/Episode 3.mkv",
+	}
+	for current, expected := range examples {
+		next, _, ok := findNextEpisode(filepath.Join(dir, current))
+		assert.True(t, ok, current)
+		assert.Equal(t, filepath.Join(dir, expected), next)
+	}
+
+	for _, current := range []string{"Show/Season 2/Show.S02E02.mkv", "Other/Episode 3.mkv"} {
+		_, _, ok := findNextEpisode(filepath.Join(dir, current))
+		assert.False(t, ok, current)
+	}
+}
+
+func Test_NextUp(t *testing.T) {
+	n := &NextUp{}
+	played := make(chan bool, 1)
+
+	n.Offer("Show.S01E02.mkv", Episode{1, 2}, 0, func() { played <- true })
+	file, episode, left := n.Get()
+	assert.Equal(t, "Show.S01E02.mkv", file)
+	assert.Equal(t, Episode{1, 2}, episode)
+	assert.Equal(t, time.Duration(0), left)
+
+	n.Offer("Show.S01E03.mkv", Episode{1, 3}, 10*time.Millisecond, func() { played <- true })
+	_, _, left = n.Get()
+	assert.True(t, left > 0)
+
+	select {
+	case <-played:
+	case <-time.After(time.Second):
+		t.Fatal("next episode was not played")
+	}
+
+	// Cancelled offer does not play
+	n.Offer("Show.S01E04.mkv", Episode{1, 4}, 10*time.Millisecond, func() { played <- true })
+	n.Cancel()
+	file, _, _ = n.Get()
+	assert.Equal(t, "", file)
+
+	select {
+	case <-played:
+		t.Fatal("cancelled episode was played")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
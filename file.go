@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -13,6 +14,16 @@ func fileExists(path string) bool {
 	return err == nil
 }
 
+// Returns true if the file was deleted. Files in missing directories are not
+// reported, they may be on a drive that is not mounted.
+func fileRemoved(path string) bool {
+	if !fileExists(filepath.Dir(path)) {
+		return false
+	}
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
+}
+
 // Scan given path for all directories and matching video files.
 // If nothing was found it will return an empty slice.
 func scanPath(path string) []FileEntry {
@@ -23,6 +34,20 @@ func scanPath(path string) []FileEntry {
 		return entries
 	}
 
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+
+	// Multi-part movies are listed once, by their first part
+	stacks := groupStacks(names)
+	stacked := map[string]bool{}
+	for _, parts := range stacks {
+		for _, part := range parts[1:] {
+			stacked[part] = true
+		}
+	}
+
 	for _, file := range files {
 		entry := FileEntry{
 			Filename: file.Name(),
@@ -34,44 +59,129 @@ func scanPath(path string) []FileEntry {
 			continue
 		}
 
+		if !file.IsDir() {
+			if stacked[file.Name()] {
+				continue
+			}
+			entry.Parts = stacks[file.Name()]
+		}
+
 		entries = append(entries, entry)
 	}
 
 	return entries
 }
 
-// Convert media filename to regular title
-func fileToTitle(name string) string {
-	// Remove file extension from name
-	name = strings.Replace(name, filepath.Ext(name), "", 1)
+// Find playable files in the directory, optionally including subdirectories.
+// Returned paths are relative to the directory and sorted in natural order.
+func scanMediaFiles(dir string, recursive bool) ([]string, error) {
+	files := []string{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path != dir && (!recursive || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if omxCanPlay(info.Name()) {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	// Replace all dots with white space
-	name = strings.Replace(name, ".", " ", -1)
+	sort.Slice(files, func(i, j int) bool {
+		return naturalLess(files[i], files[j])
+	})
 
-	// Replace parenteses and brackets
-	name = RegexBrackets.ReplaceAllString(name, "")
+	return skipStackedParts(files), nil
+}
 
-	// Check if name has a typical torrent format: "name year format etc"
-	pos := RegexYear.FindStringIndex(name)
-	if len(pos) > 0 {
-		name = name[0:pos[0]]
-	} else {
-		// Check if its an episode, i.e. "S##E##"
-		pos = RegexEpisode.FindStringIndex(name)
+// Remove all but the first part of multi-part movies, following parts are
+// played automatically
+func skipStackedParts(files []string) []string {
+	dirs := map[string][]string{}
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		dirs[dir] = append(dirs[dir], filepath.Base(file))
+	}
 
-		if len(pos) > 0 {
-			name = name[0:pos[0]]
+	stacked := map[string]bool{}
+	for dir, names := range dirs {
+		for _, parts := range groupStacks(names) {
+			for _, part := range parts[1:] {
+				stacked[filepath.Join(dir, part)] = true
+			}
 		}
 	}
 
-	// Remove junk stuff
-	name = RegexJunk.ReplaceAllString(name, "")
+	result := []string{}
+	for _, file := range files {
+		if !stacked[file] {
+			result = append(result, file)
+		}
+	}
+	return result
+}
 
-	// Remove any extra white space
-	name = RegexSpace.ReplaceAllString(name, "")
+// Compare strings so that embedded numbers are ordered by value, i.e. "Episode 2" < "Episode 10"
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			numA, restA := splitNumber(a)
+			numB, restB := splitNumber(b)
+
+			// Compare numbers without leading zeros by length first, then by value
+			trimA := strings.TrimLeft(numA, "0")
+			trimB := strings.TrimLeft(numB, "0")
+			if len(trimA) != len(trimB) {
+				return len(trimA) < len(trimB)
+			}
+			if trimA != trimB {
+				return trimA < trimB
+			}
+			if numA != numB {
+				return len(numA) < len(numB)
+			}
+
+			a, b = restA, restB
+			continue
+		}
 
-	// Truncate space
-	name = strings.TrimSpace(name)
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
 
-	return name
+// Split leading digits from the string
+func splitNumber(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// Convert media filename to regular title
+func fileToTitle(name string) string {
+	return parseMediaName(name).Title
 }
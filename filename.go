@@ -0,0 +1,207 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// Release tags found in media filenames
+	RegexYear       = regexp.MustCompile(`\b(?:19|20)\d{2}\b`)
+	RegexResolution = regexp.MustCompile(`(?i)\b(?:2160p|1080[pi]|720p|576p|480p|4k|uhd)\b`)
+	RegexSource     = regexp.MustCompile(`(?i)\b(?:blu-?ray|bdrip|brrip|bdremux|remux|web-?dl|webrip|hdtv|pdtv|dvdrip|dvdscr|hdrip|hdcam)\b`)
+	RegexCodec      = regexp.MustCompile(`(?i)\b(?:x264|x265|h ?26[45]|hevc|avc|xvid|divx|vp9|av1|mpeg-?2)\b`)
+	RegexAudio      = regexp.MustCompile(`(?i)\b(?:aac|ac3|dts(?:-hd)?|ddp?5 1|truehd|atmos|flac|mp3)\b`)
+	RegexEdition    = regexp.MustCompile(`(?i)\b(?:extended(?: cut| edition)?|director'?s cut|unrated|uncut|theatrical(?: cut)?|remastered|special edition|criterion|imax)\b`)
+	Regex3D         = regexp.MustCompile(`(?i)\b(?:3d|h-?sbs|half-sbs|sbs|h-?ou|half-ou)\b`)
+	RegexJunk       = regexp.MustCompile(`(?i)\b(?:limited|proper|repack|internal|multi|dubbed|subbed)\b`)
+
+	// Release group at the end of the name, i.e. "x264-GROUP" or "AAC - GROUP [tag]"
+	RegexGroup = regexp.MustCompile(`(\s*)-\s*([A-Za-z0-9]+)(?:\s*\[[^\]]*\])*\s*$`)
+
+	RegexBrackets = regexp.MustCompile(`[\(\[\]\)]`)
+	RegexSpace    = regexp.MustCompile(`\s{2,}`)
+
+	// Canonical names of release tags, keyed by lowercase tag without separators
+	sourceNames = map[string]string{
+		"bluray": "BluRay", "bdrip": "BluRay", "brrip": "BluRay", "bdremux": "Remux", "remux": "Remux",
+		"webdl": "WEB-DL", "webrip": "WEBRip", "hdtv": "HDTV", "pdtv": "PDTV",
+		"dvdrip": "DVDRip", "dvdscr": "DVDScr", "hdrip": "HDRip", "hdcam": "HDCAM",
+	}
+	codecNames = map[string]string{
+		"x264": "H.264", "h264": "H.264", "avc": "H.264",
+		"x265": "H.265", "h265": "H.265", "hevc": "H.265",
+		"xvid": "XviD", "divx": "DivX", "vp9": "VP9", "av1": "AV1", "mpeg2": "MPEG-2",
+	}
+	editionNames = map[string]string{
+		"extended": "Extended", "extendedcut": "Extended", "extendededition": "Extended",
+		"directorscut": "Director's Cut", "unrated": "Unrated", "uncut": "Uncut",
+		"theatrical": "Theatrical", "theatricalcut": "Theatrical", "remastered": "Remastered",
+		"specialedition": "Special Edition", "criterion": "Criterion", "imax": "IMAX",
+	}
+)
+
+// MediaName is metadata parsed from a media filename
+type MediaName struct {
+	Title        string `json:"title"`                   // Movie or show title
+	Year         int    `json:"year,omitempty"`          // Release year
+	Season       int    `json:"season,omitempty"`        // Season number
+	Episode      int    `json:"episode,omitempty"`       // Episode number
+	EpisodeTitle string `json:"episode_title,omitempty"` // Episode title
+	Resolution   string `json:"resolution,omitempty"`    // Video resolution, i.e. "1080p"
+	Source       string `json:"source,omitempty"`        // Release source, i.e. "BluRay"
+	Codec        string `json:"codec,omitempty"`         // Video codec, i.e. "H.264"
+	Group        string `json:"group,omitempty"`         // Release group
+	Edition      string `json:"edition,omitempty"`       // Edition, i.e. "Director's Cut"
+	Is3D         bool   `json:"3d,omitempty"`            // True for 3D video
+}
+
+// Parse media filename, i.e. "Show.Name.S05E01.720p.HDTV.x264-LOL.mkv"
+func parseMediaName(name string) MediaName {
+	result := MediaName{}
+
+	// Remove file extension and treat dots and underscores as spaces
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+
+	// Tags are only recognized as the whole words
+	name = RegexBrackets.ReplaceAllStringFunc(name, func(s string) string {
+		if s == "[" || s == "]" {
+			return s
+		}
+		return " "
+	})
+
+	// Release group follows the release tags
+	if m := RegexGroup.FindStringSubmatchIndex(name); m != nil {
+		before := strings.TrimSpace(name[:m[0]])
+		tags := tagPositions(before)
+
+		// Tags with dashes, i.e. "WEB-DL", are not groups
+		if len(tags) > 0 && (m[3] == m[2] || tagAt(before, len(before))) && !tagAt(name, m[5]) {
+			result.Group = name[m[4]:m[5]]
+			name = name[:m[0]]
+		}
+	}
+	name = strings.NewReplacer("[", " ", "]", " ").Replace(name)
+
+	end := len(name)
+	episodeEnd := -1
+
+	// Leading year is a part of the title, i.e. "2001 A Space Odyssey"
+	for _, loc := range RegexYear.FindAllStringIndex(name, -1) {
+		if loc[0] > 0 {
+			result.Year = atoi(name[loc[0]:loc[1]])
+			end = minInt(end, loc[0])
+			break
+		}
+	}
+
+	if m := RegexSeasonEpisode.FindStringSubmatchIndex(name); m != nil {
+		if m[2] >= 0 {
+			result.Season, result.Episode = atoi(name[m[2]:m[3]]), atoi(name[m[4]:m[5]])
+		} else {
+			result.Season, result.Episode = atoi(name[m[6]:m[7]]), atoi(name[m[8]:m[9]])
+		}
+		end = minInt(end, m[0])
+		episodeEnd = m[1]
+	} else if m := RegexEpisodeNumber.FindStringSubmatchIndex(name); m != nil {
+		result.Episode = atoi(name[m[2]:m[3]])
+		end = minInt(end, m[0])
+		episodeEnd = m[1]
+	}
+
+	if match := RegexResolution.FindString(name); match != "" {
+		result.Resolution = strings.ToLower(match)
+		if result.Resolution == "4k" || result.Resolution == "uhd" {
+			result.Resolution = "2160p"
+		}
+	}
+	result.Source = sourceNames[tagKey(RegexSource.FindString(name))]
+	result.Codec = codecNames[tagKey(RegexCodec.FindString(name))]
+	result.Edition = editionNames[tagKey(RegexEdition.FindString(name))]
+	result.Is3D = Regex3D.MatchString(name)
+
+	tags := tagPositions(name)
+	if len(tags) > 0 {
+		end = minInt(end, tags[0])
+	}
+
+	// Episode title is between the episode number and the following tags
+	if episodeEnd >= 0 {
+		titleEnd := len(name)
+		for _, pos := range tags {
+			if pos >= episodeEnd {
+				titleEnd = pos
+				break
+			}
+		}
+		for _, loc := range RegexYear.FindAllStringIndex(name[episodeEnd:titleEnd], 1) {
+			titleEnd = episodeEnd + loc[0]
+		}
+		result.EpisodeTitle = cleanTitle(name[episodeEnd:titleEnd])
+	}
+
+	result.Title = cleanTitle(name[:end])
+	if result.Title == "" {
+		result.Title = cleanTitle(name)
+	}
+
+	return result
+}
+
+// Parse metadata of the media file. Season of files named only with episode
+// number is taken from the season folder.
+func mediaName(path string) *MediaName {
+	name := parseMediaName(stackName(filepath.Base(path)))
+
+	if name.Episode > 0 && name.Season == 0 {
+		name.Season = folderSeason(filepath.Dir(path))
+	}
+
+	return &name
+}
+
+// Get sorted positions of release tags in the name
+func tagPositions(name string) []int {
+	positions := []int{}
+	for _, re := range []*regexp.Regexp{RegexResolution, RegexSource, RegexCodec, RegexAudio, RegexEdition, Regex3D, RegexJunk} {
+		if loc := re.FindStringIndex(name); loc != nil {
+			positions = append(positions, loc[0])
+		}
+	}
+	sort.Ints(positions)
+	return positions
+}
+
+// Returns true if a release tag ends at the position
+func tagAt(name string, pos int) bool {
+	for _, re := range []*regexp.Regexp{RegexResolution, RegexSource, RegexCodec, RegexAudio, RegexEdition, Regex3D} {
+		for _, loc := range re.FindAllStringIndex(name, -1) {
+			if loc[1] == pos {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lowercase tag without separators, used as a key for canonical names
+func tagKey(tag string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "", "'", "").Replace(tag))
+}
+
+// Collapse white space and remove separators around the title
+func cleanTitle(title string) string {
+	title = RegexSpace.ReplaceAllString(title, " ")
+	return strings.Trim(title, " -")
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Name of the per-folder language profile, applies to the folder and subfolders
+const languageProfileName = ".languages.yml"
+
+// Folder profiles are read on every playback and listing, so they are only
+// parsed again when the file changes
+var folderProfiles = &LanguageProfileCache{items: map[string]*cachedProfile{}}
+
+// LanguageProfile lists preferred languages used to pick audio and subtitle
+// tracks automatically, i.e. Japanese audio with English subtitles unless the
+// audio is English:
+//
+//	audio: [jpn, eng]
+//	subtitles: [eng]
+//	subtitles_unless_audio: [eng]
+type LanguageProfile struct {
+	Audio                []string `yaml:"audio"`                  // Audio languages in order of preference
+	Subtitles            []string `yaml:"subtitles"`              // Subtitle languages in order of preference
+	SubtitlesUnlessAudio []string `yaml:"subtitles_unless_audio"` // Audio languages that need no subtitles
+}
+
+// Load language profile, missing file results in empty profile
+func loadLanguageProfile(path string) (*LanguageProfile, error) {
+	profile := &LanguageProfile{}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return profile, nil
+		}
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, profile); err != nil {
+		return nil, fmt.Errorf("Invalid language profile %s: %v", path, err)
+	}
+
+	if err := profile.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid language profile %s: %v", path, err)
+	}
+
+	return profile, nil
+}
+
+// Folder profile parsed from a single version of the file
+type cachedProfile struct {
+	size    int64
+	modTime time.Time
+	profile *LanguageProfile
+	err     error
+}
+
+// LanguageProfileCache keeps parsed folder profiles by path
+type LanguageProfileCache struct {
+	sync.Mutex
+	items map[string]*cachedProfile
+}
+
+// Get folder profile, missing file results in empty profile. Errors are
+// logged once per version of the file.
+func (c *LanguageProfileCache) Get(path string) (*LanguageProfile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &LanguageProfile{}, nil
+		}
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if item, ok := c.items[path]; ok && item.size == info.Size() && item.modTime.Equal(info.ModTime()) {
+		return item.profile, item.err
+	}
+
+	profile, err := loadLanguageProfile(path)
+	if err != nil {
+		log.Println("Cant load language profile:", err)
+	}
+	c.items[path] = &cachedProfile{size: info.Size(), modTime: info.ModTime(), profile: profile, err: err}
+
+	return profile, err
+}
+
+func (p *LanguageProfile) Validate() error {
+	for _, list := range [][]string{p.Audio, p.Subtitles, p.SubtitlesUnlessAudio} {
+		for _, language := range list {
+			if !RegexLanguage.MatchString(language) {
+				return fmt.Errorf("Invalid language: %s", language)
+			}
+		}
+	}
+	return nil
+}
+
+// Merge profile with another one, lists set in the other profile take precedence
+func (p LanguageProfile) Merge(other *LanguageProfile) LanguageProfile {
+	if len(other.Audio) > 0 {
+		p.Audio = other.Audio
+	}
+	if len(other.Subtitles) > 0 {
+		p.Subtitles = other.Subtitles
+	}
+	if len(other.SubtitlesUnlessAudio) > 0 {
+		p.SubtitlesUnlessAudio = other.SubtitlesUnlessAudio
+	}
+	return p
+}
+
+// Get language profile for the media file. Folder profiles between the media
+// directory and the file override the server-wide profile.
+func languageProfileFor(file string) LanguageProfile {
+	profile := LanguageProfile{}
+	if languages != nil {
+		profile = *languages
+	}
+
+	rel, err := filepath.Rel(MediaPath, filepath.Dir(file))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return profile
+	}
+
+	dirs := []string{MediaPath}
+	if rel != "." {
+		for _, name := range strings.Split(rel, string(filepath.Separator)) {
+			dirs = append(dirs, filepath.Join(dirs[len(dirs)-1], name))
+		}
+	}
+
+	for _, dir := range dirs {
+		// Broken profiles are skipped
+		folder, err := folderProfiles.Get(filepath.Join(dir, languageProfileName))
+		if err != nil {
+			continue
+		}
+		profile = profile.Merge(folder)
+	}
+
+	return profile
+}
+
+// Pick tracks according to the profile, keeping tracks selected explicitly.
+// Without probed streams the preferred languages are passed to the player.
+func (p LanguageProfile) Apply(opts TrackOptions, info *FileInfo) TrackOptions {
+	audioLang := opts.AudioLang
+
+	if opts.Audio == nil && opts.AudioLang == "" && len(p.Audio) > 0 {
+		if info == nil {
+			opts.AudioLang = p.Audio[0]
+			audioLang = p.Audio[0]
+		} else if index, ok := preferredAudio(info.Audio, p.Audio); ok {
+			opts.Audio = &index
+		}
+	}
+
+	// Language of the selected audio track decides if subtitles are needed
+	if info != nil && len(info.Audio) > 0 {
+		streams := make([]StreamInfo, len(info.Audio))
+		for i, stream := range info.Audio {
+			streams[i] = stream.StreamInfo
+		}
+		// Players start with the first track if nothing else is selected
+		if index, _ := pickTrack(streams, opts.Audio, opts.AudioLang); index < len(streams) {
+			audioLang = streams[index].Language
+		}
+	}
+
+	if opts.Subtitle != nil || opts.SubtitleLang != "" {
+		return opts
+	}
+
+	if audioLang != "" && containsString(p.SubtitlesUnlessAudio, audioLang) {
+		disabled := -1
+		opts.Subtitle = &disabled
+		return opts
+	}
+
+	if len(p.Subtitles) == 0 {
+		return opts
+	}
+
+	if info == nil {
+		opts.SubtitleLang = p.Subtitles[0]
+		return opts
+	}
+
+	for _, language := range p.Subtitles {
+		for i, stream := range info.Subtitles {
+			if stream.Language == language {
+				index := i
+				opts.Subtitle = &index
+				return opts
+			}
+		}
+	}
+
+	return opts
+}
+
+// Find the audio stream in the most preferred language
+func preferredAudio(streams []AudioStream, languages []string) (int, bool) {
+	for _, language := range languages {
+		for i, stream := range streams {
+			if stream.Language == language {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
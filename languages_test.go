@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_loadLanguageProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	profile, err := loadLanguageProfile(filepath.Join(dir, "missing.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, &LanguageProfile{}, profile)
+
+	path := filepath.Join(dir, "languages.yml")
+	ioutil.WriteFile(path, []byte("audio: [jpn, eng]\nsubtitles:\n  - eng\nsubtitles_unless_audio: [eng]\n"), 0644)
+	profile, err = loadLanguageProfile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, &LanguageProfile{
+		Audio:                []string{"jpn", "eng"},
+		Subtitles:            []string{"eng"},
+		SubtitlesUnlessAudio: []string{"eng"},
+	}, profile)
+
+	ioutil.WriteFile(path, []byte("audio: [japanese]\n"), 0644)
+	_, err = loadLanguageProfile(path)
+	assert.EqualError(t, err, "Invalid language profile "+path+": Invalid language: japanese")
+
+	ioutil.WriteFile(path, []byte("video: [jpn]\n"), 0644)
+	_, err = loadLanguageProfile(path)
+	assert.Error(t, err)
+}
+
+func Test_LanguageProfileApply(t *testing.T) {
+	info := &FileInfo{
+		Audio: []AudioStream{
+			{StreamInfo: StreamInfo{Index: 0, Language: "eng", Default: true}},
+			{StreamInfo: StreamInfo{Index: 1, Language: "jpn"}},
+		},
+		Subtitles: []SubtitleStream{
+			{StreamInfo{Index: 0, Language: "rus"}},
+			{StreamInfo{Index: 1, Language: "eng"}},
+		},
+	}
+	profile := LanguageProfile{
+		Audio:                []string{"jpn", "eng"},
+		Subtitles:            []string{"eng"},
+		SubtitlesUnlessAudio: []string{"eng"},
+	}
+
+	opts := profile.Apply(TrackOptions{}, info)
+	assert.Equal(t, 1, *opts.Audio)
+	assert.Equal(t, 1, *opts.Subtitle)
+
+	// English audio needs no subtitles
+	english := 0
+	opts = profile.Apply(TrackOptions{Audio: &english}, info)
+	assert.Equal(t, 0, *opts.Audio)
+	assert.Equal(t, -1, *opts.Subtitle)
+
+	opts = profile.Apply(TrackOptions{}, &FileInfo{Audio: info.Audio[:1]})
+	assert.Equal(t, 0, *opts.Audio)
+	assert.Equal(t, -1, *opts.Subtitle)
+
+	// Explicit selection is kept
+	opts = profile.Apply(TrackOptions{AudioLang: "jpn", SubtitleLang: "rus"}, info)
+	assert.Nil(t, opts.Audio)
+	assert.Nil(t, opts.Subtitle)
+	assert.Equal(t, "rus", opts.SubtitleLang)
+
+	// Missing subtitle language leaves subtitles alone
+	opts = LanguageProfile{Subtitles: []string{"fre"}}.Apply(TrackOptions{}, info)
+	assert.Nil(t, opts.Audio)
+	assert.Nil(t, opts.Subtitle)
+
+	// Without probe the languages are passed to the player
+	opts = profile.Apply(TrackOptions{}, nil)
+	assert.Equal(t, TrackOptions{AudioLang: "jpn", SubtitleLang: "eng"}, opts)
+}
+
+func Test_languageProfileFor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string, profile *LanguageProfile) { MediaPath, languages = path, profile }(MediaPath, languages)
+	MediaPath = dir
+	languages = &LanguageProfile{Audio: []string{"eng"}, Subtitles: []string{"eng"}}
+
+	os.MkdirAll(filepath.Join(dir, "Anime", "Show", "Season 1"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "Anime", languageProfileName), []byte("audio: [jpn]\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "Anime", "Show", languageProfileName), []byte("subtitles: [rus]\n"), 0644)
+
+	assert.Equal(t, *languages, languageProfileFor(filepath.Join(dir, "movie.mkv")))
+	assert.Equal(t, LanguageProfile{Audio: []string{"jpn"}, Subtitles: []string{"eng"}}, languageProfileFor(filepath.Join(dir, "Anime", "movie.mkv")))
+	assert.Equal(t, LanguageProfile{Audio: []string{"jpn"}, Subtitles: []string{"rus"}}, languageProfileFor(filepath.Join(dir, "Anime", "Show", "Season 1", "e1.mkv")))
+	assert.Equal(t, *languages, languageProfileFor("http://example.com/movie.mkv"))
+
+	// Profiles are parsed again only when the file changes
+	path := filepath.Join(dir, "Anime", languageProfileName)
+	info, _ := os.Stat(path)
+	ioutil.WriteFile(path, []byte("audio: [kor]\n"), 0644)
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+	assert.Equal(t, []string{"jpn"}, languageProfileFor(filepath.Join(dir, "Anime", "movie.mkv")).Audio)
+
+	os.Chtimes(path, info.ModTime(), info.ModTime().Add(time.Second))
+	assert.Equal(t, []string{"kor"}, languageProfileFor(filepath.Join(dir, "Anime", "movie.mkv")).Audio)
+}
@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// How long to wait for mpv IPC command response
+	mpvCallTimeout = 2 * time.Second
+
+	errMpvClosed = errors.New("mpv IPC connection is closed")
+)
+
+// Properties observed for status reporting
+var mpvObserved = []string{"time-pos", "duration", "pause"}
+
+// Mapping of remote commands to mpv IPC commands
+var mpvCommands = map[string][]interface{}{
+	"pause":             {"cycle", "pause"},
+	"volume_up":         {"add", "volume", 5},
+	"volume_down":       {"add", "volume", -5},
+	"subtitles":         {"cycle", "sub-visibility"},
+	"seek_back":         {"seek", -30, "relative"},
+	"seek_back_fast":    {"seek", -600, "relative"},
+	"seek_forward":      {"seek", 30, "relative"},
+	"seek_forward_fast": {"seek", 600, "relative"},
+
+	"speed_down":          {"multiply", "speed", 0.5},
+	"speed_up":            {"multiply", "speed", 2},
+	"chapter_previous":    {"add", "chapter", -1},
+	"chapter_next":        {"add", "chapter", 1},
+	"audio_previous":      {"cycle", "audio", "down"},
+	"audio_next":          {"cycle", "audio"},
+	"subtitle_previous":   {"cycle", "sub", "down"},
+	"subtitle_next":       {"cycle", "sub"},
+	"subtitle_delay_down": {"add", "sub-delay", -0.25},
+	"subtitle_delay_up":   {"add", "sub-delay", 0.25},
+	"info":                {"script-binding", "stats/display-stats"},
+}
+
+// MpvPlayer plays media with mpv, controlled over its JSON IPC socket.
+// Same as OmxPlayer, all playback state is guarded by the lock.
+type MpvPlayer struct {
+	sync.Mutex
+
+	path   string           // Path to mpv executable
+	socket string           // Path to IPC socket
+	state  PlayerState      // Current playback state
+	proc   *mpvProcess      // Running mpv process
+	file   string           // Currently playing media file
+	err    error            // Last playback error
+	events chan PlayerEvent // Playback lifecycle events
+}
+
+// A single mpv process execution
+type mpvProcess struct {
+	cmd      *exec.Cmd     // Child process
+	conn     *mpvConn      // IPC connection, nil until connected
+	stderr   *outputTail   // Last lines of error output
+	done     chan struct{} // Closed when child process exits
+	ready    chan struct{} // Closed when playback position is first reported
+	err      error         // Exit error, only valid after done is closed
+	startErr error         // Startup failure reported instead of the exit error
+	exited   bool          // Set when child process has exited
+	started  bool          // Set when playback position is first reported
+	paused   bool          // Pause property reported by the player
+	position float64       // Current position in seconds
+	duration float64       // Media duration in seconds
+}
+
+func NewMpvPlayer(path string) *MpvPlayer {
+	return &MpvPlayer{
+		path:   path,
+		socket: filepath.Join(os.TempDir(), fmt.Sprintf("omxremote-mpv-%d.sock", os.Getpid())),
+		state:  StateIdle,
+		events: make(chan PlayerEvent, 10),
+	}
+}
+
+// Start mpv playback for a given file. Blocks until the player reports
+// playback position, exits or the startup window expires.
+func (p *MpvPlayer) Play(file string, opts PlayOptions) error {
+	p.Lock()
+
+	if p.state.Active() {
+		p.Unlock()
+		return ErrPlayerActive
+	}
+	if err := p.state.Transition(StateStarting); err != nil {
+		p.Unlock()
+		return err
+	}
+
+	p.file = file
+	p.err = nil
+
+	// Remove stale socket left by a crashed process
+	os.Remove(p.socket)
+
+	cmd := exec.Command(p.path, mpvArgs(p.socket, file, opts)...)
+
+	proc := &mpvProcess{
+		cmd:    cmd,
+		stderr: &outputTail{},
+		done:   make(chan struct{}),
+		ready:  make(chan struct{}),
+	}
+	cmd.Stderr = proc.stderr
+
+	if err := cmd.Start(); err != nil {
+		p.err = err
+		p.state.Transition(StateFailed)
+		p.Unlock()
+
+		sendEvent(p.events, PlayerEvent{Type: EventFailed, File: file, Error: err})
+		return err
+	}
+
+	p.proc = proc
+	go p.wait(proc)
+	p.Unlock()
+
+	timer := time.NewTimer(startupTimeout)
+	defer timer.Stop()
+
+	if err := p.connect(proc, timer.C); err != nil {
+		select {
+		case <-proc.done:
+			return proc.err
+		default:
+		}
+
+		// Player cannot be controlled without IPC, so playback has failed
+		log.Println("mpv IPC connection failed:", err)
+
+		p.Lock()
+		proc.startErr = err
+		proc.cmd.Process.Kill()
+		p.Unlock()
+
+		<-proc.done
+		return proc.err
+	}
+
+	select {
+	case <-proc.ready:
+	case <-timer.C:
+		log.Println("mpv did not report playback position in", startupTimeout)
+	case <-proc.done:
+		// Process exited during startup, nil error means it was stopped
+		return proc.err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	// Playback could be stopped while waiting
+	if p.proc != proc || p.state != StateStarting {
+		return nil
+	}
+
+	p.state.Transition(StatePlaying)
+	sendEvent(p.events, PlayerEvent{Type: EventStarted, File: file})
+
+	// Pause state could be changed during startup
+	if proc.paused {
+		p.state.Transition(StatePaused)
+	}
+
+	return nil
+}
+
+// Build mpv command line arguments
+func mpvArgs(socket string, file string, opts PlayOptions) []string {
+	args := []string{
+		"--input-ipc-server=" + socket, // JSON IPC socket used for control
+		"--no-input-terminal",          // do not read keyboard input
+		"--msg-level=all=error",        // only print errors
+		"--fullscreen",                 // use the whole screen
+	}
+
+	if opts.Position > 0 {
+		args = append(args, fmt.Sprintf("--start=%d", int(opts.Position.Seconds())))
+	}
+
+	if opts.Volume != 0 {
+		args = append(args, fmt.Sprintf("--volume=%.1f", mpvVolume(opts.Volume)))
+	}
+
+	if device := alsaDeviceName(opts.AudioDevice); device != "" {
+		args = append(args, "--audio-device=alsa/"+device)
+	}
+
+	// Display selection and layers are specific to the Pi firmware
+	video := opts.Video
+	switch video.AspectMode {
+	case "fill":
+		args = append(args, "--panscan=1.0")
+	case "stretch":
+		args = append(args, "--no-keepaspect")
+	}
+	if video.Window != "" {
+		x, y, width, height := videoWindowRect(video.Window)
+		args = append(args, "--no-fullscreen", fmt.Sprintf("--geometry=%dx%d+%d+%d", width, height, x, y))
+	}
+	if video.Orientation != 0 {
+		args = append(args, fmt.Sprintf("--video-rotate=%d", video.Orientation))
+	}
+
+	// Track IDs start at 1
+	tracks := opts.Tracks
+	if tracks.AudioLang != "" {
+		args = append(args, "--alang="+tracks.AudioLang)
+	}
+	if tracks.SubtitleLang != "" {
+		args = append(args, "--slang="+tracks.SubtitleLang)
+	}
+	if tracks.Audio != nil {
+		args = append(args, fmt.Sprintf("--aid=%d", *tracks.Audio+1))
+	}
+	if tracks.Subtitle != nil {
+		args = append(args, "--sid="+mpvTrackID(*tracks.Subtitle))
+	}
+	if opts.Subtitles != "" {
+		args = append(args, "--sub-file="+opts.Subtitles)
+	}
+	if opts.SubtitleDelay != 0 {
+		args = append(args, fmt.Sprintf("--sub-delay=%.3f", opts.SubtitleDelay.Seconds()))
+	}
+
+	return append(args, file)
+}
+
+// Connect to IPC socket once mpv creates it and subscribe to property changes
+func (p *MpvPlayer) connect(proc *mpvProcess, timeout <-chan time.Time) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		conn, err := mpvDial(p.socket, func(msg mpvMessage) {
+			p.handleEvent(proc, msg)
+		})
+
+		if err == nil {
+			// Process exited after the dial, nothing else will close the connection
+			p.Lock()
+			if proc.exited {
+				p.Unlock()
+				conn.Close()
+				return errMpvClosed
+			}
+			proc.conn = conn
+			p.Unlock()
+
+			for i, name := range mpvObserved {
+				if _, err := conn.Command("observe_property", i+1, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-proc.done:
+			return errMpvClosed
+		case <-timeout:
+			return fmt.Errorf("Unable to connect to mpv: %v", err)
+		}
+	}
+}
+
+// Update playback state from observed property changes
+func (p *MpvPlayer) handleEvent(proc *mpvProcess, msg mpvMessage) {
+	if proc == nil || msg.Event != "property-change" {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	switch msg.Name {
+	case "time-pos":
+		if err := json.Unmarshal(msg.Data, &proc.position); err != nil {
+			return
+		}
+		if !proc.started {
+			proc.started = true
+			close(proc.ready)
+		}
+	case "duration":
+		json.Unmarshal(msg.Data, &proc.duration)
+	case "pause":
+		json.Unmarshal(msg.Data, &proc.paused)
+
+		if p.proc != proc {
+			return
+		}
+		if proc.paused && p.state == StatePlaying {
+			p.state.Transition(StatePaused)
+		} else if !proc.paused && p.state == StatePaused {
+			p.state.Transition(StatePlaying)
+		}
+	}
+}
+
+// Wait until child process is finished and notify listeners
+func (p *MpvPlayer) wait(proc *mpvProcess) {
+	defer close(proc.done)
+
+	err := proc.cmd.Wait()
+	if err != nil {
+		log.Println("Process exited with error:", err)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	proc.exited = true
+	event := PlayerEvent{Type: EventFinished, File: p.file}
+
+	// Player exiting before it started playing is always a failure
+	if err == nil && p.state == StateStarting {
+		err = errors.New("Player exited before playback started")
+	}
+	if proc.startErr != nil {
+		err = proc.startErr
+	}
+
+	switch {
+	case p.state == StateStopping:
+		event.Type = EventStopped
+		event.Position, event.Duration = uint64(proc.position), uint64(proc.duration)
+		p.state.Transition(StateIdle)
+	case err != nil:
+		proc.err = newPlayError(err, proc.stderr.Lines())
+		event.Type = EventFailed
+		event.Error = proc.err
+		p.err = proc.err
+		p.state.Transition(StateFailed)
+	default:
+		p.state.Transition(StateIdle)
+	}
+
+	if proc.conn != nil {
+		proc.conn.Close()
+	}
+	os.Remove(p.socket)
+
+	p.proc = nil
+	p.file = ""
+
+	sendEvent(p.events, event)
+}
+
+// Send a command to mpv
+func (p *MpvPlayer) Command(name string) error {
+	if _, ok := Commands[name]; !ok {
+		return ErrInvalidCommand
+	}
+
+	// Position is remembered by the server, the player only stops
+	if name == "stop" {
+		return p.Stop()
+	}
+
+	args, ok := mpvCommands[name]
+	if !ok {
+		return ErrInvalidCommand
+	}
+
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	if _, err = conn.Command(args...); err != nil {
+		return err
+	}
+
+	// Update state right away instead of waiting for the property change event
+	if name == "pause" {
+		data, err := conn.Command("get_property", "pause")
+		if err != nil {
+			return err
+		}
+		p.handleEvent(p.current(), mpvMessage{Event: "property-change", Name: "pause", Data: data})
+	}
+
+	return nil
+}
+
+// Get running process
+func (p *MpvPlayer) current() *mpvProcess {
+	p.Lock()
+	defer p.Unlock()
+	return p.proc
+}
+
+// Get IPC connection of the running player
+func (p *MpvPlayer) conn() (*mpvConn, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.state != StatePlaying && p.state != StatePaused {
+		return nil, ErrPlayerInactive
+	}
+	if p.proc.conn == nil {
+		return nil, errMpvClosed
+	}
+	return p.proc.conn, nil
+}
+
+// Seek relative to the current position
+func (p *MpvPlayer) Seek(offset time.Duration) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Command("seek", offset.Seconds(), "relative")
+	return err
+}
+
+// Seek to absolute position
+func (p *MpvPlayer) SetPosition(pos time.Duration) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Command("seek", pos.Seconds(), "absolute")
+	return err
+}
+
+// Set volume in decibels
+func (p *MpvPlayer) SetVolume(db float64) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Command("set_property", "volume", mpvVolume(db))
+	return err
+}
+
+// Mute or unmute audio
+func (p *MpvPlayer) SetMuted(muted bool) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Command("set_property", "mute", muted)
+	return err
+}
+
+// Select audio track
+func (p *MpvPlayer) SelectAudio(index int) error {
+	return p.setTrack("aid", index)
+}
+
+// Select subtitle track, -1 disables subtitles
+func (p *MpvPlayer) SelectSubtitle(index int) error {
+	return p.setTrack("sid", index)
+}
+
+func (p *MpvPlayer) setTrack(property string, index int) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Command("set_property", property, mpvTrackID(index))
+	return err
+}
+
+// Offset subtitle timing
+func (p *MpvPlayer) SetSubtitleDelay(delay time.Duration) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Command("set_property", "sub-delay", delay.Seconds())
+	return err
+}
+
+// Track ID for the track index, "no" disables the track
+func mpvTrackID(index int) string {
+	if index < 0 {
+		return "no"
+	}
+	return strconv.Itoa(index + 1)
+}
+
+// Convert decibels to mpv volume, which is on a cubic scale
+func mpvVolume(db float64) float64 {
+	if db <= volumeMin {
+		return 0
+	}
+	return 100 * math.Pow(10, db/60)
+}
+
+// Stop playback and wait until the player process exits
+func (p *MpvPlayer) Stop() error {
+	p.Lock()
+
+	if !p.state.Active() {
+		p.Unlock()
+		return ErrPlayerInactive
+	}
+
+	proc := p.proc
+
+	if p.state != StateStopping {
+		p.state.Transition(StateStopping)
+
+		if proc.conn == nil {
+			proc.cmd.Process.Kill()
+		} else {
+			go proc.conn.Command("quit")
+
+			// Kill the process if it does not quit on its own
+			go func() {
+				select {
+				case <-proc.done:
+				case <-time.After(mpvCallTimeout):
+					proc.cmd.Process.Kill()
+				}
+			}()
+		}
+	}
+
+	p.Unlock()
+
+	<-proc.done
+	return nil
+}
+
+func (p *MpvPlayer) Status() PlayerStatus {
+	p.Lock()
+	defer p.Unlock()
+
+	status := PlayerStatus{
+		Running: p.state.Active(),
+		State:   p.state,
+		File:    p.file,
+	}
+
+	if p.err != nil {
+		status.Error = p.err.Error()
+	}
+
+	if p.proc != nil {
+		status.Position = uint64(p.proc.position)
+		status.Duration = uint64(p.proc.duration)
+	}
+
+	return status
+}
+
+func (p *MpvPlayer) Events() <-chan PlayerEvent {
+	return p.events
+}
+
+// mpv IPC message, either a command response or an event
+type mpvMessage struct {
+	RequestID int             `json:"request_id"`
+	Error     string          `json:"error"`
+	Data      json.RawMessage `json:"data"`
+	Event     string          `json:"event"`
+	Name      string          `json:"name"`
+}
+
+// mpvConn is a client for mpv JSON IPC protocol
+type mpvConn struct {
+	conn      net.Conn
+	onEvent   func(mpvMessage)
+	writeLock sync.Mutex
+
+	lock      sync.Mutex
+	requestID int
+	pending   map[int]chan mpvMessage
+	closed    bool
+}
+
+func mpvDial(socket string, onEvent func(mpvMessage)) (*mpvConn, error) {
+	conn, err := net.DialTimeout("unix", socket, mpvCallTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mpvConn{
+		conn:    conn,
+		onEvent: onEvent,
+		pending: map[int]chan mpvMessage{},
+	}
+	go c.read()
+
+	return c, nil
+}
+
+// Execute IPC command and return response data
+func (c *mpvConn) Command(args ...interface{}) (json.RawMessage, error) {
+	reply := make(chan mpvMessage, 1)
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil, errMpvClosed
+	}
+	c.requestID++
+	id := c.requestID
+	c.pending[id] = reply
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+	}()
+
+	data, err := json.Marshal(map[string]interface{}{
+		"command":    args,
+		"request_id": id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.writeLock.Lock()
+	_, err = c.conn.Write(append(data, '\n'))
+	c.writeLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(mpvCallTimeout)
+	defer timer.Stop()
+
+	select {
+	case resp, ok := <-reply:
+		if !ok {
+			return nil, errMpvClosed
+		}
+		if resp.Error != "success" {
+			return nil, fmt.Errorf("mpv command %v failed: %s", args[0], resp.Error)
+		}
+		return resp.Data, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("mpv command %v timed out", args[0])
+	}
+}
+
+func (c *mpvConn) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	for id, reply := range c.pending {
+		close(reply)
+		delete(c.pending, id)
+	}
+	c.lock.Unlock()
+
+	return c.conn.Close()
+}
+
+// Read messages from the socket until it is closed
+func (c *mpvConn) read() {
+	defer c.Close()
+
+	scanner := bufio.NewScanner(c.conn)
+	for scanner.Scan() {
+		msg := mpvMessage{}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+
+		if msg.Event != "" {
+			if c.onEvent != nil {
+				c.onEvent(msg)
+			}
+			continue
+		}
+
+		c.lock.Lock()
+		if reply, ok := c.pending[msg.RequestID]; ok {
+			select {
+			case reply <- msg:
+			default:
+			}
+		}
+		c.lock.Unlock()
+	}
+}
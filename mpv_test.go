@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Mimic mpv JSON IPC server. Files named "broken" fail to open, files named
+// "short" finish after a few position updates.
+func fakeMpv(args []string) {
+	socket := strings.TrimPrefix(args[0], "--input-ipc-server=")
+	file := args[len(args)-1]
+
+	if strings.Contains(file, "broken") {
+		fmt.Fprintln(os.Stderr, "Failed to recognize file format.")
+		os.Exit(2)
+	}
+	if strings.Contains(file, "noipc") {
+		time.Sleep(30 * time.Second)
+		os.Exit(0)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.Exit(3)
+	}
+
+	lock := sync.Mutex{}
+	props := map[string]interface{}{
+		"time-pos": 0.0,
+		"duration": 100.0,
+		"pause":    false,
+		"volume":   100.0,
+	}
+	observers := map[string]int{}
+	conns := []net.Conn{}
+
+	// Caller must hold the lock
+	send := func(conn net.Conn, msg map[string]interface{}) {
+		data, _ := json.Marshal(msg)
+		conn.Write(append(data, '\n'))
+	}
+	notify := func(name string) {
+		if id, ok := observers[name]; ok {
+			for _, conn := range conns {
+				send(conn, map[string]interface{}{"event": "property-change", "id": id, "name": name, "data": props[name]})
+			}
+		}
+	}
+
+	handle := func(conn net.Conn) {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			req := struct {
+				Command   []interface{} `json:"command"`
+				RequestID int           `json:"request_id"`
+			}{}
+			json.Unmarshal(scanner.Bytes(), &req)
+
+			lock.Lock()
+			resp := map[string]interface{}{"request_id": req.RequestID, "error": "success"}
+
+			switch req.Command[0] {
+			case "observe_property":
+				name := req.Command[2].(string)
+				observers[name] = int(req.Command[1].(float64))
+				send(conn, resp)
+				notify(name)
+				lock.Unlock()
+				continue
+			case "get_property":
+				resp["data"] = props[req.Command[1].(string)]
+			case "set_property":
+				props[req.Command[1].(string)] = req.Command[2]
+			case "cycle":
+				if req.Command[1] == "pause" {
+					props["pause"] = !props["pause"].(bool)
+					notify("pause")
+				}
+			case "add":
+				name := req.Command[1].(string)
+				props[name] = props[name].(float64) + req.Command[2].(float64)
+			case "seek":
+				value := req.Command[1].(float64)
+				if req.Command[2] == "relative" {
+					value += props["time-pos"].(float64)
+				}
+				props["time-pos"] = value
+				notify("time-pos")
+			case "quit":
+				send(conn, resp)
+				os.Exit(0)
+			default:
+				resp["error"] = "invalid parameter"
+			}
+
+			send(conn, resp)
+			lock.Unlock()
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			lock.Lock()
+			conns = append(conns, conn)
+			lock.Unlock()
+
+			go handle(conn)
+		}
+	}()
+
+	ticks := 1000
+	if strings.Contains(file, "short") {
+		ticks = 5
+	}
+
+	for i := 0; i < ticks; i++ {
+		time.Sleep(10 * time.Millisecond)
+
+		lock.Lock()
+		if !props["pause"].(bool) {
+			props["time-pos"] = props["time-pos"].(float64) + 1
+			notify("time-pos")
+		}
+		lock.Unlock()
+	}
+
+	listener.Close()
+	os.Remove(socket)
+}
+
+func Test_newPlayer(t *testing.T) {
+	_, err := newPlayer("foo")
+	assert.EqualError(t, err, "Unsupported player: foo")
+}
+
+func Test_mpvArgs(t *testing.T) {
+	args := mpvArgs("/tmp/mpv.sock", "/media/movie.mkv", PlayOptions{AudioDevice: "hdmi"})
+	assert.Equal(t, []string{"--fullscreen", "/media/movie.mkv"}, args[len(args)-2:])
+
+	args = mpvArgs("/tmp/mpv.sock", "/media/movie.mkv", PlayOptions{Position: time.Minute, AudioDevice: "alsa:hw:1,0"})
+	assert.Equal(t, []string{"--start=60", "--audio-device=alsa/hw:1,0", "/media/movie.mkv"}, args[len(args)-3:])
+
+	args = mpvArgs("/tmp/mpv.sock", "/media/movie.mkv", PlayOptions{Video: VideoOptions{
+		AspectMode:  "stretch",
+		Window:      "100,50,740,530",
+		Orientation: 270,
+	}})
+	assert.Equal(t, []string{
+		"--no-keepaspect",
+		"--no-fullscreen",
+		"--geometry=640x480+100+50",
+		"--video-rotate=270",
+		"/media/movie.mkv",
+	}, args[len(args)-5:])
+
+	audio, subtitle := 1, -1
+	args = mpvArgs("/tmp/mpv.sock", "/media/movie.mkv", PlayOptions{Tracks: TrackOptions{
+		AudioLang:    "jpn",
+		SubtitleLang: "eng",
+		Audio:        &audio,
+		Subtitle:     &subtitle,
+	}})
+	assert.Equal(t, []string{"--alang=jpn", "--slang=eng", "--aid=2", "--sid=no", "/media/movie.mkv"}, args[len(args)-5:])
+
+	args = mpvArgs("/tmp/mpv.sock", "/media/movie.mkv", PlayOptions{Subtitles: "/data/subtitles/movie.srt"})
+	assert.Equal(t, []string{"--sub-file=/data/subtitles/movie.srt", "/media/movie.mkv"}, args[len(args)-2:])
+
+	args = mpvArgs("/tmp/mpv.sock", "/media/movie.mkv", PlayOptions{SubtitleDelay: -1500 * time.Millisecond})
+	assert.Equal(t, []string{"--sub-delay=-1.500", "/media/movie.mkv"}, args[len(args)-2:])
+}
+
+func Test_MpvPlayer(t *testing.T) {
+	p := NewMpvPlayer(os.Args[0])
+
+	assert.Equal(t, StateIdle, p.Status().State)
+	assert.Equal(t, ErrPlayerInactive, p.Command("pause"))
+	assert.Equal(t, ErrPlayerInactive, p.Stop())
+
+	assert.NoError(t, p.Play("/media/movie.mkv", PlayOptions{}))
+	assert.Equal(t, EventStarted, waitEvent(t, p).Type)
+	assert.Equal(t, ErrPlayerActive, p.Play("/media/movie.mkv", PlayOptions{}))
+
+	status := p.Status()
+	assert.Equal(t, StatePlaying, status.State)
+	assert.Equal(t, "/media/movie.mkv", status.File)
+	assert.Equal(t, uint64(100), status.Duration)
+
+	assert.NoError(t, p.Command("pause"))
+	assert.Equal(t, StatePaused, p.Status().State)
+	assert.NoError(t, p.Command("pause"))
+	assert.Equal(t, StatePlaying, p.Status().State)
+
+	assert.NoError(t, p.Command("seek_forward"))
+	assert.NoError(t, p.Command("volume_up"))
+	assert.Equal(t, ErrInvalidCommand, p.Command("foo"))
+
+	assert.NoError(t, p.SetVolume(-6))
+	assert.NoError(t, p.SetMuted(true))
+	conn, _ := p.conn()
+	data, _ := conn.Command("get_property", "volume")
+	assert.True(t, strings.HasPrefix(string(data), "79.43"))
+	data, _ = conn.Command("get_property", "mute")
+	assert.Equal(t, "true", string(data))
+
+	assert.NoError(t, p.SelectAudio(1))
+	data, _ = conn.Command("get_property", "aid")
+	assert.Equal(t, `"2"`, string(data))
+	assert.NoError(t, p.SelectSubtitle(-1))
+	data, _ = conn.Command("get_property", "sid")
+	assert.Equal(t, `"no"`, string(data))
+
+	assert.NoError(t, p.SetPosition(90*time.Second))
+	assert.True(t, p.Status().Position >= 90)
+
+	assert.NoError(t, p.Stop())
+	assert.Equal(t, EventStopped, waitEvent(t, p).Type)
+
+	status = p.Status()
+	assert.Equal(t, StateIdle, status.State)
+	assert.Equal(t, "", status.File)
+}
+
+func Test_MpvPlayerFinished(t *testing.T) {
+	p := NewMpvPlayer(os.Args[0])
+
+	assert.NoError(t, p.Play("/media/short.mkv", PlayOptions{}))
+	assert.Equal(t, EventStarted, waitEvent(t, p).Type)
+	assert.Equal(t, EventFinished, waitEvent(t, p).Type)
+	assert.Equal(t, StateIdle, p.Status().State)
+}
+
+func Test_MpvPlayerFailed(t *testing.T) {
+	p := NewMpvPlayer(os.Args[0])
+
+	err := p.Play("/media/broken.mkv", PlayOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, EventFailed, waitEvent(t, p).Type)
+
+	playErr, ok := err.(*PlayError)
+	assert.True(t, ok)
+	assert.Equal(t, 2, playErr.ExitCode)
+	assert.Equal(t, []string{"Failed to recognize file format."}, playErr.Stderr)
+	assert.Equal(t, StateFailed, p.Status().State)
+}
+
+func Test_MpvPlayerConnectFailed(t *testing.T) {
+	defer func(timeout time.Duration) { startupTimeout = timeout }(startupTimeout)
+	startupTimeout = 200 * time.Millisecond
+
+	p := NewMpvPlayer(os.Args[0])
+
+	err := p.Play("/media/noipc.mkv", PlayOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Unable to connect to mpv")
+
+	event := waitEvent(t, p)
+	assert.Equal(t, EventFailed, event.Type)
+	assert.Equal(t, err, event.Error)
+
+	status := p.Status()
+	assert.Equal(t, StateFailed, status.State)
+	assert.Equal(t, err.Error(), status.Error)
+}
+
+func Test_MpvPlayerConnectExited(t *testing.T) {
+	p := NewMpvPlayer(os.Args[0])
+	p.socket = fmt.Sprintf("%s/omxremote-mpv-exited-%d.sock", os.TempDir(), os.Getpid())
+	defer os.Remove(p.socket)
+
+	listener, err := net.Listen("unix", p.socket)
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	closed := make(chan bool)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, err = conn.Read(make([]byte, 1))
+		closed <- err != nil
+	}()
+
+	// Process exited after the socket was dialed
+	proc := &mpvProcess{done: make(chan struct{}), exited: true}
+	assert.Equal(t, errMpvClosed, p.connect(proc, time.After(time.Second)))
+	assert.Nil(t, proc.conn)
+	assert.True(t, <-closed)
+}
+
+func Test_MpvPlayerConcurrency(t *testing.T) {
+	p := NewMpvPlayer(os.Args[0])
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 3; i++ {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				p.Play("/media/movie.mkv", PlayOptions{})
+				p.Stop()
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				p.Command("pause")
+				p.Status()
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				p.Status()
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	wg.Wait()
+	p.Stop()
+
+	assert.False(t, p.Status().Running)
+}
@@ -2,50 +2,76 @@ package main
 
 import (
 	"bytes"
-	"fmt"
+	"errors"
 	"io"
 	"log"
-	"os"
+	"math"
 	"os/exec"
-	"strings"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 )
 
-type FileInfo struct {
-	Duration string `json:"duration"`
-}
+// Subtitle delay change of a single keyboard command
+const omxSubtitleDelayStep = 250 * time.Millisecond
 
-// Determine the full path to omxplayer executable. Returns error if not found.
-func omxDetect() error {
-	buff, err := exec.Command("which", "omxplayer").Output()
-	if err != nil {
-		return err
-	}
+var (
+	// How long to wait for omxplayer to exit before killing its process group
+	omxStopTimeout = 5 * time.Second
 
-	// Set path in global variable
-	OmxPath = strings.TrimSpace(string(buff))
+	errOmxStopTimeout = errors.New("omxplayer did not exit")
+)
 
-	return nil
+// Keyboard key and matching D-Bus action of omxplayer
+type omxKey struct {
+	key    string
+	action int32
 }
 
-// Start command listener. Commands are coming in through a channel.
-func omxListen() {
-	Command = make(chan string)
-
-	for {
-		command := <-Command
+// Keys stepping subtitle delay, see KeyConfig.h
+var (
+	omxSubtitleDelayDown = omxKey{"d", 13}
+	omxSubtitleDelayUp   = omxKey{"f", 14}
+)
 
-		// Skip command handling of omx player is not active
-		if Omx == nil {
-			continue
-		}
+// OmxPlayer controls omxplayer child process over D-Bus when the session bus
+// is available, falling back to keyboard commands on STDIN. The player owns
+// the child process and is the only writer of playback state, all access to
+// its fields is guarded by the lock.
+type OmxPlayer struct {
+	sync.Mutex
+
+	path   string           // Path to omxplayer executable
+	state  PlayerState      // Current playback state
+	proc   *omxProcess      // Running omxplayer process
+	file   string           // Currently playing media file
+	opts   PlayOptions      // Options of the current playback
+	err    error            // Last playback error
+	events chan PlayerEvent // Playback lifecycle events
+
+	restarting bool // Set while the process is being replaced
+	stopped    bool // Set when playback is stopped during restart
+}
 
-		// Send command to the player
-		omxWrite(command)
+// A single omxplayer process execution
+type omxProcess struct {
+	cmd    *exec.Cmd      // Child process for spawning omxplayer
+	stdin  io.WriteCloser // Child process STDIN pipe to send commands
+	stream *Stream        // Playback progress parsed from the output
+	done   chan struct{}  // Closed when child process exits
+	err    error          // Exit error, only valid after done is closed
+
+	bus        *OmxDbus  // D-Bus control connection, nil if not connected
+	busAttempt time.Time // Last time bus connection was attempted
+	restart    bool      // Set when process is stopped to be restarted
+}
 
-		// Attempt to kill the process if stop command is requested
-		if command == "stop" {
-			Omx.Process.Kill()
-		}
+func NewOmxPlayer(path string) *OmxPlayer {
+	return &OmxPlayer{
+		path:   path,
+		state:  StateIdle,
+		events: make(chan PlayerEvent, 10),
 	}
 }
 
@@ -57,113 +83,713 @@ func omxInfo(file string) (*FileInfo, error) {
 	cmd.Stderr = output
 
 	// Valid `omxplayer --info` exits with status 1, so we just log the error
-	if err := cmd.Run(); err != nil {
+	err := cmd.Run()
+	if _, exited := err.(*exec.ExitError); err != nil && !exited {
+		return nil, err
+	}
+	if err != nil {
 		log.Println("omxplayer --info returned error:", err)
 	}
 
-	data := string(output.String())
-	info := &FileInfo{}
+	return parseFileInfo(output.String()), nil
+}
 
-	matches := durationRegexp.FindAllStringSubmatch(data, 1)
-	if len(matches) > 0 {
-		info.Duration = matches[0][1]
+// Start omxplayer playback for a given video file. Blocks until the player
+// reports playback position, exits or the startup window expires.
+func (p *OmxPlayer) Play(file string, opts PlayOptions) error {
+	return p.play(file, opts, false)
+}
+
+// Start playback, lifecycle events are not sent when restarting the player
+func (p *OmxPlayer) play(file string, opts PlayOptions, restart bool) error {
+	p.Lock()
+
+	// Playback stopped between the exit of the old process and this call
+	if restart && p.stopped {
+		p.restarting = false
+		p.stopped = false
+		p.Unlock()
+
+		sendEvent(p.events, PlayerEvent{Type: EventStopped, File: file})
+		return nil
+	}
+	p.restarting = false
+	p.stopped = false
+
+	if p.state.Active() {
+		p.Unlock()
+		return ErrPlayerActive
+	}
+	if err := p.state.Transition(StateStarting); err != nil {
+		p.Unlock()
+		return err
 	}
 
-	return info, nil
+	p.file = file
+	p.opts = opts
+	p.err = nil
+
+	// Timing of external subtitles is fixed by shifting the file itself
+	args := opts
+	if opts.Subtitles != "" && opts.SubtitleDelay != 0 {
+		shifted, err := shiftSubtitles(opts.Subtitles, opts.SubtitleDelay)
+		if err != nil {
+			log.Println("Cant shift subtitles:", err)
+		} else {
+			args.Subtitles = shifted
+		}
+	}
+
+	cmd := exec.Command(p.path, omxArgs(file, args)...)
+
+	// omxplayer is a wrapper script, its own group lets omxplayer.bin be killed with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	proc, err := p.start(cmd)
+	if err != nil {
+		p.err = err
+		p.state.Transition(StateFailed)
+		p.Unlock()
+
+		sendEvent(p.events, PlayerEvent{Type: EventFailed, File: file, Error: err})
+		return err
+	}
+	p.Unlock()
+
+	timer := time.NewTimer(startupTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-proc.stream.Ready():
+	case <-timer.C:
+		log.Println("omxplayer did not report playback position in", startupTimeout)
+	case <-proc.done:
+		// Process exited during startup, nil error means it was stopped
+		return proc.err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	// Playback could be stopped while waiting
+	if p.proc != proc || p.state != StateStarting {
+		return nil
+	}
+
+	p.state.Transition(StatePlaying)
+	if !restart {
+		sendEvent(p.events, PlayerEvent{Type: EventStarted, File: file})
+	}
+
+	// Embedded subtitles always start without delay
+	if opts.Subtitles == "" && opts.SubtitleDelay != 0 {
+		p.opts.SubtitleDelay = 0
+		if err := p.stepSubtitleDelay(opts.SubtitleDelay); err != nil {
+			log.Println("Cant set subtitle delay:", err)
+		}
+	}
+
+	return nil
 }
 
-// Start omxplayer playback for a given video file. Returns error if start fails.
-func omxPlay(file string) error {
-	Omx = exec.Command(
-		OmxPath,       // path to omxplayer executable
+// Build omxplayer command line arguments
+func omxArgs(file string, opts PlayOptions) []string {
+	audio := opts.AudioDevice
+	if audio == "" {
+		audio = "hdmi"
+	}
+
+	args := []string{
 		"--stats",     // print stats to stdout (buffers, time, etc)
 		"--with-info", // print stats about streams before playback
-		"--refresh",   // adjust framerate/resolution to video
 		"--blank",     // set background to black
 		"--adev",      // audio out device
-		"hdmi",        // using hdmi for audio/video
-		file,          // path to video file
-	)
+		audio,
+	}
+
+	video := opts.Video
+	if !video.NoRefresh {
+		args = append(args, "--refresh") // adjust framerate/resolution to video
+	}
+	if video.Display != 0 {
+		args = append(args, "--display", strconv.Itoa(video.Display))
+	}
+	if video.AspectMode != "" {
+		args = append(args, "--aspect-mode", video.AspectMode)
+	}
+	if video.Window != "" {
+		args = append(args, "--win", video.Window)
+	}
+	if video.Orientation != 0 {
+		args = append(args, "--orientation", strconv.Itoa(video.Orientation))
+	}
+	if video.Layer != 0 {
+		args = append(args, "--layer", strconv.Itoa(video.Layer))
+	}
+
+	if opts.Position > 0 {
+		args = append(args, "--pos", durationFromSeconds(uint64(opts.Position.Seconds())))
+	}
+
+	if opts.Volume != 0 {
+		// Initial volume is set in millibels
+		args = append(args, "--vol", strconv.Itoa(int(math.Round(opts.Volume*100))))
+	}
+
+	// Track indexes start at 1
+	tracks := opts.Tracks
+	if tracks.AudioLang != "" {
+		args = append(args, "--alang", tracks.AudioLang)
+	}
+	if tracks.SubtitleLang != "" {
+		args = append(args, "--slang", tracks.SubtitleLang)
+	}
+	if tracks.Audio != nil {
+		args = append(args, "--aidx", strconv.Itoa(*tracks.Audio+1))
+	}
+	if tracks.Subtitle != nil && *tracks.Subtitle >= 0 {
+		args = append(args, "--sid", strconv.Itoa(*tracks.Subtitle+1))
+	}
+	if opts.Subtitles != "" {
+		args = append(args, "--subtitles", opts.Subtitles)
+	}
+
+	style := opts.SubtitleStyle
+	if style.FontSize != 0 {
+		args = append(args, "--font-size", strconv.Itoa(style.FontSize))
+	}
+	if style.Align != "" {
+		args = append(args, "--align", style.Align)
+	}
+	if style.Lines != 0 {
+		args = append(args, "--lines", strconv.Itoa(style.Lines))
+	}
+
+	return append(args, file)
+}
 
+// Setup child process pipes and start execution. Caller must hold the lock.
+func (p *OmxPlayer) start(cmd *exec.Cmd) (*omxProcess, error) {
 	// Grab child process STDIN
-	stdin, err := Omx.StdinPipe()
+	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer stdin.Close()
 
-	stderr, err := Omx.StderrPipe()
+	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer stderr.Close()
 
-	stdout, err := Omx.StdoutPipe()
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer stdout.Close()
-
-	stream = NewStream()
-	go stream.Start(stdout, stderr)
 
 	// Start omxplayer execution.
 	// If successful, something will appear on HDMI display.
-	err = Omx.Start()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	proc := &omxProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		stream: NewStream(),
+		done:   make(chan struct{}),
+	}
+	p.proc = proc
+
+	go p.wait(proc, stdout, stderr)
+
+	return proc, nil
+}
+
+// Wait until child process is finished and notify listeners
+func (p *OmxPlayer) wait(proc *omxProcess, stdout, stderr io.Reader) {
+	defer close(proc.done)
+
+	// All output must be consumed before waiting on the process
+	proc.stream.Start(stdout, stderr)
+
+	err := proc.cmd.Wait()
 	if err != nil {
+		log.Println("Process exited with error:", err)
+	}
+
+	// Make sure nothing is left hanging before accepting new playback
+	omxKill()
+
+	p.Lock()
+	defer p.Unlock()
+
+	event := PlayerEvent{Type: EventFinished, File: p.file}
+
+	// Player exiting before it started playing is always a failure
+	if err == nil && p.state == StateStarting {
+		err = errors.New("Player exited before playback started")
+	}
+
+	switch {
+	case proc.restart:
+		p.state.Transition(StateIdle)
+	case p.state == StateStopping:
+		event.Type = EventStopped
+		event.Position, event.Duration = proc.stream.Position(), proc.stream.Duration()
+		p.state.Transition(StateIdle)
+	case err != nil:
+		proc.err = newPlayError(err, proc.stream.Stderr())
+		event.Type = EventFailed
+		event.Error = proc.err
+		p.err = proc.err
+		p.state.Transition(StateFailed)
+	default:
+		p.state.Transition(StateIdle)
+	}
+
+	if proc.bus != nil {
+		proc.bus.Close()
+	}
+
+	p.proc = nil
+	p.file = ""
+
+	if !proc.restart {
+		sendEvent(p.events, event)
+	}
+}
+
+// Send a command to the omxplayer process
+func (p *OmxPlayer) Command(name string) error {
+	if _, ok := Commands[name]; !ok {
+		return ErrInvalidCommand
+	}
+
+	// Position is remembered by the server, the player only stops
+	if name == "stop" {
+		return p.Stop()
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	// Skip command handling of omx player is not active
+	if p.state != StatePlaying && p.state != StatePaused {
+		return ErrPlayerInactive
+	}
+
+	if err := p.send(name); err != nil {
 		return err
 	}
 
-	// Set current file
-	CurrentFile = file
+	// Track paused state since the pause key only toggles playback
+	if name == "pause" {
+		if p.state == StatePlaying {
+			p.state.Transition(StatePaused)
+		} else {
+			p.state.Transition(StatePlaying)
+		}
+	}
+
+	return nil
+}
+
+// Send command over D-Bus if available, otherwise write it to STDIN. Caller must hold the lock.
+func (p *OmxPlayer) send(name string) error {
+	if bus := p.control(); bus != nil {
+		err := bus.Command(name)
+		if err == nil {
+			return nil
+		}
+		log.Println("omxplayer D-Bus command failed, using stdin:", err)
+		p.disconnect()
+	}
 
-	// Make child's STDIN globally available
-	OmxIn = stdin
+	return p.write(name)
+}
 
-	// Wait until child process is finished
-	err = Omx.Wait()
+// Press a key that is not exposed as a command, over D-Bus when available.
+// Caller must hold the lock.
+func (p *OmxPlayer) press(key omxKey) error {
+	if bus := p.control(); bus != nil {
+		err := bus.Action(key.action)
+		if err == nil {
+			return nil
+		}
+		log.Println("omxplayer D-Bus action failed, using stdin:", err)
+		p.disconnect()
+	}
+
+	if p.proc == nil {
+		return ErrPlayerInactive
+	}
+	_, err := io.WriteString(p.proc.stdin, key.key)
 	if err != nil {
-		fmt.Fprintln(os.Stdout, "Process exited with error:", err)
+		log.Println("Cant write to omxplayer:", err)
 	}
+	return err
+}
 
-	omxCleanup()
+// Get D-Bus control connection for the running process, connecting if needed.
+// Returns nil if the bus is not available. Caller must hold the lock.
+func (p *OmxPlayer) control() *OmxDbus {
+	proc := p.proc
+	if proc == nil {
+		return nil
+	}
+	if proc.bus != nil {
+		return proc.bus
+	}
+
+	// Do not hammer the bus while omxplayer has not set it up yet
+	if time.Since(proc.busAttempt) < omxDbusRetryInterval {
+		return nil
+	}
+	proc.busAttempt = time.Now()
+
+	bus, err := omxDbusConnect()
+	if err != nil {
+		return nil
+	}
+
+	proc.bus = bus
+	return bus
+}
+
+// Drop D-Bus connection of the running process. Caller must hold the lock.
+func (p *OmxPlayer) disconnect() {
+	if p.proc != nil && p.proc.bus != nil {
+		p.proc.bus.Close()
+		p.proc.bus = nil
+	}
+}
+
+// Run a function with D-Bus control connection of the running player
+func (p *OmxPlayer) withBus(fn func(bus *OmxDbus) error) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.state != StatePlaying && p.state != StatePaused {
+		return ErrPlayerInactive
+	}
+
+	bus := p.control()
+	if bus == nil {
+		return ErrOmxDbusUnavailable
+	}
+
+	// Errors reported by omxplayer do not mean the connection is broken
+	err := fn(bus)
+	switch err.(type) {
+	case nil, *DbusError, *OmxStreamError:
+	default:
+		p.disconnect()
+	}
+	return err
+}
+
+// Seek to absolute position. Without D-Bus the player is restarted at the position.
+func (p *OmxPlayer) SetPosition(pos time.Duration) error {
+	err := p.withBus(func(bus *OmxDbus) error {
+		return bus.SetPosition(pos)
+	})
+	if err != ErrOmxDbusUnavailable {
+		return err
+	}
+
+	return p.restart(pos)
+}
+
+// Restart playback of the current file at the given position
+func (p *OmxPlayer) restart(pos time.Duration) error {
+	p.Lock()
+
+	if p.state != StatePlaying && p.state != StatePaused {
+		p.Unlock()
+		return ErrPlayerInactive
+	}
+
+	file := p.file
+	opts := p.opts
+	proc := p.proc
+
+	proc.restart = true
+	p.restarting = true
+	p.state.Transition(StateStopping)
+	p.write("stop")
+	proc.cmd.Process.Kill()
+	p.Unlock()
+
+	if err := proc.waitExit(); err != nil {
+		return err
+	}
+
+	opts.Position = pos
+	return p.play(file, opts, true)
+}
+
+// Set volume in decibels. Without D-Bus the volume is changed with keyboard
+// commands in 3dB steps relative to the launch volume.
+func (p *OmxPlayer) SetVolume(db float64) error {
+	err := p.withBus(func(bus *OmxDbus) error {
+		_, err := bus.SetVolume(db)
+		return err
+	})
+	if err == ErrOmxDbusUnavailable {
+		return p.stepVolume(db)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Keep the volume if the player is restarted
+	p.Lock()
+	p.opts.Volume = db
+	p.Unlock()
+
+	return nil
+}
+
+func (p *OmxPlayer) stepVolume(db float64) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.state != StatePlaying && p.state != StatePaused {
+		return ErrPlayerInactive
+	}
+
+	command := "volume_up"
+	steps := int(math.Round((db - p.opts.Volume) / volumeStep))
+	if steps < 0 {
+		command = "volume_down"
+		steps = -steps
+	}
+
+	for i := 0; i < steps; i++ {
+		if err := p.write(command); err != nil {
+			return err
+		}
+		if command == "volume_up" {
+			p.opts.Volume += volumeStep
+		} else {
+			p.opts.Volume -= volumeStep
+		}
+	}
 
 	return nil
 }
 
-// Write a command string to the omxplayer process's STDIN
-func omxWrite(command string) {
-	if OmxIn == nil {
+// Mute or unmute audio. Requires D-Bus.
+func (p *OmxPlayer) SetMuted(muted bool) error {
+	return p.withBus(func(bus *OmxDbus) error {
+		if muted {
+			return bus.Mute()
+		}
+		return bus.Unmute()
+	})
+}
+
+// Select audio track. Without D-Bus the player is restarted with the track selected.
+func (p *OmxPlayer) SelectAudio(index int) error {
+	err := p.withBus(func(bus *OmxDbus) error {
+		return bus.SelectAudio(index)
+	})
+	if err != ErrOmxDbusUnavailable {
+		if err == nil {
+			p.Lock()
+			p.opts.Tracks.Audio = &index
+			p.Unlock()
+		}
+		return err
+	}
+
+	return p.restartWith(func(opts *PlayOptions) {
+		opts.Tracks.Audio = &index
+	})
+}
+
+// Select subtitle track, -1 hides subtitles. Without D-Bus the player is
+// restarted with the track selected, hiding subtitles requires D-Bus.
+func (p *OmxPlayer) SelectSubtitle(index int) error {
+	err := p.withBus(func(bus *OmxDbus) error {
+		if index < 0 {
+			return bus.HideSubtitles()
+		}
+		if err := bus.SelectSubtitle(index); err != nil {
+			return err
+		}
+		return bus.ShowSubtitles()
+	})
+	if err != ErrOmxDbusUnavailable || index < 0 {
+		if err == nil {
+			p.Lock()
+			p.opts.Tracks.Subtitle = &index
+			p.Unlock()
+		}
+		return err
+	}
+
+	return p.restartWith(func(opts *PlayOptions) {
+		opts.Tracks.Subtitle = &index
+	})
+}
+
+// Offset subtitle timing. External subtitles are shifted and reloaded by
+// restarting the player, embedded ones are adjusted in 250ms steps.
+func (p *OmxPlayer) SetSubtitleDelay(delay time.Duration) error {
+	p.Lock()
+	external := p.opts.Subtitles != ""
+	p.Unlock()
+
+	if external {
+		return p.restartWith(func(opts *PlayOptions) {
+			opts.SubtitleDelay = delay
+		})
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.state != StatePlaying && p.state != StatePaused {
+		return ErrPlayerInactive
+	}
+	return p.stepSubtitleDelay(delay)
+}
+
+// Change subtitle delay with keyboard commands. Caller must hold the lock.
+func (p *OmxPlayer) stepSubtitleDelay(delay time.Duration) error {
+	key, step := omxSubtitleDelayUp, omxSubtitleDelayStep
+	steps := int(math.Round(float64(delay-p.opts.SubtitleDelay) / float64(omxSubtitleDelayStep)))
+	if steps < 0 {
+		key, step = omxSubtitleDelayDown, -omxSubtitleDelayStep
+		steps = -steps
+	}
+
+	for i := 0; i < steps; i++ {
+		if err := p.press(key); err != nil {
+			return err
+		}
+		p.opts.SubtitleDelay += step
+	}
+
+	return nil
+}
+
+// Change playback options and restart playback at the current position
+func (p *OmxPlayer) restartWith(change func(opts *PlayOptions)) error {
+	p.Lock()
+
+	if p.state != StatePlaying && p.state != StatePaused {
+		p.Unlock()
+		return ErrPlayerInactive
+	}
+
+	change(&p.opts)
+	pos := time.Duration(p.proc.stream.Position()) * time.Second
+	p.Unlock()
+
+	return p.restart(pos)
+}
+
+// Write a command string to the omxplayer process's STDIN. Caller must hold the lock.
+func (p *OmxPlayer) write(command string) error {
+	if p.proc == nil {
 		log.Println("Cant write to omxplayer stdin: not setup")
-		return
+		return ErrPlayerInactive
 	}
 
-	_, err := io.WriteString(OmxIn, Commands[command])
+	_, err := io.WriteString(p.proc.stdin, Commands[command])
 	if err != nil {
 		log.Println("Cant write to omxplayer:", err)
 	}
+	return err
 }
 
-// Terminate any running omxplayer processes. Fixes random hangs.
-func omxKill() {
-	exec.Command("killall", "omxplayer.bin").Run()
-	exec.Command("killall", "omxplayer").Run()
+// Stop playback and wait until the player process exits
+func (p *OmxPlayer) Stop() error {
+	p.Lock()
+
+	// Restart checks the flag before starting the new process
+	if p.restarting {
+		p.stopped = true
+	}
+
+	if !p.state.Active() {
+		restarting := p.restarting
+		p.Unlock()
+		if restarting {
+			return nil
+		}
+		return ErrPlayerInactive
+	}
+
+	proc := p.proc
+
+	if p.state != StateStopping {
+		p.state.Transition(StateStopping)
+		p.write("stop")
+
+		// Attempt to kill the process in case it does not respond to the command
+		proc.cmd.Process.Kill()
+	}
+
+	p.Unlock()
+
+	return proc.waitExit()
 }
 
-// Reset internal state and stop any running processes
-func omxCleanup() {
-	Omx = nil
-	OmxIn = nil
-	CurrentFile = ""
+// Wait until the process exits. omxplayer.bin keeps output pipes open when it
+// hangs after the wrapper script is killed, so the whole process group is
+// killed if the process does not exit in time.
+func (proc *omxProcess) waitExit() error {
+	select {
+	case <-proc.done:
+		return nil
+	case <-time.After(omxStopTimeout):
+	}
 
+	log.Println("omxplayer did not exit, killing process group")
+	syscall.Kill(-proc.cmd.Process.Pid, syscall.SIGKILL)
 	omxKill()
 
-	stream = nil
+	select {
+	case <-proc.done:
+		return nil
+	case <-time.After(omxStopTimeout):
+		return errOmxStopTimeout
+	}
+}
+
+func (p *OmxPlayer) Status() PlayerStatus {
+	p.Lock()
+	defer p.Unlock()
+
+	status := PlayerStatus{
+		Running: p.state.Active(),
+		State:   p.state,
+		File:    p.file,
+	}
+
+	if p.err != nil {
+		status.Error = p.err.Error()
+	}
+
+	if p.proc != nil {
+		status.Duration = p.proc.stream.Duration()
+		status.Position = p.proc.stream.Position()
+	}
+
+	return status
+}
+
+func (p *OmxPlayer) Events() <-chan PlayerEvent {
+	return p.events
 }
 
-// Check if player is currently active
-func omxIsActive() bool {
-	return Omx != nil
+// Terminate any running omxplayer processes. Fixes random hangs.
+func omxKill() {
+	exec.Command("killall", "omxplayer.bin").Run()
+	exec.Command("killall", "omxplayer").Run()
 }
 
 // Check if player can play the file
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+const (
+	omxDbusName       = "org.mpris.MediaPlayer2.omxplayer"
+	omxDbusPath       = ObjectPath("/org/mpris/MediaPlayer2")
+	omxDbusPlayer     = "org.mpris.MediaPlayer2.Player"
+	omxDbusProperties = "org.freedesktop.DBus.Properties"
+)
+
+var (
+	// File where omxplayer writes the address of its session bus
+	omxDbusAddressFile = "/tmp/omxplayerdbus." + currentUsername()
+
+	// How often to retry connecting to the bus when it is not available
+	omxDbusRetryInterval = time.Second
+
+	ErrOmxDbusUnavailable = errors.New("omxplayer D-Bus interface is not available")
+
+	errOmxDbusReply = errors.New("Unexpected omxplayer D-Bus reply")
+)
+
+// OMXPlayer key actions accepted by the Action method, see KeyConfig.h
+var omxDbusActions = map[string]int32{
+	"pause":             16,
+	"volume_up":         18,
+	"volume_down":       17,
+	"subtitles":         12,
+	"seek_back":         19,
+	"seek_back_fast":    21,
+	"seek_forward":      20,
+	"seek_forward_fast": 22,
+
+	"speed_down":          1,
+	"speed_up":            2,
+	"info":                5,
+	"audio_previous":      6,
+	"audio_next":          7,
+	"chapter_previous":    8,
+	"chapter_next":        9,
+	"subtitle_previous":   10,
+	"subtitle_next":       11,
+	"subtitle_delay_down": 13,
+	"subtitle_delay_up":   14,
+}
+
+// OmxDbus controls omxplayer over its MPRIS D-Bus interface
+type OmxDbus struct {
+	conn *DbusConn
+}
+
+func currentUsername() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "root"
+}
+
+// Connect to the session bus used by omxplayer
+func omxDbusConnect() (*OmxDbus, error) {
+	data, err := ioutil.ReadFile(omxDbusAddressFile)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dbusDial(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &OmxDbus{conn: conn}, nil
+}
+
+func (d *OmxDbus) Close() error {
+	return d.conn.Close()
+}
+
+func (d *OmxDbus) call(iface, method string, args ...interface{}) ([]interface{}, error) {
+	return d.conn.Call(omxDbusName, omxDbusPath, iface, method, args...)
+}
+
+// Trigger a keyboard action by command name
+func (d *OmxDbus) Command(name string) error {
+	action, ok := omxDbusActions[name]
+	if !ok {
+		return ErrInvalidCommand
+	}
+	return d.Action(action)
+}
+
+// Send key action, see KeyConfig.h
+func (d *OmxDbus) Action(action int32) error {
+	_, err := d.call(omxDbusPlayer, "Action", action)
+	return err
+}
+
+// Seek to absolute position
+func (d *OmxDbus) SetPosition(pos time.Duration) error {
+	_, err := d.call(omxDbusPlayer, "SetPosition", ObjectPath("/not/used"), int64(pos/time.Microsecond))
+	return err
+}
+
+// Set volume in decibels, returns the volume applied by the player
+func (d *OmxDbus) SetVolume(db float64) (float64, error) {
+	return d.volume(math.Pow(10, db/20))
+}
+
+// Volume property is linear, where 1.0 is 0dB. Passing a value changes the volume.
+func (d *OmxDbus) volume(args ...interface{}) (float64, error) {
+	reply, err := d.call(omxDbusProperties, "Volume", args...)
+	if err != nil {
+		return 0, err
+	}
+	if len(reply) == 0 {
+		return 0, errOmxDbusReply
+	}
+	value, ok := reply[0].(float64)
+	if !ok {
+		return 0, errOmxDbusReply
+	}
+	if value <= 0 {
+		return math.Inf(-1), nil
+	}
+	return 20 * math.Log10(value), nil
+}
+
+func (d *OmxDbus) Mute() error {
+	_, err := d.call(omxDbusPlayer, "Mute")
+	return err
+}
+
+func (d *OmxDbus) Unmute() error {
+	_, err := d.call(omxDbusPlayer, "Unmute")
+	return err
+}
+
+func (d *OmxDbus) SelectAudio(index int) error {
+	return d.selectStream("SelectAudio", index)
+}
+
+func (d *OmxDbus) SelectSubtitle(index int) error {
+	return d.selectStream("SelectSubtitle", index)
+}
+
+func (d *OmxDbus) ShowSubtitles() error {
+	_, err := d.call(omxDbusPlayer, "ShowSubtitles")
+	return err
+}
+
+func (d *OmxDbus) HideSubtitles() error {
+	_, err := d.call(omxDbusPlayer, "HideSubtitles")
+	return err
+}
+
+func (d *OmxDbus) selectStream(method string, index int) error {
+	reply, err := d.call(omxDbusPlayer, method, int32(index))
+	if err != nil {
+		return err
+	}
+	if len(reply) > 0 && reply[0] == false {
+		return &OmxStreamError{index}
+	}
+	return nil
+}
+
+// OmxStreamError is returned when omxplayer rejects stream selection
+type OmxStreamError struct {
+	Index int
+}
+
+func (e *OmxStreamError) Error() string {
+	return fmt.Sprintf("Stream %d is not available", e.Index)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const dbusTestConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:tmpdir=/tmp</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>`
+
+// Mock omxplayer D-Bus service recording all method calls
+type omxDbusMock struct {
+	sync.Mutex
+	calls  []string
+	args   [][]interface{}
+	volume float64
+}
+
+func (m *omxDbusMock) handle(msg *dbusMessage) ([]interface{}, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.calls = append(m.calls, msg.Member)
+	m.args = append(m.args, msg.Body)
+
+	switch msg.Member {
+	case "SetPosition":
+		return []interface{}{msg.Body[len(msg.Body)-1]}, nil
+	case "Volume":
+		if len(msg.Body) > 0 {
+			m.volume = msg.Body[0].(float64)
+		}
+		return []interface{}{m.volume}, nil
+	case "SelectAudio", "SelectSubtitle":
+		return []interface{}{msg.Body[0].(int32) < 2}, nil
+	case "Action", "Mute", "Unmute", "ShowSubtitles", "HideSubtitles":
+		return nil, nil
+	}
+
+	return nil, &DbusError{Name: "org.freedesktop.DBus.Error.UnknownMethod", Message: msg.Member}
+}
+
+func (m *omxDbusMock) lastCall() (string, []interface{}) {
+	m.Lock()
+	defer m.Unlock()
+
+	if len(m.calls) == 0 {
+		return "", nil
+	}
+	return m.calls[len(m.calls)-1], m.args[len(m.args)-1]
+}
+
+// Start a private dbus-daemon with mock omxplayer service registered on it.
+// Address of the bus is written into omxDbusAddressFile.
+func startOmxDbusMock(t *testing.T) (*omxDbusMock, func()) {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "omxremote-dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := filepath.Join(dir, "session.conf")
+	ioutil.WriteFile(config, []byte(dbusTestConfig), 0644)
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--print-address", "--nofork")
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatal(err)
+	}
+
+	addressFile := omxDbusAddressFile
+	omxDbusAddressFile = filepath.Join(dir, "omxplayerdbus")
+	ioutil.WriteFile(omxDbusAddressFile, []byte(address), 0644)
+
+	conn, err := dbusDial(strings.TrimSpace(address))
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatal(err)
+	}
+
+	mock := &omxDbusMock{volume: 1.0}
+	conn.Handle(mock.handle)
+
+	if err := conn.RequestName(omxDbusName); err != nil {
+		t.Fatal(err)
+	}
+
+	return mock, func() {
+		conn.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		omxDbusAddressFile = addressFile
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_OmxDbus(t *testing.T) {
+	mock, cleanup := startOmxDbusMock(t)
+	defer cleanup()
+
+	bus, err := omxDbusConnect()
+	assert.NoError(t, err)
+	defer bus.Close()
+
+	assert.NoError(t, bus.SetPosition(90*time.Second))
+	method, args := mock.lastCall()
+	assert.Equal(t, "SetPosition", method)
+	assert.Equal(t, []interface{}{ObjectPath("/not/used"), int64(90000000)}, args)
+
+	db, err := bus.SetVolume(-6)
+	assert.NoError(t, err)
+	assert.InDelta(t, -6, db, 0.001)
+	_, args = mock.lastCall()
+	assert.InDelta(t, 0.501, args[0].(float64), 0.001)
+
+	assert.NoError(t, bus.SelectAudio(1))
+	assert.Error(t, bus.SelectAudio(5))
+
+	assert.NoError(t, bus.Command("seek_forward_fast"))
+	method, args = mock.lastCall()
+	assert.Equal(t, "Action", method)
+	assert.Equal(t, []interface{}{int32(22)}, args)
+
+	assert.Equal(t, ErrInvalidCommand, bus.Command("foo"))
+}
+
+func Test_OmxPlayerDbus(t *testing.T) {
+	mock, cleanup := startOmxDbusMock(t)
+	defer cleanup()
+
+	p := NewOmxPlayer(os.Args[0])
+	assert.NoError(t, p.Play("/media/movie.mkv", PlayOptions{}))
+	defer p.Stop()
+
+	// Commands are sent over D-Bus instead of STDIN
+	assert.NoError(t, p.Command("seek_back"))
+	method, args := mock.lastCall()
+	assert.Equal(t, "Action", method)
+	assert.Equal(t, []interface{}{int32(19)}, args)
+
+	assert.NoError(t, p.Command("pause"))
+	assert.Equal(t, StatePaused, p.Status().State)
+
+	assert.NoError(t, p.SetPosition(5*time.Minute))
+	method, args = mock.lastCall()
+	assert.Equal(t, "SetPosition", method)
+	assert.Equal(t, int64(300000000), args[1])
+
+	assert.NoError(t, p.SetVolume(-3))
+	_, args = mock.lastCall()
+	assert.InDelta(t, 0.708, args[0].(float64), 0.001)
+	assert.Equal(t, -3.0, p.opts.Volume)
+
+	assert.NoError(t, p.SetMuted(true))
+
+	assert.NoError(t, p.SelectAudio(1))
+	method, args = mock.lastCall()
+	assert.Equal(t, "SelectAudio", method)
+	assert.Equal(t, []interface{}{int32(1)}, args)
+	assert.Error(t, p.SelectAudio(5))
+
+	assert.NoError(t, p.SelectSubtitle(0))
+	method, _ = mock.lastCall()
+	assert.Equal(t, "ShowSubtitles", method)
+	assert.NoError(t, p.SelectSubtitle(-1))
+	method, _ = mock.lastCall()
+	assert.Equal(t, "HideSubtitles", method)
+	assert.Equal(t, -1, *p.opts.Tracks.Subtitle)
+}
+
+func Test_OmxPlayerDbusUnavailable(t *testing.T) {
+	addressFile := omxDbusAddressFile
+	omxDbusAddressFile = "/nonexistent/omxplayerdbus"
+	defer func() { omxDbusAddressFile = addressFile }()
+
+	p := NewOmxPlayer(os.Args[0])
+	assert.NoError(t, p.Play("/media/movie.mkv", PlayOptions{}))
+	defer p.Stop()
+
+	// Falls back to keyboard commands
+	assert.NoError(t, p.Command("seek_forward"))
+	assert.Equal(t, ErrOmxDbusUnavailable, p.SetMuted(true))
+
+	// Volume is changed in steps with keyboard commands
+	assert.NoError(t, p.SetVolume(-7))
+	assert.Equal(t, -6.0, p.opts.Volume)
+
+	assert.Equal(t, EventStarted, waitEvent(t, p).Type)
+
+	// Absolute seek restarts the player at the requested position
+	assert.NoError(t, p.SetPosition(time.Minute))
+	assert.Equal(t, StatePlaying, p.Status().State)
+	assert.Equal(t, "/media/movie.mkv", p.Status().File)
+	assert.Equal(t, time.Minute, p.opts.Position)
+	assert.Equal(t, -6.0, p.opts.Volume)
+
+	// Track selection restarts the player with the track selected
+	assert.Equal(t, ErrOmxDbusUnavailable, p.SelectSubtitle(-1))
+	assert.NoError(t, p.SelectAudio(1))
+	assert.Equal(t, StatePlaying, p.Status().State)
+	assert.Equal(t, 1, *p.opts.Tracks.Audio)
+
+	select {
+	case event := <-p.Events():
+		t.Errorf("unexpected event on restart: %v", event)
+	default:
+	}
+}
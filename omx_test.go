@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// When set, the test binary acts as a fake media player executable
+const fakePlayerEnv = "OMXREMOTE_FAKE_PLAYER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakePlayerEnv) == "1" {
+		args := os.Args[1:]
+
+		switch {
+		case strings.HasPrefix(args[0], "--input-ipc-server="):
+			fakeMpv(args)
+		case args[0] == "--intf":
+			fakeVlc(args)
+		case args[0] == "-hide_banner":
+			fakeFFprobe(args)
+		default:
+			fakeOmxplayer(args)
+		}
+		os.Exit(0)
+	}
+
+	// Never talk to a real omxplayer session bus
+	omxDbusAddressFile = "/nonexistent/omxplayerdbus"
+
+	os.Setenv(fakePlayerEnv, "1")
+	os.Exit(m.Run())
+}
+
+// Mimic omxplayer output and keyboard controls. Files named "broken" fail to
+// open, files named "short" finish after a few position updates.
+func fakeOmxplayer(args []string) {
+	file := args[len(args)-1]
+
+	// Media info is printed from the fixture, valid --info exits with status 1
+	if args[0] == "--info" {
+		data, _ := ioutil.ReadFile("testdata/info_mkv.txt")
+		os.Stderr.Write(data)
+		os.Exit(1)
+	}
+
+	if strings.Contains(file, "broken") {
+		fmt.Fprintln(os.Stderr, "Invalid framerate 0, using forced 25fps and just trust timestamps")
+		fmt.Fprintln(os.Stderr, "have a nice day ;)")
+		os.Exit(1)
+	}
+
+	// Like a hung omxplayer.bin, the child keeps output open after the player exits
+	if strings.Contains(file, "hung") {
+		child := exec.Command("sleep", "30")
+		child.Stdout = os.Stdout
+		child.Start()
+	}
+
+	fmt.Fprintln(os.Stderr, "Input #0, matroska,webm, from '"+file+"':")
+	fmt.Fprintln(os.Stderr, "  Duration: 00:01:40.00, start: 0.000000, bitrate: 1000 kb/s")
+
+	lock := sync.Mutex{}
+	paused := false
+
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				return
+			}
+			switch buf[0] {
+			case 'q':
+				os.Exit(0)
+			case 'p':
+				lock.Lock()
+				paused = !paused
+				lock.Unlock()
+			}
+		}
+	}()
+
+	ticks := 1000
+	if strings.Contains(file, "short") {
+		ticks = 5
+	}
+
+	pos := 0
+	for i := 0; i < ticks; i++ {
+		lock.Lock()
+		if !paused {
+			pos++
+		}
+		lock.Unlock()
+
+		fmt.Printf("M:%d V:  0 %d\r", pos*1000000, pos)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitEvent(t *testing.T, p Player) PlayerEvent {
+	select {
+	case event := <-p.Events():
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for player event")
+	}
+	return PlayerEvent{}
+}
+
+func Test_OmxPlayer(t *testing.T) {
+	p := NewOmxPlayer(os.Args[0])
+
+	assert.Equal(t, StateIdle, p.Status().State)
+	assert.Equal(t, ErrPlayerInactive, p.Command("pause"))
+	assert.Equal(t, ErrPlayerInactive, p.Stop())
+	assert.Equal(t, ErrInvalidCommand, p.Command("foo"))
+
+	assert.NoError(t, p.Play("/media/movie.mkv", PlayOptions{}))
+	assert.Equal(t, EventStarted, waitEvent(t, p).Type)
+	assert.Equal(t, ErrPlayerActive, p.Play("/media/movie.mkv", PlayOptions{}))
+
+	status := p.Status()
+	assert.Equal(t, true, status.Running)
+	assert.Equal(t, StatePlaying, status.State)
+	assert.Equal(t, "/media/movie.mkv", status.File)
+
+	assert.NoError(t, p.Command("pause"))
+	assert.Equal(t, StatePaused, p.Status().State)
+	assert.NoError(t, p.Command("pause"))
+	assert.Equal(t, StatePlaying, p.Status().State)
+
+	// Wait for the duration line to be parsed
+	for i := 0; i < 100 && p.Status().Duration == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, uint64(100), p.Status().Duration)
+
+	assert.NoError(t, p.Stop())
+	assert.Equal(t, EventStopped, waitEvent(t, p).Type)
+
+	status = p.Status()
+	assert.Equal(t, false, status.Running)
+	assert.Equal(t, StateIdle, status.State)
+	assert.Equal(t, "", status.File)
+}
+
+func Test_OmxPlayerStopDuringRestart(t *testing.T) {
+	p := NewOmxPlayer(os.Args[0])
+
+	// Old process has exited, the new one is not started yet
+	p.Lock()
+	p.restarting = true
+	p.Unlock()
+
+	assert.NoError(t, p.Stop())
+	assert.NoError(t, p.play("/media/movie.mkv", PlayOptions{}, true))
+	assert.Equal(t, EventStopped, waitEvent(t, p).Type)
+	assert.Equal(t, StateIdle, p.Status().State)
+	assert.Equal(t, ErrPlayerInactive, p.Stop())
+
+	// Restart is not affected by stops before it
+	assert.NoError(t, p.Play("/media/movie.mkv", PlayOptions{}))
+	assert.Equal(t, EventStarted, waitEvent(t, p).Type)
+	assert.NoError(t, p.restart(10*time.Second))
+	assert.True(t, p.Status().Running)
+
+	assert.NoError(t, p.Stop())
+	assert.Equal(t, EventStopped, waitEvent(t, p).Type)
+}
+
+func Test_OmxPlayerSubtitleDelay(t *testing.T) {
+	p := NewOmxPlayer(os.Args[0])
+	assert.Equal(t, ErrPlayerInactive, p.SetSubtitleDelay(time.Second))
+
+	assert.NoError(t, p.Play("/media/movie.mkv", PlayOptions{SubtitleDelay: time.Second}))
+	assert.Equal(t, EventStarted, waitEvent(t, p).Type)
+
+	// Embedded subtitles are delayed in 250ms steps after start
+	p.Lock()
+	assert.Equal(t, time.Second, p.opts.SubtitleDelay)
+	p.Unlock()
+
+	assert.NoError(t, p.SetSubtitleDelay(-600*time.Millisecond))
+	p.Lock()
+	assert.Equal(t, -500*time.Millisecond, p.opts.SubtitleDelay)
+	p.Unlock()
+
+	assert.NoError(t, p.Stop())
+	assert.Equal(t, EventStopped, waitEvent(t, p).Type)
+}
+
+// Buffer standing in for omxplayer STDIN
+type keyBuffer struct {
+	bytes.Buffer
+}
+
+func (b *keyBuffer) Close() error { return nil }
+
+func Test_OmxPlayerStepSubtitleDelay(t *testing.T) {
+	keys := &keyBuffer{}
+	p := NewOmxPlayer(os.Args[0])
+	p.proc = &omxProcess{stdin: keys, busAttempt: time.Now()}
+
+	// Keys are pressed directly, regardless of published commands
+	assert.NoError(t, p.stepSubtitleDelay(500*time.Millisecond))
+	assert.NoError(t, p.stepSubtitleDelay(-250*time.Millisecond))
+	assert.Equal(t, "ffddd", keys.String())
+	assert.Equal(t, -250*time.Millisecond, p.opts.SubtitleDelay)
+}
+
+func Test_OmxPlayerStopHung(t *testing.T) {
+	defer func(timeout time.Duration) { omxStopTimeout = timeout }(omxStopTimeout)
+	omxStopTimeout = 100 * time.Millisecond
+
+	p := NewOmxPlayer(os.Args[0])
+	assert.NoError(t, p.Play("/media/hung.mkv", PlayOptions{}))
+	assert.Equal(t, EventStarted, waitEvent(t, p).Type)
+
+	// Process group is killed when output stays open after the player exits
+	started := time.Now()
+	assert.NoError(t, p.Stop())
+	assert.True(t, time.Since(started) < 2*time.Second)
+	assert.Equal(t, EventStopped, waitEvent(t, p).Type)
+	assert.Equal(t, StateIdle, p.Status().State)
+}
+
+func Test_OmxPlayerFinished(t *testing.T) {
+	p := NewOmxPlayer(os.Args[0])
+
+	assert.NoError(t, p.Play("/media/short.mkv", PlayOptions{}))
+	assert.Equal(t, EventStarted, waitEvent(t, p).Type)
+
+	event := waitEvent(t, p)
+	assert.Equal(t, EventFinished, event.Type)
+	assert.Equal(t, "/media/short.mkv", event.File)
+	assert.Equal(t, StateIdle, p.Status().State)
+}
+
+func Test_OmxPlayerFailed(t *testing.T) {
+	p := NewOmxPlayer(os.Args[0])
+
+	err := p.Play("/media/broken.mkv", PlayOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, EventFailed, waitEvent(t, p).Type)
+
+	playErr, ok := err.(*PlayError)
+	assert.True(t, ok)
+	assert.Equal(t, 1, playErr.ExitCode)
+	assert.Equal(t, "exit status 1", playErr.Error())
+	assert.Equal(t, "have a nice day ;)", playErr.Stderr[len(playErr.Stderr)-1])
+
+	status := p.Status()
+	assert.Equal(t, StateFailed, status.State)
+	assert.Equal(t, "exit status 1", status.Error)
+
+	// Player can be started again after a failure
+	assert.NoError(t, p.Play("/media/movie.mkv", PlayOptions{}))
+	assert.Equal(t, StatePlaying, p.Status().State)
+	assert.NoError(t, p.Stop())
+}
+
+func Test_OmxPlayerStartError(t *testing.T) {
+	p := NewOmxPlayer("/nonexistent/omxplayer")
+
+	assert.Error(t, p.Play("/media/movie.mkv", PlayOptions{}))
+	assert.Equal(t, StateFailed, p.Status().State)
+}
+
+func Test_OmxPlayerConcurrency(t *testing.T) {
+	p := NewOmxPlayer(os.Args[0])
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(4)
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				p.Play("/media/movie.mkv", PlayOptions{})
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				p.Command("pause")
+				p.Command("volume_up")
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				status := p.Status()
+				if status.State == StateIdle {
+					assert.Equal(t, "", status.File)
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				p.Stop()
+			}
+		}()
+	}
+
+	wg.Wait()
+	p.Stop()
+
+	assert.False(t, p.Status().Running)
+}
+
+func Test_omxArgs(t *testing.T) {
+	args := omxArgs("/media/movie.mkv", PlayOptions{})
+	assert.Equal(t, "/media/movie.mkv", args[len(args)-1])
+	assert.NotContains(t, args, "--pos")
+
+	args = omxArgs("/media/movie.mkv", PlayOptions{Position: 4350 * time.Second})
+	assert.Equal(t, []string{"--pos", "01:12:30", "/media/movie.mkv"}, args[len(args)-3:])
+
+	args = omxArgs("/media/movie.mkv", PlayOptions{})
+	assert.Equal(t, []string{"--adev", "hdmi", "--refresh"}, args[3:6])
+
+	args = omxArgs("/media/movie.mkv", PlayOptions{AudioDevice: "alsa:hw:1,0"})
+	assert.Equal(t, []string{"--adev", "alsa:hw:1,0"}, args[3:5])
+
+	args = omxArgs("/media/movie.mkv", PlayOptions{Video: VideoOptions{
+		Display:     7,
+		AspectMode:  "stretch",
+		Window:      "0,0,1280,720",
+		Orientation: 90,
+		Layer:       2,
+		NoRefresh:   true,
+	}})
+	assert.Equal(t, []string{
+		"--stats", "--with-info", "--blank", "--adev", "hdmi",
+		"--display", "7",
+		"--aspect-mode", "stretch",
+		"--win", "0,0,1280,720",
+		"--orientation", "90",
+		"--layer", "2",
+		"/media/movie.mkv",
+	}, args)
+
+	args = omxArgs("/media/movie.mkv", PlayOptions{Volume: -6})
+	assert.Equal(t, []string{"--vol", "-600", "/media/movie.mkv"}, args[len(args)-3:])
+
+	audio, subtitle := 1, 0
+	args = omxArgs("/media/movie.mkv", PlayOptions{Tracks: TrackOptions{AudioLang: "jpn", SubtitleLang: "eng"}})
+	assert.Equal(t, []string{"--alang", "jpn", "--slang", "eng", "/media/movie.mkv"}, args[len(args)-5:])
+	args = omxArgs("/media/movie.mkv", PlayOptions{Tracks: TrackOptions{Audio: &audio, Subtitle: &subtitle}})
+	assert.Equal(t, []string{"--aidx", "2", "--sid", "1", "/media/movie.mkv"}, args[len(args)-5:])
+
+	args = omxArgs("/media/movie.mkv", PlayOptions{Subtitles: "/data/subtitles/movie.srt"})
+	assert.Equal(t, []string{"--subtitles", "/data/subtitles/movie.srt", "/media/movie.mkv"}, args[len(args)-3:])
+
+	args = omxArgs("/media/movie.mkv", PlayOptions{SubtitleStyle: SubtitleStyle{FontSize: 40, Align: "left", Lines: 2}})
+	assert.Equal(t, []string{"--font-size", "40", "--align", "left", "--lines", "2", "/media/movie.mkv"}, args[len(args)-7:])
+}
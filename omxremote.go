@@ -2,17 +2,20 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,131 +27,1215 @@ type Response struct {
 	Message string `json:"message"`
 }
 
+type CommandInfo struct {
+	Name        string `json:"name"`
+	Key         string `json:"-"` // omxplayer keyboard key, empty for server commands
+	Description string `json:"description"`
+}
+
+type PlayErrorResponse struct {
+	Response
+	ExitCode int      `json:"exit_code"` // Player process exit code
+	Stderr   []string `json:"stderr"`    // Last lines of player error output
+}
+
+type SeekResponse struct {
+	Response
+	Position string `json:"position"` // Resulting position
+	Seconds  uint64 `json:"seconds"`  // Resulting position in seconds
+}
+
+type SubtitleDelayRequest struct {
+	Delay  *int64 `json:"delay"`  // Delay in milliseconds
+	Offset *int64 `json:"offset"` // Change of the current delay in milliseconds
+}
+
+type SubtitleDelayResponse struct {
+	Response
+	Delay int64 `json:"delay"` // Resulting delay in milliseconds
+}
+
+type VolumeRequest struct {
+	DB      *float64 `json:"db"`      // Volume in decibels, -60 to 0
+	Percent *float64 `json:"percent"` // Volume on 0-100 scale
+	Muted   *bool    `json:"muted"`   // Mute or unmute audio
+}
+
+type VolumeResponse struct {
+	DB      float64 `json:"db"`      // Volume in decibels
+	Percent int     `json:"percent"` // Volume on 0-100 scale
+	Muted   bool    `json:"muted"`   // True if audio is muted
+}
+
+type TrackRequest struct {
+	Index *int `json:"index"` // Track index, -1 disables subtitles
+}
+
+type TracksResponse struct {
+	Audio         []AudioStream    `json:"audio"`          // Audio tracks of the playing file
+	Subtitles     []SubtitleStream `json:"subtitles"`      // Subtitle tracks of the playing file
+	AudioIndex    int              `json:"audio_index"`    // Selected audio track
+	SubtitleIndex int              `json:"subtitle_index"` // Selected subtitle track, -1 if disabled
+}
+
+type QueueRequest struct {
+	File  string `json:"file"`  // Media file to add
+	Index *int   `json:"index"` // Position in the queue, appends if not set
+}
+
+type QueueMoveRequest struct {
+	From int `json:"from"` // Index of the item to move
+	To   int `json:"to"`   // New index of the item
+}
+
+type QueueModeRequest struct {
+	Repeat  *string `json:"repeat"`  // Repeat mode: off, one, all
+	Shuffle *bool   `json:"shuffle"` // Shuffle queued items
+}
+
+type QueueItem struct {
+	File string `json:"file"` // Path relative to the media directory
+	Name string `json:"name"` // Titleized filename
+}
+
+type QueueResponse struct {
+	Items   []QueueItem `json:"items"`   // Queued media files
+	Current int         `json:"current"` // Index of the playing item, -1 if none
+	Repeat  string      `json:"repeat"`  // Repeat mode: off, one, all
+	Shuffle bool        `json:"shuffle"` // True if items are shuffled
+}
+
+type NextUpResponse struct {
+	File      string `json:"file"`      // Path relative to the media directory
+	Name      string `json:"name"`      // Titleized filename
+	Season    int    `json:"season"`    // Season number, 0 if unknown
+	Episode   int    `json:"episode"`   // Episode number
+	Countdown int    `json:"countdown"` // Seconds until automatic playback, 0 if disabled
+}
+
 type StatusResponse struct {
 	Running  bool   `json:"running"`            // True if player is running
+	State    string `json:"state"`              // Playback state, i.e. "playing"
+	Paused   bool   `json:"paused"`             // True if playback is paused
 	File     string `json:"file"`               // Path to current media file
 	Name     string `json:"name"`               // Titleized filename
 	Position string `json:"position,omitempty"` // Current position in the movie
 	Duration string `json:"duration,omitempty"` // Movie duration
+	Error    string `json:"error,omitempty"`    // Last playback error
+
+	Volume VolumeResponse  `json:"volume"`            // Current volume
+	NextUp *NextUpResponse `json:"next_up,omitempty"` // Episode offered to play next
+	Media  *MediaName      `json:"media,omitempty"`   // Metadata parsed from the filename
+
+	Part  int `json:"part,omitempty"`  // Playing part of a multi-part movie
+	Parts int `json:"parts,omitempty"` // Number of parts of a multi-part movie
+
+	Chapter string `json:"chapter,omitempty"` // Title of the current chapter
+}
+
+type ChaptersResponse struct {
+	Chapters []Chapter `json:"chapters"` // Chapters of the playing file
+	Current  int       `json:"current"`  // Index of the current chapter, -1 if none
 }
 
 type FileEntry struct {
 	Filename string `json:"filename"`
 	IsDir    bool   `json:"directory"`
+	Position uint64 `json:"position,omitempty"` // Saved playback position in seconds
+	Duration uint64 `json:"duration,omitempty"` // Media duration in seconds
+	Watched  bool   `json:"watched"`            // True if media was watched
+
+	Parts []string   `json:"parts,omitempty"` // All parts of a multi-part movie
+	Media *MediaName `json:"media,omitempty"` // Metadata parsed from the filename
+}
+
+var (
+	// Regular expression to match all supported video files
+	RegexFormats = regexp.MustCompile(`.(avi|mpg|mov|flv|wmv|asf|mpeg|m4v|divx|mp4|ogm|mkv|mp4)$`)
+
+	// Commands accepted by /command/:name in the order they are published. Keys
+	// are piped via STDIN to omxplayer process, commands without a key are
+	// handled by the server.
+	CommandList = []CommandInfo{
+		{"pause", "p", "Pause/continue playback"},
+		{"stop", "q", "Stop playback"},
+		{"volume_up", "+", "Change volume by +3dB"},
+		{"volume_down", "-", "Change volume by -3dB"},
+		{"subtitles", "s", "Enable/disable subtitles"},
+		{"seek_back", "\x1b\x5b\x44", "Seek -30 seconds"},
+		{"seek_back_fast", "\x1b\x5b\x42", "Seek -600 seconds"},
+		{"seek_forward", "\x1b\x5b\x43", "Seek +30 seconds"},
+		{"seek_forward_fast", "\x1b\x5b\x41", "Seek +600 seconds"},
+		{"speed_down", "1", "Decrease playback speed"},
+		{"speed_up", "2", "Increase playback speed"},
+		{"chapter_previous", "i", "Jump to previous chapter"},
+		{"chapter_next", "o", "Jump to next chapter"},
+		{"audio_previous", "j", "Switch to previous audio track"},
+		{"audio_next", "k", "Switch to next audio track"},
+		{"subtitle_previous", "n", "Switch to previous subtitle track"},
+		{"subtitle_next", "m", "Switch to next subtitle track"},
+		{"subtitle_delay_down", "d", "Show subtitles 250ms earlier"},
+		{"subtitle_delay_up", "f", "Show subtitles 250ms later"},
+		{"info", "z", "Show media info on screen"},
+		{"next", "", "Play next item in the queue"},
+		{"previous", "", "Play previous item in the queue"},
+	}
+
+	// OMXPlayer control commands by name
+	Commands = commandKeys(CommandList)
+
+	MediaPath    string              // Path where all media files are stored
+	DataPath     string              // Path where server state is stored
+	OmxPath      string              // Path to omxplayer executable
+	PlayerName   string              // Media player backend name
+	AudioOutput  string              // Default audio output device
+	VideoLayout  VideoOptions        // Default video layout
+	SubStyle     SubtitleStyle       // Default subtitle style
+	Zeroconf     bool                // Enable Zeroconf discovery
+	Frontend     bool                // Serve frontend app
+	printVersion bool                // Print version and exit
+	player       Player              // Media player backend
+	volume       *Volume             // Volume shared by all playback sessions
+	progress     *ProgressStore      // Playback progress of media files
+	queue        *Queue              // Media files to play one after another
+	nextUp       = &NextUp{}         // Episode offered after the current one finished
+	stack        = &StackPlayback{}  // Multi-part movie being played
+	probes       *ProbeCache         // Cached media information
+	tracks       = &TrackSelection{} // Tracks selected for the current playback
+	languages    *LanguageProfile    // Server-wide language preferences
+	dialogue     = &DialogueIndex{}  // Subtitle text of the library for searching
+	autoplayNext bool                // Play next episode automatically
+	autoplayWait time.Duration       // Delay before the next episode starts
+)
+
+func httpBrowse(c *gin.Context) {
+	path := c.Request.FormValue("path")
+
+	if path != "" {
+		path = fmt.Sprintf("%s/%s", MediaPath, path)
+	} else {
+		path = MediaPath
+	}
+
+	entries := scanPath(path)
+
+	if preprobe && canProbe() {
+		files := []string{}
+		for _, entry := range entries {
+			if entry.IsDir {
+				continue
+			}
+
+			names := entry.Parts
+			if len(names) == 0 {
+				names = []string{entry.Filename}
+			}
+			for _, name := range names {
+				files = append(files, filepath.Join(path, name))
+			}
+		}
+		probes.Prefetch(files)
+	}
+
+	for i, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+
+		entries[i].Media = mediaName(filepath.Join(path, entry.Filename))
+
+		if len(entry.Parts) > 0 {
+			if item, ok := stackProgress(path, entry.Parts); ok {
+				entries[i].Position = item.Position
+				entries[i].Duration = item.Duration
+				entries[i].Watched = item.Watched
+			}
+			continue
+		}
+		if item, ok := progress.Get(filepath.Join(path, entry.Filename)); ok {
+			entries[i].Position = item.Position
+			entries[i].Duration = item.Duration
+			entries[i].Watched = item.Watched
+		}
+	}
+
+	c.JSON(200, entries)
+}
+
+func httpCommand(c *gin.Context) {
+	val := c.Params.ByName("command")
+
+	// Queue navigation is handled by the server
+	if val == "next" || val == "previous" {
+		offset := 1
+		if val == "previous" {
+			offset = -1
+		}
+
+		if err := playQueueStep(offset); err != nil {
+			playErrorResponse(c, err)
+			return
+		}
+
+		c.JSON(200, Response{true, "OK"})
+		return
+	}
+
+	if _, ok := Commands[val]; !ok {
+		c.JSON(400, Response{false, "Invalid command"})
+		return
+	}
+
+	fmt.Println("Received command:", val)
+
+	// Subtitle delay is tracked by the server so it is remembered for the file
+	if val == "subtitle_delay_up" || val == "subtitle_delay_down" {
+		status := player.Status()
+		if status.State != StatePlaying && status.State != StatePaused {
+			c.JSON(400, Response{false, ErrPlayerInactive.Error()})
+			return
+		}
+
+		step := int64(subtitleDelayStep / time.Millisecond)
+		if val == "subtitle_delay_down" {
+			step = -step
+		}
+
+		item, _ := progress.Get(status.File)
+		if err := applySubtitleDelay(status.File, item.SubtitleDelay+step); err != nil {
+			c.JSON(400, Response{false, err.Error()})
+			return
+		}
+
+		c.JSON(200, Response{true, "OK"})
+		return
+	}
+
+	// Volume is tracked by the server so it can be restored on the next launch
+	if val == "volume_up" || val == "volume_down" {
+		if !player.Status().Running {
+			c.JSON(400, Response{false, ErrPlayerInactive.Error()})
+			return
+		}
+
+		level, muted := volume.Get()
+		if val == "volume_up" {
+			level = math.Min(level+volumeStep, volumeMax)
+		} else {
+			level = math.Max(level-volumeStep, volumeMin)
+		}
+
+		if err := applyVolume(level, muted); err != nil {
+			c.JSON(400, Response{false, err.Error()})
+			return
+		}
+
+		c.JSON(200, Response{true, "OK"})
+		return
+	}
+
+	// Tracks are cycled by the server when streams are known, so selection is tracked
+	if kind, step, ok := trackCycleCommand(val); ok {
+		if _, info, err := playingFileInfo(); err == nil {
+			if err := cycleTrack(info, kind, step); err != nil {
+				c.JSON(400, Response{false, err.Error()})
+				return
+			}
+
+			c.JSON(200, Response{true, "OK"})
+			return
+		}
+	}
+
+	// Handle requested commmand
+	if err := player.Command(val); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	c.JSON(200, Response{true, "OK"})
+}
+
+// Map commands that have a key to the key
+func commandKeys(list []CommandInfo) map[string]string {
+	keys := map[string]string{}
+	for _, command := range list {
+		if command.Key != "" {
+			keys[command.Name] = command.Key
+		}
+	}
+	return keys
+}
+
+// List commands accepted by /command/:name
+// GET /commands
+func httpCommands(c *gin.Context) {
+	c.JSON(200, CommandList)
+}
+
+// Track type and direction of track cycling commands
+func trackCycleCommand(name string) (string, int, bool) {
+	switch name {
+	case "audio_previous":
+		return "audio", -1, true
+	case "audio_next":
+		return "audio", 1, true
+	case "subtitle_previous":
+		return "subtitle", -1, true
+	case "subtitle_next":
+		return "subtitle", 1, true
+	}
+	return "", 0, false
+}
+
+// Select the previous or next track of the playing file, wrapping around.
+// Cycling subtitles passes through disabled subtitles.
+func cycleTrack(info *FileInfo, kind string, step int) error {
+	file := player.Status().File
+	audio, subtitle := tracks.Current(file, info)
+
+	if kind == "audio" {
+		if len(info.Audio) == 0 {
+			return errors.New("No audio tracks")
+		}
+		index := (audio + step + len(info.Audio)) % len(info.Audio)
+		if err := player.SelectAudio(index); err != nil {
+			return err
+		}
+		tracks.SelectAudio(file, index)
+		return nil
+	}
+
+	if len(info.Subtitles) == 0 {
+		return errors.New("No subtitle tracks")
+	}
+
+	// Positions are shifted by one to include -1 for disabled subtitles
+	count := len(info.Subtitles) + 1
+	index := (subtitle+1+step+count)%count - 1
+	if err := player.SelectSubtitle(index); err != nil {
+		return err
+	}
+	tracks.SelectSubtitle(file, index)
+	return nil
+}
+
+func httpServe(c *gin.Context) {
+	file := c.Request.URL.Query().Get("file")
+	if file == "" {
+		return
+	}
+
+	file = fmt.Sprintf("%s/%s", MediaPath, file)
+	if !fileExists(file) {
+		c.String(404, "Not found")
+		return
+	}
+
+	if !omxCanPlay(file) {
+		c.String(400, "Invalid format")
+		return
+	}
+
+	http.ServeFile(c.Writer, c.Request, file)
+}
+
+func httpPlay(c *gin.Context) {
+	if player.Status().Running {
+		c.JSON(400, Response{false, ErrPlayerActive.Error()})
+		return
+	}
+
+	if c.Request.FormValue("mode") == "folder" {
+		httpPlayFolder(c)
+		return
+	}
+
+	file := c.Request.FormValue("file")
+	if file == "" {
+		c.JSON(400, Response{false, "File is required"})
+		return
+	}
+
+	path, err := mediaFile(file)
+	if err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	opts := defaultPlayOptions()
+
+	if audio := c.Request.FormValue("audio"); audio != "" {
+		if err := validateAudioDevice(audio); err != nil {
+			c.JSON(400, Response{false, err.Error()})
+			return
+		}
+		opts.AudioDevice = audio
+	}
+
+	if opts.Video, err = VideoLayout.Merge(c.Request.FormValue); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	if opts.Tracks, err = opts.Tracks.Merge(c.Request.FormValue); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	if subtitles := c.Request.FormValue("subtitles"); subtitles != "" {
+		subtitlesPath, err := subtitlesFile(subtitles)
+		if err != nil {
+			c.JSON(400, Response{false, err.Error()})
+			return
+		}
+		if opts.Subtitles, err = prepareSubtitles(subtitlesPath); err != nil {
+			c.JSON(400, Response{false, err.Error()})
+			return
+		}
+	}
+
+	// Continue from the saved position unless media was watched to the end.
+	// Multi-part movies continue in the part that was played last.
+	if c.Request.FormValue("resume") == "true" && c.Request.FormValue("position") == "" {
+		if parts := findStack(path); parts != nil {
+			if part, position, ok := stackResume(parts); ok {
+				path = part
+				opts.Position = time.Duration(position) * time.Second
+			}
+		} else if item, ok := progress.Get(path); ok && !item.Watched {
+			opts.Position = time.Duration(item.Position) * time.Second
+		}
+	}
+
+	if value := c.Request.FormValue("position"); value != "" {
+		seconds, err := parseTimestamp(value)
+		if err != nil {
+			c.JSON(400, Response{false, err.Error()})
+			return
+		}
+		opts.Position = time.Duration(seconds) * time.Second
+	}
+
+	if err := startPlayback(path, opts); err != nil {
+		playErrorResponse(c, err)
+		return
+	}
+
+	if err := queue.SetCurrent(file); err != nil {
+		log.Println("Cant save queue:", err)
+	}
+
+	c.JSON(200, Response{true, "OK"})
+}
+
+// Replace the queue with media files of the folder and start playing it
+// GET /play?path=...&mode=folder
+func httpPlayFolder(c *gin.Context) {
+	dir := strings.Trim(c.Request.FormValue("path"), "/")
+	fullPath := fmt.Sprintf("%s/%s", MediaPath, dir)
+
+	if info, err := os.Stat(fullPath); err != nil || !info.IsDir() {
+		c.JSON(400, Response{false, "Directory does not exist"})
+		return
+	}
+
+	repeat, shuffle := queue.Mode()
+	if value := c.Request.FormValue("repeat"); value != "" {
+		repeat = value
+	}
+	if value := c.Request.FormValue("shuffle"); value != "" {
+		shuffle = value == "true"
+	}
+
+	if err := validateRepeat(repeat); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	files, err := scanMediaFiles(fullPath, c.Request.FormValue("recursive") == "true")
+	if err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+	if len(files) == 0 {
+		c.JSON(400, Response{false, "Directory has no playable files"})
+		return
+	}
+
+	for i, file := range files {
+		files[i] = filepath.Join(dir, file)
+	}
+
+	if err := queue.SetMode(repeat, shuffle); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+	if err := queue.Replace(files); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	if err := playQueueStep(1); err != nil {
+		playErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(200, queueResponse())
+}
+
+// Get full path to the requested media file. Stream URLs are returned as is.
+func mediaFile(file string) (string, error) {
+	if strings.HasPrefix(file, "http") {
+		return file, nil
+	}
+
+	file = fmt.Sprintf("%s/%s", MediaPath, file)
+
+	if !fileExists(file) {
+		return "", errors.New("File does not exist")
+	}
+
+	if !omxCanPlay(file) {
+		return "", errors.New("File cannot be played")
+	}
+
+	return file, nil
+}
+
+// Playback options configured on the server
+func defaultPlayOptions() PlayOptions {
+	level, _ := volume.Get()
+
+	return PlayOptions{
+		Volume:      level,
+		AudioDevice: AudioOutput,
+		Video:       VideoLayout,
+
+		SubtitleStyle: SubStyle,
+	}
+}
+
+// Start playback and restore muted state. Blocks until the player has started or failed.
+func startPlayback(file string, opts PlayOptions) error {
+	nextUp.Cancel()
+	opts.Tracks = applyLanguages(file, opts.Tracks)
+	if item, ok := progress.Get(file); ok && opts.SubtitleDelay == 0 {
+		opts.SubtitleDelay = time.Duration(item.SubtitleDelay) * time.Millisecond
+	}
+	stack.Prepare(file, opts)
+	tracks.Start(file, opts.Tracks)
+
+	if err := player.Play(file, opts); err != nil {
+		return err
+	}
+
+	// Not all players can start with subtitles disabled
+	if subtitle := opts.Tracks.Subtitle; subtitle != nil && *subtitle < 0 {
+		if err := player.SelectSubtitle(-1); err != nil {
+			log.Println("Cant disable subtitles:", err)
+		}
+	}
+
+	if _, muted := volume.Get(); muted {
+		if err := player.SetMuted(true); err != nil {
+			log.Println("Cant mute player:", err)
+		}
+	}
+
+	return nil
 }
 
-var (
-	// Regular expression to match all supported video files
-	RegexFormats = regexp.MustCompile(`.(avi|mpg|mov|flv|wmv|asf|mpeg|m4v|divx|mp4|ogm|mkv|mp4)$`)
+// Pick tracks of the file according to language preferences
+func applyLanguages(file string, opts TrackOptions) TrackOptions {
+	profile := languageProfileFor(file)
+
+	var info *FileInfo
+	if canProbe() && (len(profile.Audio) > 0 || len(profile.Subtitles) > 0 || len(profile.SubtitlesUnlessAudio) > 0) {
+		if probed, err := probes.Get(file); err == nil {
+			info = probed
+		}
+	}
+
+	return profile.Apply(opts, info)
+}
+
+// Respond with playback start error, including player output if available
+func playErrorResponse(c *gin.Context, err error) {
+	if playErr, ok := err.(*PlayError); ok {
+		c.JSON(400, PlayErrorResponse{
+			Response: Response{false, playErr.Error()},
+			ExitCode: playErr.ExitCode,
+			Stderr:   playErr.Stderr,
+		})
+		return
+	}
+
+	c.JSON(400, Response{false, err.Error()})
+}
+
+func queueResponse() QueueResponse {
+	items, current := queue.List()
+	repeat, shuffle := queue.Mode()
+
+	resp := QueueResponse{
+		Items:   make([]QueueItem, len(items)),
+		Current: current,
+		Repeat:  repeat,
+		Shuffle: shuffle,
+	}
+	for i, item := range items {
+		resp.Items[i] = QueueItem{File: item, Name: fileToTitle(filepath.Base(item))}
+	}
+
+	return resp
+}
+
+// Play queue item at the given offset from the current one, replacing current playback
+func playQueueStep(offset int) error {
+	file, err := queue.Step(offset)
+	if err != nil {
+		return err
+	}
+	return playQueueItem(file)
+}
+
+func playQueueItem(file string) error {
+	path, err := mediaFile(file)
+	if err != nil {
+		return err
+	}
+
+	if err := player.Stop(); err != nil && err != ErrPlayerInactive {
+		return err
+	}
+
+	return startPlayback(path, defaultPlayOptions())
+}
+
+// Play next queued item if the finished file is the current one.
+// Returns false if the file was not played from the queue.
+func advanceQueue(finished string) bool {
+	current := queue.CurrentItem()
+	if current == "" {
+		return false
+	}
+
+	if path, err := mediaFile(current); err != nil || path != finished {
+		return false
+	}
+
+	if player.Status().Running {
+		return true
+	}
+
+	file, err := queue.Advance()
+	if err != nil {
+		return true
+	}
+
+	if err := playQueueItem(file); err != nil {
+		log.Println("Cant play next queue item:", err)
+	}
+	return true
+}
+
+// Offer the episode following the finished one
+func offerNextEpisode(finished string) {
+	if !strings.HasPrefix(finished, MediaPath+"/") {
+		return
+	}
+
+	next, episode, ok := findNextEpisode(finished)
+	if !ok {
+		return
+	}
+
+	var delay time.Duration
+	if autoplayNext {
+		delay = autoplayWait
+	}
+
+	file, _ := filepath.Rel(MediaPath, next)
+	nextUp.Offer(file, episode, delay, func() {
+		if err := playNextUp(); err != nil {
+			log.Println("Cant play next episode:", err)
+		}
+	})
+}
+
+// Play the offered episode
+func playNextUp() error {
+	file := nextUp.Take()
+	if file == "" {
+		return errors.New("Next episode is not available")
+	}
+
+	if player.Status().Running {
+		return ErrPlayerActive
+	}
+
+	path, err := mediaFile(file)
+	if err != nil {
+		return err
+	}
+
+	if err := startPlayback(path, defaultPlayOptions()); err != nil {
+		return err
+	}
+
+	if err := queue.SetCurrent(file); err != nil {
+		log.Println("Cant save queue:", err)
+	}
+	return nil
+}
+
+// Play the next episode right away
+// POST /next-up/play
+func httpNextUpPlay(c *gin.Context) {
+	if err := playNextUp(); err != nil {
+		playErrorResponse(c, err)
+		return
+	}
+	c.JSON(200, Response{true, "OK"})
+}
+
+// Dismiss the next episode
+// DELETE /next-up
+func httpNextUpCancel(c *gin.Context) {
+	nextUp.Cancel()
+	c.JSON(200, Response{true, "OK"})
+}
+
+// Get queued media files
+// GET /queue
+func httpQueue(c *gin.Context) {
+	c.JSON(200, queueResponse())
+}
+
+// Add media file to the queue
+// POST /queue
+func httpQueueAdd(c *gin.Context) {
+	req := QueueRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, Response{false, "Invalid request: " + err.Error()})
+		return
+	}
+
+	if req.File == "" {
+		c.JSON(400, Response{false, "File is required"})
+		return
+	}
+
+	if _, err := mediaFile(req.File); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	index := -1
+	if req.Index != nil {
+		index = *req.Index
+	}
+
+	if err := queue.Add(req.File, index); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	c.JSON(200, queueResponse())
+}
+
+// Move queued item to another position
+// POST /queue/move
+func httpQueueMove(c *gin.Context) {
+	req := QueueMoveRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, Response{false, "Invalid request: " + err.Error()})
+		return
+	}
+
+	if err := queue.Move(req.From, req.To); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	c.JSON(200, queueResponse())
+}
+
+// Play queued item by index
+// POST /queue/play/:index
+func httpQueuePlay(c *gin.Context) {
+	index, err := strconv.Atoi(c.Params.ByName("index"))
+	if err != nil {
+		c.JSON(400, Response{false, ErrQueueIndex.Error()})
+		return
+	}
+
+	file, err := queue.Select(index)
+	if err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	if err := playQueueItem(file); err != nil {
+		playErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(200, queueResponse())
+}
+
+// Change repeat mode or shuffle queued items
+// PUT /queue/mode
+func httpQueueMode(c *gin.Context) {
+	req := QueueModeRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, Response{false, "Invalid request: " + err.Error()})
+		return
+	}
+
+	repeat, shuffle := queue.Mode()
+	if req.Repeat != nil {
+		repeat = *req.Repeat
+	}
+	if req.Shuffle != nil {
+		shuffle = *req.Shuffle
+	}
+
+	if err := queue.SetMode(repeat, shuffle); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	c.JSON(200, queueResponse())
+}
+
+// Remove queued item by index
+// DELETE /queue/:index
+func httpQueueRemove(c *gin.Context) {
+	index, err := strconv.Atoi(c.Params.ByName("index"))
+	if err != nil {
+		c.JSON(400, Response{false, ErrQueueIndex.Error()})
+		return
+	}
+
+	if err := queue.Remove(index); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	c.JSON(200, queueResponse())
+}
+
+// Remove all queued items
+// DELETE /queue
+func httpQueueClear(c *gin.Context) {
+	if err := queue.Clear(); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	c.JSON(200, queueResponse())
+}
+
+// Seek to absolute or relative position
+// POST /seek
+func httpSeek(c *gin.Context) {
+	req := SeekRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, Response{false, "Invalid request: " + err.Error()})
+		return
+	}
+
+	status := player.Status()
+	if status.State != StatePlaying && status.State != StatePaused {
+		c.JSON(400, Response{false, ErrPlayerInactive.Error()})
+		return
+	}
+
+	// Position of multi-part movies is relative to the whole movie
+	target, err := req.Target(stack.Adjust(status))
+	if err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	if err := seekTo(status.File, target); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	c.JSON(200, SeekResponse{
+		Response: Response{true, "OK"},
+		Position: durationFromSeconds(target),
+		Seconds:  target,
+	})
+}
+
+// Seek to the position, switching to another part of a multi-part movie if needed
+func seekTo(file string, target uint64) error {
+	part, _, position, ok := stack.Locate(target)
+	if !ok {
+		return player.SetPosition(time.Duration(target) * time.Second)
+	}
+
+	if part == file {
+		return player.SetPosition(time.Duration(position) * time.Second)
+	}
+
+	_, opts := stack.Current()
+	opts.Volume, _ = volume.Get()
+	opts.Position = time.Duration(position) * time.Second
 
-	// Regular expression to convert filenames to titles
-	RegexBrackets = regexp.MustCompile(`[\(\[\]\)]`)
-	RegexYear     = regexp.MustCompile(`((19|20)[\d]{2})`)
-	RegexEpisode  = regexp.MustCompile(`(?i)S[\d]+E[\d]+`)
-	RegexJunk     = regexp.MustCompile(`(?i)(1080p|720p|3d|brrip|bluray|webrip|x264|aac)`)
-	RegexSpace    = regexp.MustCompile(`\s{2,}`)
-
-	// OMXPlayer control commands, these are piped via STDIN to omxplayer process
-	Commands = map[string]string{
-		"pause":             "p",            // Pause/continue playback
-		"stop":              "q",            // Stop playback and exit
-		"volume_up":         "+",            // Change volume by +3dB
-		"volume_down":       "-",            // Change volume by -3dB
-		"subtitles":         "s",            // Enable/disable subtitles
-		"seek_back":         "\x1b\x5b\x44", // Seek -30 seconds
-		"seek_back_fast":    "\x1b\x5b\x42", // Seek -600 second
-		"seek_forward":      "\x1b\x5b\x43", // Seek +30 second
-		"seek_forward_fast": "\x1b\x5b\x41", // Seek +600 seconds
-	}
-
-	MediaPath   string         // Path where all media files are stored
-	OmxPath     string         // Path to omxplayer executable
-	Omx         *exec.Cmd      // Child process for spawning omxplayer
-	OmxIn       io.WriteCloser // Child process STDIN pipe to send commands
-	Command     chan string    // Channel to pass along commands to the player routine
-	CurrentFile string         // Currently playing media file name
-	Zeroconf    bool           // Enable Zeroconf discovery
-	Frontend    bool           // Serve frontend app
-	stream      *Stream        // Current stream
-)
+	if err := player.Stop(); err != nil && err != ErrPlayerInactive {
+		return err
+	}
+	return startPlayback(part, opts)
+}
 
-func httpBrowse(c *gin.Context) {
-	path := c.Request.FormValue("path")
+// Get media information of the playing file
+func playingFileInfo() (string, *FileInfo, error) {
+	status := player.Status()
+	if status.State != StatePlaying && status.State != StatePaused {
+		return "", nil, ErrPlayerInactive
+	}
 
-	if path != "" {
-		path = fmt.Sprintf("%s/%s", MediaPath, path)
-	} else {
-		path = MediaPath
+	if !canProbe() {
+		return "", nil, errors.New("Media info requires omxplayer or ffprobe")
 	}
 
-	c.JSON(200, scanPath(path))
+	info, err := probes.Get(status.File)
+	return status.File, info, err
 }
 
-func httpCommand(c *gin.Context) {
-	val := c.Params.ByName("command")
+// List audio and subtitle tracks of the playing file
+// GET /tracks
+func httpTracks(c *gin.Context) {
+	file, info, err := playingFileInfo()
+	if err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
 
-	if _, ok := Commands[val]; !ok {
-		c.JSON(400, Response{false, "Invalid command"})
+	audio, subtitle := tracks.Current(file, info)
+
+	c.JSON(200, TracksResponse{
+		Audio:         info.Audio,
+		Subtitles:     info.Subtitles,
+		AudioIndex:    audio,
+		SubtitleIndex: subtitle,
+	})
+}
+
+// Select audio or subtitle track by index
+// POST /tracks/audio, POST /tracks/subtitle
+func httpSelectTrack(c *gin.Context) {
+	req := TrackRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, Response{false, "Invalid request: " + err.Error()})
+		return
+	}
+	if req.Index == nil {
+		c.JSON(400, Response{false, "Index is required"})
 		return
 	}
 
-	fmt.Println("Received command:", val)
+	kind := c.Param("kind")
+	index := *req.Index
+	status := player.Status()
 
-	// Handle requested commmand
-	Command <- val
+	// Validate the index against probed streams when possible
+	count := -1
+	if _, info, err := playingFileInfo(); err == nil {
+		count = len(info.Subtitles)
+		if kind == "audio" {
+			count = len(info.Audio)
+		}
+	}
+
+	var err error
+	switch kind {
+	case "audio":
+		if index < 0 || count >= 0 && index >= count {
+			c.JSON(400, Response{false, fmt.Sprintf("Invalid audio track: %d", index)})
+			return
+		}
+		if err = player.SelectAudio(index); err == nil {
+			tracks.SelectAudio(status.File, index)
+		}
+	case "subtitle":
+		if index < -1 || count >= 0 && index >= count {
+			c.JSON(400, Response{false, fmt.Sprintf("Invalid subtitle track: %d", index)})
+			return
+		}
+		if err = player.SelectSubtitle(index); err == nil {
+			tracks.SelectSubtitle(status.File, index)
+		}
+	default:
+		c.JSON(404, Response{false, "Invalid track type: " + kind})
+		return
+	}
+
+	if err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
 
 	c.JSON(200, Response{true, "OK"})
 }
 
-func httpServe(c *gin.Context) {
-	file := c.Request.URL.Query().Get("file")
-	if file == "" {
+// List chapters of the playing file
+// GET /chapters
+func httpChapters(c *gin.Context) {
+	_, info, err := playingFileInfo()
+	if err != nil {
+		c.JSON(400, Response{false, err.Error()})
 		return
 	}
 
-	file = fmt.Sprintf("%s/%s", MediaPath, file)
-	if !fileExists(file) {
-		c.String(404, "Not found")
+	c.JSON(200, ChaptersResponse{
+		Chapters: info.Chapters,
+		Current:  currentChapter(info.Chapters, player.Status().Position),
+	})
+}
+
+// Jump to the start of a chapter of the playing file
+// POST /chapters/:index
+func httpPlayChapter(c *gin.Context) {
+	file, info, err := playingFileInfo()
+	if err != nil {
+		c.JSON(400, Response{false, err.Error()})
 		return
 	}
 
-	if !omxCanPlay(file) {
-		c.String(400, "Invalid format")
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 || index >= len(info.Chapters) {
+		c.JSON(400, Response{false, "Invalid chapter: " + c.Param("index")})
 		return
 	}
 
-	http.ServeFile(c.Writer, c.Request, file)
+	start := info.Chapters[index].Start
+	if err := player.SetPosition(time.Duration(start * float64(time.Second))); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	// Position of multi-part movies is relative to the whole movie
+	target := stack.Adjust(PlayerStatus{File: file, Position: uint64(start)}).Position
+
+	c.JSON(200, SeekResponse{
+		Response: Response{true, "OK"},
+		Position: durationFromSeconds(target),
+		Seconds:  target,
+	})
 }
 
-func httpPlay(c *gin.Context) {
-	if omxIsActive() {
-		c.JSON(400, Response{false, "Player is already running"})
+// Index of the chapter containing the position in seconds, -1 if there is none.
+// Chapter starts are truncated to whole seconds the same way player position is.
+func currentChapter(chapters []Chapter, position uint64) int {
+	current := -1
+	for i, chapter := range chapters {
+		if uint64(chapter.Start) <= position {
+			current = i
+		}
+	}
+	return current
+}
+
+func volumeResponse() VolumeResponse {
+	level, muted := volume.Get()
+	return VolumeResponse{
+		DB:      level,
+		Percent: int(math.Round(volumePercent(level))),
+		Muted:   muted,
+	}
+}
+
+// Apply volume to the running player and save it for the next launch
+func applyVolume(level float64, muted bool) error {
+	current, wasMuted := volume.Get()
+
+	if level < volumeMin || level > volumeMax {
+		return ErrVolumeRange
+	}
+
+	if player.Status().Running {
+		if level != current {
+			if err := player.SetVolume(level); err != nil {
+				return err
+			}
+		}
+		if muted != wasMuted {
+			if err := player.SetMuted(muted); err != nil {
+				return err
+			}
+		}
+	}
+
+	return volume.Set(level, muted)
+}
+
+// Get current volume
+// GET /volume
+func httpVolume(c *gin.Context) {
+	c.JSON(200, volumeResponse())
+}
+
+// Change volume or mute audio
+// PUT /volume
+func httpSetVolume(c *gin.Context) {
+	req := VolumeRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, Response{false, "Invalid request: " + err.Error()})
 		return
 	}
 
-	file := c.Request.FormValue("file")
-	if file == "" {
-		c.JSON(400, Response{false, "File is required"})
+	if req.DB != nil && req.Percent != nil {
+		c.JSON(400, Response{false, "Only one of db or percent is allowed"})
 		return
 	}
 
-	if !strings.HasPrefix(file, "http") {
-		file = fmt.Sprintf("%s/%s", MediaPath, file)
+	level, muted := volume.Get()
 
-		if !fileExists(file) {
-			c.JSON(400, Response{false, "File does not exist"})
+	switch {
+	case req.DB != nil:
+		level = *req.DB
+	case req.Percent != nil:
+		if *req.Percent < 0 || *req.Percent > 100 {
+			c.JSON(400, Response{false, "Percent must be between 0 and 100"})
 			return
 		}
+		level = volumeFromPercent(*req.Percent)
+	}
 
-		if !omxCanPlay(file) {
-			c.JSON(400, Response{false, "File cannot be played"})
-			return
-		}
+	if req.Muted != nil {
+		muted = *req.Muted
+	}
+
+	if err := applyVolume(level, muted); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
 	}
 
-	go omxPlay(file)
+	c.JSON(200, volumeResponse())
+}
 
-	c.JSON(200, Response{true, "OK"})
+// List audio output devices
+// GET /audio/devices
+func httpAudioDevices(c *gin.Context) {
+	devices := []AudioDevice{}
+
+	// Built-in outputs are only supported by omxplayer
+	if _, ok := player.(*OmxPlayer); ok {
+		devices = append(devices, omxAudioDevices...)
+	}
+
+	alsa, err := alsaAudioDevices()
+	if err != nil {
+		log.Println("Cant list ALSA devices:", err)
+	}
+
+	c.JSON(200, append(devices, alsa...))
 }
 
 func httpInfo(c *gin.Context) {
@@ -164,7 +1251,12 @@ func httpInfo(c *gin.Context) {
 		return
 	}
 
-	info, err := omxInfo(file)
+	if !canProbe() {
+		c.JSON(400, Response{false, "Media info requires omxplayer or ffprobe"})
+		return
+	}
+
+	info, err := probes.Get(file)
 	if err != nil {
 		c.JSON(400, Response{false, err.Error()})
 		return
@@ -173,6 +1265,101 @@ func httpInfo(c *gin.Context) {
 	c.JSON(200, info)
 }
 
+// List external subtitles of the media file
+// GET /subtitles?file=...
+func httpSubtitles(c *gin.Context) {
+	file := c.Request.FormValue("file")
+	if file == "" {
+		c.JSON(400, Response{false, "File is required"})
+		return
+	}
+
+	file = fmt.Sprintf("%s/%s", MediaPath, file)
+	if !fileExists(file) {
+		c.JSON(400, Response{false, "File does not exist"})
+		return
+	}
+
+	c.JSON(200, findSubtitles(file))
+}
+
+// Change subtitle timing of the playing file. Delay is remembered for the file.
+// POST /subtitles/delay
+func httpSubtitleDelay(c *gin.Context) {
+	req := SubtitleDelayRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, Response{false, "Invalid request: " + err.Error()})
+		return
+	}
+
+	status := player.Status()
+	if status.State != StatePlaying && status.State != StatePaused {
+		c.JSON(400, Response{false, ErrPlayerInactive.Error()})
+		return
+	}
+
+	item, _ := progress.Get(status.File)
+	delay := item.SubtitleDelay
+
+	switch {
+	case req.Delay != nil:
+		delay = *req.Delay
+	case req.Offset != nil:
+		delay += *req.Offset
+	default:
+		c.JSON(400, Response{false, "Delay or offset is required"})
+		return
+	}
+
+	if err := applySubtitleDelay(status.File, delay); err != nil {
+		c.JSON(400, Response{false, err.Error()})
+		return
+	}
+
+	c.JSON(200, SubtitleDelayResponse{Response{true, "OK"}, delay})
+}
+
+// Offset subtitle timing of the playing file by delay in milliseconds and
+// remember it for the next playback
+func applySubtitleDelay(file string, delay int64) error {
+	if time.Duration(math.Abs(float64(delay)))*time.Millisecond > subtitleDelayMax {
+		return fmt.Errorf("Invalid delay: %d", delay)
+	}
+
+	if err := player.SetSubtitleDelay(time.Duration(delay) * time.Millisecond); err != nil {
+		return err
+	}
+
+	if err := progress.SetSubtitleDelay(file, delay); err == nil {
+		if err := progress.Save(); err != nil {
+			log.Println("Cant save playback progress:", err)
+		}
+	}
+	return nil
+}
+
+// Search subtitles of the library for a phrase
+// GET /search/dialogue?q=...&limit=50
+func httpSearchDialogue(c *gin.Context) {
+	query := strings.TrimSpace(c.Request.FormValue("q"))
+	if query == "" {
+		c.JSON(400, Response{false, "Query is required"})
+		return
+	}
+
+	limit := 50
+	if value := c.Request.FormValue("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(400, Response{false, "Invalid limit: " + value})
+			return
+		}
+		limit = n
+	}
+
+	c.JSON(200, dialogue.Search(query, limit))
+}
+
 func httpRemoveFile(c *gin.Context) {
 	file := strings.TrimSpace(c.Request.FormValue("file"))
 	if file == "" {
@@ -195,20 +1382,70 @@ func httpRemoveFile(c *gin.Context) {
 }
 
 func httpStatus(c *gin.Context) {
+	status := stack.Adjust(player.Status())
+
 	resp := StatusResponse{
-		Running: omxIsActive(),
-		File:    CurrentFile,
-		Name:    fileToTitle(filepath.Base(CurrentFile)),
+		Running: status.Running,
+		State:   string(status.State),
+		Paused:  status.State == StatePaused,
+		File:    status.File,
+		Name:    fileToTitle(filepath.Base(status.File)),
+		Error:   status.Error,
+		Volume:  volumeResponse(),
+	}
+
+	if status.File != "" {
+		resp.Media = mediaName(status.File)
+	}
+
+	if part, parts := stack.Part(status.File); parts > 0 {
+		resp.Part = part
+		resp.Parts = parts
+		resp.Name = fileToTitle(stackName(filepath.Base(status.File)))
+	}
+
+	if file, episode, left := nextUp.Get(); file != "" {
+		resp.NextUp = &NextUpResponse{
+			File:      file,
+			Name:      fileToTitle(filepath.Base(file)),
+			Season:    episode.Season,
+			Episode:   episode.Episode,
+			Countdown: int(math.Ceil(left.Seconds())),
+		}
 	}
 
-	if stream != nil {
-		resp.Duration = durationFromSeconds(stream.duration)
-		resp.Position = stream.pos.String()
+	if status.Running {
+		resp.Duration = durationFromSeconds(status.Duration)
+		resp.Position = durationFromSeconds(status.Position)
+		resp.Chapter = currentChapterTitle()
 	}
 
 	c.JSON(200, resp)
 }
 
+// Title of the chapter being played. Only already probed files are checked,
+// so status is not delayed by probing.
+func currentChapterTitle() string {
+	status := player.Status()
+	if !probes.Cached(status.File) {
+		return ""
+	}
+
+	info, err := probes.Get(status.File)
+	if err != nil {
+		return ""
+	}
+
+	index := currentChapter(info.Chapters, status.Position)
+	if index < 0 {
+		return ""
+	}
+	if title := info.Chapters[index].Title; title != "" {
+		return title
+	}
+	return fmt.Sprintf("Chapter %d", index+1)
+}
+
 func httpIndex(c *gin.Context) {
 	data, err := Asset("static/index.html")
 
@@ -284,6 +1521,43 @@ func httpReboot(c *gin.Context) {
 	c.JSON(200, Response{Success: true})
 }
 
+// React to playback lifecycle events
+func handlePlayerEvents() {
+	for event := range player.Events() {
+		handlePlayerEvent(event)
+	}
+}
+
+func handlePlayerEvent(event PlayerEvent) {
+	switch event.Type {
+	case EventStopped:
+		// Position since the last periodic update is not lost
+		saveProgress(progress, event.File, event.Position, event.Duration)
+
+	case EventFinished:
+		if err := progress.Finish(event.File); err == nil {
+			if err := progress.Save(); err != nil {
+				log.Println("Cant save playback progress:", err)
+			}
+		}
+
+		// Parts of a multi-part movie are played back-to-back
+		if next, opts, ok := stack.Next(event.File); ok {
+			opts.Volume, _ = volume.Get()
+			if err := startPlayback(next, opts); err != nil {
+				log.Println("Cant play next part:", err)
+			}
+			return
+		}
+
+		// Stopped playback does not advance the queue
+		finished := stack.First(event.File)
+		if !advanceQueue(finished) {
+			offerNextEpisode(finished)
+		}
+	}
+}
+
 func terminate(message string, code int) {
 	fmt.Println(message)
 	os.Exit(code)
@@ -294,21 +1568,87 @@ func usage() {
 }
 
 func init() {
-	var printVersion bool
-
 	flag.StringVar(&MediaPath, "media", "./", "Path to media files")
+	flag.StringVar(&DataPath, "data", "~/.omxremote", "Path to store server state")
 	flag.BoolVar(&Frontend, "frontend", true, "Enable frontend applicaiton")
 	flag.BoolVar(&Zeroconf, "zeroconf", true, "Enable service advertisement with Zeroconf")
+	flag.StringVar(&PlayerName, "player", "auto", "Media player: auto, omxplayer, mpv, vlc")
+	flag.StringVar(&AudioOutput, "audio", "hdmi", "Default audio device: hdmi, local, both, alsa:<device>")
+	flag.IntVar(&VideoLayout.Display, "display", 0, "Default display number")
+	flag.StringVar(&VideoLayout.AspectMode, "aspect-mode", "", "Default aspect mode: letterbox, fill, stretch")
+	flag.StringVar(&VideoLayout.Window, "win", "", "Default video window: x1,y1,x2,y2")
+	flag.IntVar(&VideoLayout.Orientation, "orientation", 0, "Default video orientation: 0, 90, 180, 270")
+	flag.IntVar(&VideoLayout.Layer, "layer", 0, "Default video layer")
+	flag.BoolVar(&VideoLayout.NoRefresh, "no-refresh", false, "Do not adjust display refresh rate to video")
+	flag.IntVar(&SubStyle.FontSize, "font-size", 0, "Subtitle font size in 1/1000 of screen height (omxplayer)")
+	flag.StringVar(&SubStyle.Align, "align", "", "Subtitle alignment: left, center (omxplayer)")
+	flag.IntVar(&SubStyle.Lines, "lines", 0, "Number of subtitle lines (omxplayer)")
+	flag.BoolVar(&autoplayNext, "autoplay-next", true, "Play next episode automatically")
+	flag.DurationVar(&autoplayWait, "autoplay-delay", 10*time.Second, "Delay before the next episode starts")
+	flag.Float64Var(&watchedThreshold, "watched", watchedThreshold, "Fraction of duration after which media is marked as watched")
+	flag.IntVar(&probeWorkers, "probe-workers", probeWorkers, "Maximum number of concurrent media probes")
+	flag.BoolVar(&preprobe, "preprobe", preprobe, "Probe media files in the background when they are browsed")
 	flag.BoolVar(&printVersion, "v", false, "Print version")
+}
+
+// Setup HTTP routes
+func newRouter() *gin.Engine {
+	router := gin.Default()
+
+	// Handle CORS
+	router.Use(func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Access-Control-Expose-Headers", "*")
+	})
+
+	// Server frontend application only if its enabled
+	if Frontend == true {
+		router.GET("/", httpIndex)
+	}
+
+	router.GET("/status", httpStatus)
+	router.GET("/browse", httpBrowse)
+	router.GET("/info", httpInfo)
+	router.GET("/play", httpPlay)
+	router.GET("/serve", httpServe)
+	router.POST("/remove", httpRemoveFile)
+	router.GET("/command/:command", httpCommand)
+	router.GET("/commands", httpCommands)
+	router.POST("/seek", httpSeek)
+	router.GET("/volume", httpVolume)
+	router.PUT("/volume", httpSetVolume)
+	router.GET("/audio/devices", httpAudioDevices)
+	router.GET("/tracks", httpTracks)
+	router.GET("/chapters", httpChapters)
+	router.POST("/chapters/:index", httpPlayChapter)
+	router.GET("/subtitles", httpSubtitles)
+	router.POST("/subtitles/delay", httpSubtitleDelay)
+	router.GET("/search/dialogue", httpSearchDialogue)
+	router.POST("/tracks/:kind", httpSelectTrack)
+	router.GET("/queue", httpQueue)
+	router.POST("/queue", httpQueueAdd)
+	router.DELETE("/queue", httpQueueClear)
+	router.POST("/queue/move", httpQueueMove)
+	router.PUT("/queue/mode", httpQueueMode)
+	router.POST("/queue/play/:index", httpQueuePlay)
+	router.DELETE("/queue/:index", httpQueueRemove)
+	router.POST("/next-up/play", httpNextUpPlay)
+	router.DELETE("/next-up", httpNextUpCancel)
+	router.GET("/host", httpHost)
+	router.POST("/reboot", httpReboot)
+
+	return router
+}
+
+func main() {
 	flag.Parse()
 
 	if printVersion {
 		fmt.Printf("omxremote v%v\n", VERSION)
 		os.Exit(0)
 	}
-}
 
-func main() {
 	// Expand media path if needed
 	MediaPath = strings.Replace(MediaPath, "~", os.Getenv("HOME"), 1)
 
@@ -319,16 +1659,53 @@ func main() {
 		terminate(fmt.Sprintf("Directory does not exist: %s", MediaPath), 1)
 	}
 
+	if err := validateAudioDevice(AudioOutput); err != nil {
+		terminate(err.Error(), 1)
+	}
+
+	if err := VideoLayout.Validate(); err != nil {
+		terminate(err.Error(), 1)
+	}
+
+	if err := SubStyle.Validate(); err != nil {
+		terminate(err.Error(), 1)
+	}
+
+	if watchedThreshold <= 0 || watchedThreshold > 1 {
+		terminate("Watched threshold must be between 0 and 1", 1)
+	}
+
+	DataPath = strings.Replace(DataPath, "~", os.Getenv("HOME"), 1)
+	if err := os.MkdirAll(DataPath, 0755); err != nil {
+		terminate(err.Error(), 1)
+	}
+
+	volume = loadVolume(filepath.Join(DataPath, "volume.json"))
+	progress = loadProgressStore(filepath.Join(DataPath, "progress.json"))
+	queue = loadQueue(filepath.Join(DataPath, "queue.json"))
+
+	profile, err := loadLanguageProfile(filepath.Join(DataPath, "languages.yml"))
+	if err != nil {
+		terminate(err.Error(), 1)
+	}
+	languages = profile
+
 	// Check if player is installed
-	if omxDetect() != nil {
-		terminate("omxplayer is not installed", 1)
+	p, err := newPlayer(PlayerName)
+	if err != nil {
+		terminate(err.Error(), 1)
 	}
+	player = p
 
-	// Make sure nothing is running
-	omxCleanup()
+	// Media info is provided by omxplayer regardless of the playback backend,
+	// ffprobe is used when omxplayer is not installed
+	OmxPath, _ = detectExecutable("omxplayer")
+	FFprobePath, _ = detectExecutable("ffprobe")
+	probes = loadProbeCache(filepath.Join(DataPath, "probe.json"), probeWorkers, mediaInfo)
 
-	// Start a remote command listener
-	go omxListen()
+	go trackProgress(progress, player)
+	go handlePlayerEvents()
+	go dialogue.Watch(dialogueRefreshInterval)
 
 	// Start zeroconf service advertisement
 	if Zeroconf {
@@ -340,29 +1717,7 @@ func main() {
 	gin.SetMode("release")
 
 	// Setup HTTP server
-	router := gin.Default()
-
-	// Handle CORS
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Expose-Headers", "*")
-	})
-
-	// Server frontend application only if its enabled
-	if Frontend == true {
-		router.GET("/", httpIndex)
-	}
-
-	router.GET("/status", httpStatus)
-	router.GET("/browse", httpBrowse)
-	router.GET("/info", httpInfo)
-	router.GET("/play", httpPlay)
-	router.GET("/serve", httpServe)
-	router.POST("/remove", httpRemoveFile)
-	router.GET("/command/:command", httpCommand)
-	router.GET("/host", httpHost)
-	router.POST("/reboot", httpReboot)
+	router := newRouter()
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -1,8 +1,19 @@
 package main
 
 import (
-	"github.com/stretchr/testify/assert"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_fileToTitle(t *testing.T) {
@@ -21,3 +32,1068 @@ func Test_fileToTitle(t *testing.T) {
 		assert.Equal(t, fileToTitle(val), "Movie Name")
 	}
 }
+
+func Test_parseMediaName(t *testing.T) {
+	examples := []struct {
+		name     string
+		expected MediaName
+	}{
+		{"Movie Name (2014) [1080p].mp4", MediaName{Title: "Movie Name", Year: 2014, Resolution: "1080p"}},
+		{"Movie Name (2009) [1080p] [HSBS] [3d].mp4", MediaName{Title: "Movie Name", Year: 2009, Resolution: "1080p", Is3D: true}},
+		{"Movie.Name.2011.480p.BRRip.XviD.AC3-AsA.mp4", MediaName{Title: "Movie Name", Year: 2011, Resolution: "480p", Source: "BluRay", Codec: "XviD", Group: "AsA"}},
+		{"Movie Name 2007 BRRip 720p x264 AAC - PRiSTiNE [P2PDL].mp4", MediaName{Title: "Movie Name", Year: 2007, Resolution: "720p", Source: "BluRay", Codec: "H.264", Group: "PRiSTiNE"}},
+		{"Movie Name.2011.limited.720p.BRRip.H264.AAC-MAJESTiC.mp4", MediaName{Title: "Movie Name", Year: 2011, Resolution: "720p", Source: "BluRay", Codec: "H.264", Group: "MAJESTiC"}},
+		{"Movie.Name.2010.1080p.BrRip.x264.YIFY.mp4", MediaName{Title: "Movie Name", Year: 2010, Resolution: "1080p", Source: "BluRay", Codec: "H.264"}},
+		{"Movie Name[2011]BRRip XviD-ExtraTorrentRG.avi", MediaName{Title: "Movie Name", Year: 2011, Source: "BluRay", Codec: "XviD", Group: "ExtraTorrentRG"}},
+		{"Movie.Name.S05E01.HDTV.x264-LOL.mp4", MediaName{Title: "Movie Name", Season: 5, Episode: 1, Source: "HDTV", Codec: "H.264", Group: "LOL"}},
+		{"Show Name - s01e12 - Episode Title.mkv", MediaName{Title: "Show Name", Season: 1, Episode: 12, EpisodeTitle: "Episode Title"}},
+		{"Show.Name.S02E03.The.Pilot.720p.WEB-DL.DD5.1.H.264-GRP.mkv", MediaName{Title: "Show Name", Season: 2, Episode: 3, EpisodeTitle: "The Pilot", Resolution: "720p", Source: "WEB-DL", Codec: "H.264", Group: "GRP"}},
+		{"Show Name 1x02 Title.avi", MediaName{Title: "Show Name", Season: 1, Episode: 2, EpisodeTitle: "Title"}},
+		{"Show.Name.2019.S01E01.2160p.WEBRip.x265.mkv", MediaName{Title: "Show Name", Year: 2019, Season: 1, Episode: 1, Resolution: "2160p", Source: "WEBRip", Codec: "H.265"}},
+		{"Show Name - Episode 7.mkv", MediaName{Title: "Show Name", Episode: 7}},
+		{"Movie.Name.1999.Directors.Cut.1080p.BluRay.x264.mkv", MediaName{Title: "Movie Name", Year: 1999, Resolution: "1080p", Source: "BluRay", Codec: "H.264", Edition: "Director's Cut"}},
+		{"Movie Name (2000) Extended Edition 4K UHD Remux HEVC.mkv", MediaName{Title: "Movie Name", Year: 2000, Resolution: "2160p", Source: "Remux", Codec: "H.265", Edition: "Extended"}},
+		{"2001.A.Space.Odyssey.1968.1080p.mkv", MediaName{Title: "2001 A Space Odyssey", Year: 1968, Resolution: "1080p"}},
+		{"1917.mkv", MediaName{Title: "1917"}},
+		{"Spider-Man.mkv", MediaName{Title: "Spider-Man"}},
+		{"Movie_Name_Unrated_DVDRip.avi", MediaName{Title: "Movie Name", Source: "DVDRip", Edition: "Unrated"}},
+		{"Episode 2.mkv", MediaName{Title: "Episode 2", Episode: 2}},
+	}
+
+	for _, example := range examples {
+		assert.Equal(t, example.expected, parseMediaName(example.name), example.name)
+	}
+
+	name := mediaName("/media/Show/Season 3/Episode 2.mkv")
+	assert.Equal(t, 3, name.Season)
+	assert.Equal(t, 2, name.Episode)
+}
+
+func Test_naturalLess(t *testing.T) {
+	names := []string{
+		"Episode 10.mkv",
+		"episode 2.mkv",
+		"Episode 1.mkv",
+		"Episode 02b.mkv",
+		"Season 2/Episode 1.mkv",
+		"Season 10/Episode 1.mkv",
+		"Episode.mkv",
+	}
+	sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+
+	assert.Equal(t, []string{
+		"Episode 1.mkv",
+		"episode 2.mkv",
+		"Episode 02b.mkv",
+		"Episode 10.mkv",
+		"Episode.mkv",
+		"Season 2/Episode 1.mkv",
+		"Season 10/Episode 1.mkv",
+	}, names)
+}
+
+// Setup media directory and fake player for HTTP API tests
+func setupAPI(t *testing.T) (*gin.Engine, *fakePlayer, func()) {
+	gin.SetMode(gin.TestMode)
+
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "movie.mp4"), []byte("data"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("data"), 0644)
+
+	// Shared state is restored after the test, so background playback of one
+	// test cannot leak into the next one
+	saved := struct {
+		media, data string
+		player      Player
+		volume      *Volume
+		progress    *ProgressStore
+		queue       *Queue
+		nextUp      *NextUp
+		stack       *StackPlayback
+		probes      *ProbeCache
+		tracks      *TrackSelection
+		languages   *LanguageProfile
+		dialogue    *DialogueIndex
+	}{MediaPath, DataPath, player, volume, progress, queue, nextUp, stack, probes, tracks, languages, dialogue}
+
+	fake := newFakePlayer()
+	MediaPath = dir
+	DataPath = filepath.Join(dir, ".omxremote")
+	player = fake
+	volume = loadVolume(filepath.Join(DataPath, "volume.json"))
+	progress = loadProgressStore(filepath.Join(DataPath, "progress.json"))
+	queue = loadQueue(filepath.Join(DataPath, "queue.json"))
+	stack = &StackPlayback{}
+	probes = loadProbeCache(filepath.Join(DataPath, "probe.json"), 2, mediaInfo)
+	languages = &LanguageProfile{}
+	dialogue = &DialogueIndex{}
+	nextUp = &NextUp{}
+	tracks = &TrackSelection{}
+
+	return newRouter(), fake, func() {
+		nextUp.Cancel()
+		probes.save()
+
+		MediaPath, DataPath, player = saved.media, saved.data, saved.player
+		volume, progress, queue = saved.volume, saved.progress, saved.queue
+		nextUp, stack, probes, tracks = saved.nextUp, saved.stack, saved.probes, saved.tracks
+		languages, dialogue = saved.languages, saved.dialogue
+
+		os.RemoveAll(dir)
+	}
+}
+
+func apiRequest(router *gin.Engine, method, path string) (int, map[string]interface{}) {
+	return apiRequestBody(router, method, path, "")
+}
+
+func apiRequestBody(router *gin.Engine, method, path, body string) (int, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	result := map[string]interface{}{}
+	json.Unmarshal(rec.Body.Bytes(), &result)
+
+	return rec.Code, result
+}
+
+func Test_httpPlay(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	code, resp := apiRequest(router, "GET", "/play")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "File is required", resp["message"])
+
+	code, resp = apiRequest(router, "GET", "/play?file=missing.mp4")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "File does not exist", resp["message"])
+
+	code, resp = apiRequest(router, "GET", "/play?file=notes.txt")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "File cannot be played", resp["message"])
+
+	code, resp = apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, true, resp["success"])
+	assert.Equal(t, filepath.Join(MediaPath, "movie.mp4"), fake.file)
+
+	code, resp = apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Player is already running", resp["message"])
+}
+
+func Test_httpPlayError(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	fake.playErr = &PlayError{
+		Err:      errors.New("exit status 1"),
+		ExitCode: 1,
+		Stderr:   []string{"have a nice day ;)"},
+	}
+
+	code, resp := apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, false, resp["success"])
+	assert.Equal(t, "exit status 1", resp["message"])
+	assert.Equal(t, float64(1), resp["exit_code"])
+	assert.Equal(t, []interface{}{"have a nice day ;)"}, resp["stderr"])
+}
+
+func Test_httpCommand(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	code, resp := apiRequest(router, "GET", "/command/pause")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Player is not running", resp["message"])
+
+	fake.Play("movie.mp4", PlayOptions{})
+
+	code, _ = apiRequest(router, "GET", "/command/pause")
+	assert.Equal(t, 200, code)
+
+	code, resp = apiRequest(router, "GET", "/command/foo")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid command", resp["message"])
+
+	assert.Equal(t, []string{"pause"}, fake.commands)
+}
+
+func Test_httpCommands(t *testing.T) {
+	router, _, cleanup := setupAPI(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("GET", "/commands", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	commands := []CommandInfo{}
+	assert.Equal(t, 200, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &commands))
+	assert.Len(t, commands, len(CommandList))
+	for i, command := range commands {
+		assert.Equal(t, CommandList[i].Name, command.Name)
+		assert.Equal(t, CommandList[i].Description, command.Description)
+		assert.Empty(t, command.Key)
+	}
+
+	// Only commands with a key are sent to the player
+	assert.Equal(t, "q", Commands["stop"])
+	_, ok := Commands["next"]
+	assert.False(t, ok)
+
+	// Every published command is accepted and every accepted command is published
+	names := map[string]bool{"next": true, "previous": true}
+	for name := range Commands {
+		names[name] = true
+	}
+	assert.Equal(t, len(names), len(commands))
+	for _, command := range commands {
+		assert.True(t, names[command.Name], command.Name)
+		assert.NotEmpty(t, command.Description)
+	}
+}
+
+func Test_handlePlayerEventStopped(t *testing.T) {
+	_, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	path := filepath.Join(MediaPath, "movie.mp4")
+	fake.Play(path, PlayOptions{Position: 95 * time.Second})
+	assert.Equal(t, EventStarted, (<-fake.events).Type)
+	assert.NoError(t, fake.Stop())
+
+	// Position is recorded right away instead of waiting for the next update
+	event := <-fake.events
+	assert.Equal(t, EventStopped, event.Type)
+	handlePlayerEvent(event)
+
+	item, ok := loadProgressStore(progress.path).Get(path)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(95), item.Position)
+	assert.Equal(t, uint64(3600), item.Duration)
+}
+
+func Test_httpCommandTrackCycle(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	// Without stream info the command goes to the player
+	fake.Play(filepath.Join(MediaPath, "movie.mp4"), PlayOptions{})
+	code, _ := apiRequest(router, "GET", "/command/audio_next")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{"audio_next"}, fake.commands)
+	fake.Stop()
+
+	defer func(path string) { OmxPath = path }(OmxPath)
+	OmxPath = os.Args[0]
+
+	// Fixture has 2 audio and 3 subtitle tracks, first of each is default
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 200, code)
+
+	code, _ = apiRequest(router, "GET", "/command/audio_next")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 1, fake.audio)
+
+	code, _ = apiRequest(router, "GET", "/command/audio_next")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 0, fake.audio)
+
+	code, _ = apiRequest(router, "GET", "/command/subtitle_previous")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, -1, fake.subtitle)
+
+	code, _ = apiRequest(router, "GET", "/command/subtitle_previous")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 2, fake.subtitle)
+
+	_, resp := apiRequest(router, "GET", "/tracks")
+	assert.Equal(t, 0.0, resp["audio_index"])
+	assert.Equal(t, 2.0, resp["subtitle_index"])
+	assert.Equal(t, []string{"audio_next"}, fake.commands)
+}
+
+func Test_httpStatus(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	code, resp := apiRequest(router, "GET", "/status")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, false, resp["running"])
+	assert.Nil(t, resp["position"])
+
+	fake.Play("/media/Movie.Name.2010.1080p.mp4", PlayOptions{Position: 30 * time.Second})
+
+	code, resp = apiRequest(router, "GET", "/status")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, true, resp["running"])
+	assert.Equal(t, "Movie Name", resp["name"])
+	assert.Equal(t, "00:00:30", resp["position"])
+	assert.Equal(t, "01:00:00", resp["duration"])
+}
+
+func Test_httpSeek(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	code, resp := apiRequestBody(router, "POST", "/seek", `{"seconds":10}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Player is not running", resp["message"])
+
+	fake.Play("movie.mp4", PlayOptions{Position: 600 * time.Second})
+
+	code, resp = apiRequestBody(router, "POST", "/seek", `{"position":"00:30:00"}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "00:30:00", resp["position"])
+	assert.Equal(t, float64(1800), resp["seconds"])
+	assert.Equal(t, 30*time.Minute, fake.position)
+
+	code, resp = apiRequestBody(router, "POST", "/seek", `{"offset":-10}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "00:29:50", resp["position"])
+
+	code, resp = apiRequestBody(router, "POST", "/seek", `{"percent":50}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "00:30:00", resp["position"])
+
+	code, resp = apiRequestBody(router, "POST", "/seek", `{"seconds":4000}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Position is beyond duration 01:00:00", resp["message"])
+
+	code, resp = apiRequestBody(router, "POST", "/seek", `{"seconds":"foo"}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, false, resp["success"])
+}
+
+func Test_httpVolume(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	code, resp := apiRequest(router, "GET", "/volume")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, map[string]interface{}{"db": 0.0, "percent": 100.0, "muted": false}, resp)
+
+	// Volume is saved when player is not running
+	code, resp = apiRequestBody(router, "PUT", "/volume", `{"percent":50}`)
+	assert.Equal(t, 200, code)
+	assert.InDelta(t, -6.02, resp["db"], 0.01)
+	assert.Equal(t, 50.0, resp["percent"])
+
+	code, resp = apiRequestBody(router, "PUT", "/volume", `{"db":-9}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 35.0, resp["percent"])
+
+	code, resp = apiRequestBody(router, "PUT", "/volume", `{"db":3}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Volume must be between -60 and 0 dB", resp["message"])
+
+	code, _ = apiRequestBody(router, "PUT", "/volume", `{"db":-3,"percent":10}`)
+	assert.Equal(t, 400, code)
+
+	code, _ = apiRequestBody(router, "PUT", "/volume", `{"muted":true}`)
+	assert.Equal(t, 200, code)
+
+	// Last volume is passed to the next launch and persisted
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, -9.0, fake.opts.Volume)
+	assert.True(t, fake.muted)
+	assert.Equal(t, -9.0, loadVolume(volume.path).Level)
+
+	code, _ = apiRequestBody(router, "PUT", "/volume", `{"db":-12,"muted":false}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, -12.0, fake.volume)
+	assert.False(t, fake.muted)
+
+	code, _ = apiRequest(router, "GET", "/command/volume_up")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, -9.0, fake.volume)
+
+	code, resp = apiRequest(router, "GET", "/status")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, map[string]interface{}{"db": -9.0, "percent": 35.0, "muted": false}, resp["volume"])
+}
+
+func Test_httpAudioDevices(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	cards := filepath.Join(MediaPath, "cards")
+	ioutil.WriteFile(cards, []byte(exampleAsoundCards), 0644)
+
+	defer func(path string) { asoundCardsPath = path }(asoundCardsPath)
+	asoundCardsPath = cards
+
+	req := httptest.NewRequest("GET", "/audio/devices", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	devices := []AudioDevice{}
+	assert.Equal(t, 200, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &devices))
+	assert.Equal(t, 2, len(devices))
+	assert.Equal(t, "alsa:hw:CARD=Device", devices[1].Name)
+
+	code, resp := apiRequest(router, "GET", "/play?file=movie.mp4&audio=spdif")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid audio device: spdif", resp["message"])
+
+	AudioOutput = "local"
+	defer func() { AudioOutput = "hdmi" }()
+
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "local", fake.opts.AudioDevice)
+	fake.Stop()
+
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4&audio=alsa:hw:CARD=Device")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "alsa:hw:CARD=Device", fake.opts.AudioDevice)
+}
+
+func Test_httpPlayVideoOptions(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	VideoLayout = VideoOptions{AspectMode: "fill"}
+	defer func() { VideoLayout = VideoOptions{} }()
+
+	code, resp := apiRequest(router, "GET", "/play?file=movie.mp4&orientation=45")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid orientation: 45", resp["message"])
+
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4&display=2&win=0+0+640+480&refresh=false")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, VideoOptions{
+		Display:    2,
+		AspectMode: "fill",
+		Window:     "0,0,640,480",
+		NoRefresh:  true,
+	}, fake.opts.Video)
+}
+
+func Test_httpPlayResume(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	file := filepath.Join(MediaPath, "movie.mp4")
+	assert.NoError(t, progress.Update(file, 1200, 3600))
+
+	code, _ := apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, time.Duration(0), fake.opts.Position)
+	fake.Stop()
+
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4&resume=true")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 1200*time.Second, fake.opts.Position)
+	fake.Stop()
+
+	// Watched media starts from the beginning
+	assert.NoError(t, progress.Finish(file))
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4&resume=true")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, time.Duration(0), fake.opts.Position)
+}
+
+func Test_httpBrowseProgress(t *testing.T) {
+	router, _, cleanup := setupAPI(t)
+	defer cleanup()
+
+	assert.NoError(t, progress.Update(filepath.Join(MediaPath, "movie.mp4"), 3300, 3600))
+
+	req := httptest.NewRequest("GET", "/browse", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	entries := []FileEntry{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	assert.Equal(t, []FileEntry{
+		{Filename: "movie.mp4", Position: 3300, Duration: 3600, Watched: true, Media: &MediaName{Title: "movie"}},
+	}, entries)
+}
+
+func writeEpisodes() {
+	ioutil.WriteFile(filepath.Join(MediaPath, "episode1.mkv"), []byte("data"), 0644)
+	ioutil.WriteFile(filepath.Join(MediaPath, "episode2.mkv"), []byte("data"), 0644)
+}
+
+func Test_httpQueue(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+	writeEpisodes()
+
+	code, resp := apiRequest(router, "GET", "/queue")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, map[string]interface{}{
+		"items":   []interface{}{},
+		"current": -1.0,
+		"repeat":  "off",
+		"shuffle": false,
+	}, resp)
+
+	code, resp = apiRequestBody(router, "POST", "/queue", `{"file":"missing.mkv"}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "File does not exist", resp["message"])
+
+	apiRequestBody(router, "POST", "/queue", `{"file":"episode2.mkv"}`)
+	apiRequestBody(router, "POST", "/queue", `{"file":"movie.mp4"}`)
+	code, _ = apiRequestBody(router, "POST", "/queue", `{"file":"episode1.mkv","index":0}`)
+	assert.Equal(t, 200, code)
+
+	code, _ = apiRequestBody(router, "POST", "/queue/move", `{"from":2,"to":0}`)
+	assert.Equal(t, 200, code)
+	items, _ := queue.List()
+	assert.Equal(t, []string{"movie.mp4", "episode1.mkv", "episode2.mkv"}, items)
+
+	code, _ = apiRequestBody(router, "POST", "/queue/move", `{"from":2,"to":5}`)
+	assert.Equal(t, 400, code)
+
+	// Next starts the queue from the beginning
+	code, _ = apiRequest(router, "GET", "/command/next")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, filepath.Join(MediaPath, "movie.mp4"), fake.file)
+
+	code, _ = apiRequest(router, "GET", "/command/next")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, filepath.Join(MediaPath, "episode1.mkv"), fake.file)
+
+	code, _ = apiRequest(router, "GET", "/command/previous")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, filepath.Join(MediaPath, "movie.mp4"), fake.file)
+
+	code, resp = apiRequest(router, "GET", "/command/previous")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "No more items in the queue", resp["message"])
+
+	code, resp = apiRequest(router, "POST", "/queue/play/2")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 2.0, resp["current"])
+	assert.Equal(t, filepath.Join(MediaPath, "episode2.mkv"), fake.file)
+
+	code, resp = apiRequest(router, "DELETE", "/queue/0")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 1.0, resp["current"])
+
+	// Queue is persisted
+	items, current := loadQueue(queue.path).List()
+	assert.Equal(t, []string{"episode1.mkv", "episode2.mkv"}, items)
+	assert.Equal(t, 1, current)
+
+	// Playing a file outside of the queue resets current item
+	fake.Stop()
+	apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, "", queue.CurrentItem())
+
+	code, resp = apiRequest(router, "DELETE", "/queue")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []interface{}{}, resp["items"])
+}
+
+func Test_advanceQueue(t *testing.T) {
+	_, fake, cleanup := setupAPI(t)
+	defer cleanup()
+	writeEpisodes()
+
+	queue.Add("episode1.mkv", -1)
+	queue.Add("episode2.mkv", -1)
+	queue.Select(0)
+
+	// Finished file is not the current queue item
+	advanceQueue(filepath.Join(MediaPath, "movie.mp4"))
+	assert.Equal(t, "", fake.file)
+
+	advanceQueue(filepath.Join(MediaPath, "episode1.mkv"))
+	assert.Equal(t, filepath.Join(MediaPath, "episode2.mkv"), fake.file)
+	assert.Equal(t, "episode2.mkv", queue.CurrentItem())
+
+	// Last item does not advance
+	fake.Stop()
+	advanceQueue(filepath.Join(MediaPath, "episode2.mkv"))
+	assert.False(t, fake.running)
+}
+
+func Test_httpPlayFolder(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	dir := filepath.Join(MediaPath, "Shows", "Season 1")
+	os.MkdirAll(filepath.Join(dir, "Extras"), 0755)
+	for _, name := range []string{"Episode 10.mkv", "Episode 2.mkv", "Episode 1.mkv", "notes.txt", "Extras/Bloopers.mkv"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+	}
+
+	code, resp := apiRequest(router, "GET", "/play?path=Missing&mode=folder")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Directory does not exist", resp["message"])
+
+	code, resp = apiRequest(router, "GET", "/play?path=Shows/Season+1&mode=folder&repeat=sometimes")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid repeat mode: sometimes", resp["message"])
+
+	code, resp = apiRequest(router, "GET", "/play?path=Shows/Season+1&mode=folder&repeat=all")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "all", resp["repeat"])
+	assert.Equal(t, filepath.Join(dir, "Episode 1.mkv"), fake.file)
+
+	items, _ := queue.List()
+	assert.Equal(t, []string{
+		"Shows/Season 1/Episode 1.mkv",
+		"Shows/Season 1/Episode 2.mkv",
+		"Shows/Season 1/Episode 10.mkv",
+	}, items)
+
+	// Repeat all starts over after the last item
+	fake.Stop()
+	queue.Select(2)
+	advanceQueue(filepath.Join(dir, "Episode 10.mkv"))
+	assert.Equal(t, filepath.Join(dir, "Episode 1.mkv"), fake.file)
+	assert.Equal(t, 0, queue.Current)
+
+	// Repeat one plays the same item again
+	code, _ = apiRequestBody(router, "PUT", "/queue/mode", `{"repeat":"one"}`)
+	assert.Equal(t, 200, code)
+	fake.Stop()
+	advanceQueue(filepath.Join(dir, "Episode 1.mkv"))
+	assert.Equal(t, filepath.Join(dir, "Episode 1.mkv"), fake.file)
+	assert.Equal(t, 0, queue.Current)
+
+	fake.Stop()
+	code, _ = apiRequest(router, "GET", "/play?path=Shows&mode=folder&recursive=true&shuffle=true&repeat=off")
+	assert.Equal(t, 200, code)
+
+	items, _ = queue.List()
+	assert.Equal(t, 4, len(items))
+	assert.Contains(t, items, "Shows/Season 1/Extras/Bloopers.mkv")
+	repeat, shuffle := queue.Mode()
+	assert.Equal(t, RepeatOff, repeat)
+	assert.True(t, shuffle)
+}
+
+func Test_offerNextEpisode(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	dir := filepath.Join(MediaPath, "Show", "Season 1")
+	os.MkdirAll(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "Show.S01E01.mkv"), []byte("data"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "Show.S01E02.mkv"), []byte("data"), 0644)
+
+	defer func(next bool, wait time.Duration) { autoplayNext, autoplayWait = next, wait }(autoplayNext, autoplayWait)
+	autoplayNext = false
+
+	offerNextEpisode(filepath.Join(dir, "Show.S01E01.mkv"))
+
+	code, resp := apiRequest(router, "GET", "/status")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, map[string]interface{}{
+		"file":      "Show/Season 1/Show.S01E02.mkv",
+		"name":      "Show",
+		"season":    1.0,
+		"episode":   2.0,
+		"countdown": 0.0,
+	}, resp["next_up"])
+
+	code, _ = apiRequest(router, "DELETE", "/next-up")
+	assert.Equal(t, 200, code)
+	_, resp = apiRequest(router, "GET", "/status")
+	assert.Nil(t, resp["next_up"])
+
+	code, resp = apiRequest(router, "POST", "/next-up/play")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Next episode is not available", resp["message"])
+
+	offerNextEpisode(filepath.Join(dir, "Show.S01E01.mkv"))
+	code, _ = apiRequest(router, "POST", "/next-up/play")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, filepath.Join(dir, "Show.S01E02.mkv"), fake.file)
+	fake.Stop()
+
+	// Next episode starts after the countdown
+	assert.NoError(t, queue.Replace([]string{"Show/Season 1/Show.S01E02.mkv"}))
+	autoplayNext = true
+	autoplayWait = 10 * time.Millisecond
+	assert.Equal(t, EventStarted, (<-fake.events).Type)
+	assert.Equal(t, EventStopped, (<-fake.events).Type)
+	offerNextEpisode(filepath.Join(dir, "Show.S01E01.mkv"))
+
+	select {
+	case event := <-fake.events:
+		assert.Equal(t, EventStarted, event.Type)
+		assert.Equal(t, filepath.Join(dir, "Show.S01E02.mkv"), event.File)
+	case <-time.After(time.Second):
+		t.Fatal("next episode was not played")
+	}
+
+	// Playback keeps updating shared state after the player has started
+	deadline := time.Now().Add(time.Second)
+	for _, current := queue.List(); current != 0; _, current = queue.List() {
+		if time.Now().After(deadline) {
+			t.Fatal("queue was not updated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_httpPlayStack(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	for _, name := range []string{"Movie.cd2.avi", "Movie.cd1.avi"} {
+		ioutil.WriteFile(filepath.Join(MediaPath, name), []byte("data"), 0644)
+	}
+	part1 := filepath.Join(MediaPath, "Movie.cd1.avi")
+	part2 := filepath.Join(MediaPath, "Movie.cd2.avi")
+
+	// Duration of the second part is known from the previous playback
+	assert.NoError(t, progress.Update(part2, 0, 1800))
+
+	req := httptest.NewRequest("GET", "/browse", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	entries := []FileEntry{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	assert.Equal(t, []FileEntry{
+		{Filename: "Movie.cd1.avi", Parts: []string{"Movie.cd1.avi", "Movie.cd2.avi"}, Media: &MediaName{Title: "Movie"}},
+		{Filename: "movie.mp4", Media: &MediaName{Title: "movie"}},
+	}, entries)
+
+	code, _ := apiRequest(router, "GET", "/play?file=Movie.cd1.avi")
+	assert.Equal(t, 200, code)
+	assert.NoError(t, fake.SetPosition(10*time.Minute))
+
+	_, resp := apiRequest(router, "GET", "/status")
+	assert.Equal(t, "Movie", resp["name"])
+	assert.Equal(t, map[string]interface{}{"title": "Movie"}, resp["media"])
+	assert.Equal(t, 1.0, resp["part"])
+	assert.Equal(t, 2.0, resp["parts"])
+	assert.Equal(t, "00:10:00", resp["position"])
+	assert.Equal(t, "01:30:00", resp["duration"])
+
+	// Seeking past the first part switches to the second one
+	code, _ = apiRequestBody(router, "POST", "/seek", `{"position":"01:10:00"}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, part2, fake.file)
+	assert.Equal(t, 10*time.Minute, fake.opts.Position)
+
+	_, resp = apiRequest(router, "GET", "/status")
+	assert.Equal(t, 2.0, resp["part"])
+	assert.Equal(t, "01:10:00", resp["position"])
+
+	// Finished part continues with the next one
+	next, _, ok := stack.Next(part2)
+	assert.False(t, ok)
+	stack.Prepare(part1, defaultPlayOptions())
+	next, _, ok = stack.Next(part1)
+	assert.True(t, ok)
+	assert.Equal(t, part2, next)
+	assert.Equal(t, part1, stack.First(part2))
+
+	// Resume continues in the part played last
+	assert.NoError(t, fake.Stop())
+	assert.NoError(t, progress.Update(part1, 3600, 3600))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, progress.Update(part2, 1200, 1800))
+
+	code, _ = apiRequest(router, "GET", "/play?file=Movie.cd1.avi&resume=true")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, part2, fake.file)
+	assert.Equal(t, 20*time.Minute, fake.opts.Position)
+
+	_, resp = apiRequest(router, "GET", "/status")
+	assert.Equal(t, "01:20:00", resp["position"])
+}
+
+func Test_httpTracks(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	defer func(path string) { OmxPath = path }(OmxPath)
+	OmxPath = os.Args[0]
+
+	code, resp := apiRequest(router, "GET", "/tracks")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Player is not running", resp["message"])
+
+	code, resp = apiRequest(router, "GET", "/play?file=movie.mp4&alang=english")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid audio language: english", resp["message"])
+
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4&alang=rus&subtitle_track=2")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "rus", fake.opts.Tracks.AudioLang)
+	assert.Equal(t, 2, *fake.opts.Tracks.Subtitle)
+
+	// Tracks are listed from the probe fixture
+	code, resp = apiRequest(router, "GET", "/tracks")
+	assert.Equal(t, 200, code)
+	assert.Len(t, resp["audio"], 2)
+	assert.Len(t, resp["subtitles"], 3)
+	assert.Equal(t, 1.0, resp["audio_index"])
+	assert.Equal(t, 2.0, resp["subtitle_index"])
+
+	code, _ = apiRequestBody(router, "POST", "/tracks/audio", `{"index": 0}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 0, fake.audio)
+
+	code, _ = apiRequestBody(router, "POST", "/tracks/subtitle", `{"index": -1}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, -1, fake.subtitle)
+
+	_, resp = apiRequest(router, "GET", "/tracks")
+	assert.Equal(t, 0.0, resp["audio_index"])
+	assert.Equal(t, -1.0, resp["subtitle_index"])
+
+	code, resp = apiRequestBody(router, "POST", "/tracks/audio", `{"index": 2}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid audio track: 2", resp["message"])
+
+	code, resp = apiRequestBody(router, "POST", "/tracks/subtitle", `{}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Index is required", resp["message"])
+
+	code, _ = apiRequestBody(router, "POST", "/tracks/video", `{"index": 0}`)
+	assert.Equal(t, 404, code)
+}
+
+func Test_httpTracksFFprobe(t *testing.T) {
+	router, _, cleanup := setupAPI(t)
+	defer cleanup()
+
+	code, _ := apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 200, code)
+
+	code, resp := apiRequest(router, "GET", "/tracks")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Media info requires omxplayer or ffprobe", resp["message"])
+
+	// Tracks and chapters are probed with ffprobe without omxplayer
+	defer func(path string) { FFprobePath = path }(FFprobePath)
+	FFprobePath = os.Args[0]
+
+	code, resp = apiRequest(router, "GET", "/tracks")
+	assert.Equal(t, 200, code)
+	assert.Len(t, resp["audio"], 2)
+	assert.Len(t, resp["subtitles"], 3)
+
+	code, resp = apiRequest(router, "GET", "/chapters")
+	assert.Equal(t, 200, code)
+	assert.Len(t, resp["chapters"], 3)
+}
+
+func Test_httpPlayLanguages(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	defer func(path string) { OmxPath = path }(OmxPath)
+	OmxPath = os.Args[0]
+
+	// Streams of the probe fixture: audio eng, rus; subtitles eng, eng, fre
+	languages = &LanguageProfile{Audio: []string{"rus"}, Subtitles: []string{"fre"}}
+
+	code, _ := apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 1, *fake.opts.Tracks.Audio)
+	assert.Equal(t, 2, *fake.opts.Tracks.Subtitle)
+	fake.Stop()
+
+	// Subtitles are disabled after start when the audio needs none
+	languages = &LanguageProfile{Audio: []string{"eng"}, Subtitles: []string{"fre"}, SubtitlesUnlessAudio: []string{"eng"}}
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 0, *fake.opts.Tracks.Audio)
+	assert.Equal(t, -1, fake.subtitle)
+	fake.Stop()
+
+	// Request parameters take precedence
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4&audio_track=1&subtitle_track=0")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 1, *fake.opts.Tracks.Audio)
+	assert.Equal(t, 0, *fake.opts.Tracks.Subtitle)
+}
+
+func Test_httpSubtitles(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	ioutil.WriteFile(filepath.Join(MediaPath, "movie.en.vtt"), []byte("WEBVTT\n\n00:01.000 --> 00:02.000\nHello\n"), 0644)
+
+	code, resp := apiRequest(router, "GET", "/subtitles")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "File is required", resp["message"])
+
+	req := httptest.NewRequest("GET", "/subtitles?file=movie.mp4", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	subtitles := []SubtitleFile{}
+	assert.Equal(t, 200, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &subtitles))
+	assert.Equal(t, []SubtitleFile{{File: "movie.en.vtt", Name: "movie.en.vtt", Language: "eng", Format: "vtt"}}, subtitles)
+
+	code, resp = apiRequest(router, "GET", "/play?file=movie.mp4&subtitles=missing.srt")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Subtitles file does not exist", resp["message"])
+
+	code, resp = apiRequest(router, "GET", "/play?file=movie.mp4&subtitles=notes.txt")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Unsupported subtitles format", resp["message"])
+
+	// WebVTT is converted to SRT before playback
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4&subtitles=movie.en.vtt")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, filepath.Join(DataPath, "subtitles"), filepath.Dir(fake.opts.Subtitles))
+	assert.Equal(t, ".srt", filepath.Ext(fake.opts.Subtitles))
+}
+
+func Test_httpSubtitleDelay(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	code, resp := apiRequestBody(router, "POST", "/subtitles/delay", `{"delay": 500}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Player is not running", resp["message"])
+
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 200, code)
+
+	code, resp = apiRequestBody(router, "POST", "/subtitles/delay", `{}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Delay or offset is required", resp["message"])
+
+	code, resp = apiRequestBody(router, "POST", "/subtitles/delay", `{"delay": 3600000}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid delay: 3600000", resp["message"])
+
+	code, resp = apiRequestBody(router, "POST", "/subtitles/delay", `{"delay": 500}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 500.0, resp["delay"])
+	assert.Equal(t, 500*time.Millisecond, fake.subDelay)
+
+	code, resp = apiRequestBody(router, "POST", "/subtitles/delay", `{"offset": -750}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, -250.0, resp["delay"])
+	assert.Equal(t, -250*time.Millisecond, fake.subDelay)
+
+	// Delay commands step from the stored delay
+	code, _ = apiRequest(router, "GET", "/command/subtitle_delay_up")
+	assert.Equal(t, 200, code)
+	code, _ = apiRequest(router, "GET", "/command/subtitle_delay_up")
+	assert.Equal(t, 200, code)
+	code, _ = apiRequest(router, "GET", "/command/subtitle_delay_down")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 0*time.Millisecond, fake.subDelay)
+	code, _ = apiRequest(router, "GET", "/command/subtitle_delay_down")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, -250*time.Millisecond, fake.subDelay)
+	assert.Empty(t, fake.commands)
+
+	// Delay is remembered for the next playback of the file
+	fake.Stop()
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, -250*time.Millisecond, fake.opts.SubtitleDelay)
+
+	item, _ := loadProgressStore(filepath.Join(DataPath, "progress.json")).Get(filepath.Join(MediaPath, "movie.mp4"))
+	assert.Equal(t, int64(-250), item.SubtitleDelay)
+
+	fake.Stop()
+	code, resp = apiRequest(router, "GET", "/command/subtitle_delay_up")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Player is not running", resp["message"])
+}
+
+func Test_httpSearchDialogue(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	ioutil.WriteFile(filepath.Join(MediaPath, "movie.en.srt"), []byte("1\n00:01:02,500 --> 00:01:04,000\nI'll be back.\n"), 0644)
+	dialogue.Refresh()
+
+	code, resp := apiRequest(router, "GET", "/search/dialogue")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Query is required", resp["message"])
+
+	code, resp = apiRequest(router, "GET", "/search/dialogue?q=back&limit=0")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid limit: 0", resp["message"])
+
+	req := httptest.NewRequest("GET", "/search/dialogue?q=BE+BACK", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	matches := []DialogueMatch{}
+	assert.Equal(t, 200, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &matches))
+	assert.Equal(t, []DialogueMatch{{
+		File:      "movie.mp4",
+		Subtitles: "movie.en.srt",
+		Position:  "00:01:02",
+		Seconds:   62,
+		Text:      "I'll be back.",
+		Before:    []string{},
+		After:     []string{},
+	}}, matches)
+
+	code, resp = apiRequest(router, "GET", "/play?file=movie.mp4&position=1:xx")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid timestamp: 1:xx", resp["message"])
+
+	// Match is played in one call with its position and subtitles
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4&position=00:01:02&subtitles=movie.en.srt")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 62*time.Second, fake.opts.Position)
+	assert.Equal(t, filepath.Join(MediaPath, "movie.en.srt"), fake.opts.Subtitles)
+}
+
+func Test_httpChapters(t *testing.T) {
+	router, fake, cleanup := setupAPI(t)
+	defer cleanup()
+
+	code, resp := apiRequest(router, "GET", "/chapters")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Player is not running", resp["message"])
+
+	defer func(path string) { OmxPath = path }(OmxPath)
+	OmxPath = os.Args[0]
+
+	code, _ = apiRequest(router, "GET", "/play?file=movie.mp4&position=00:10:00")
+	assert.Equal(t, 200, code)
+
+	// Chapters are listed from the probe fixture
+	code, resp = apiRequest(router, "GET", "/chapters")
+	assert.Equal(t, 200, code)
+	assert.Len(t, resp["chapters"], 3)
+	assert.Equal(t, 1.0, resp["current"])
+
+	_, resp = apiRequest(router, "GET", "/status")
+	assert.Equal(t, "The Heist", resp["chapter"])
+
+	code, resp = apiRequest(router, "POST", "/chapters/3")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid chapter: 3", resp["message"])
+
+	code, resp = apiRequest(router, "POST", "/chapters/first")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Invalid chapter: first", resp["message"])
+
+	code, resp = apiRequest(router, "POST", "/chapters/2")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "00:29:55", resp["position"])
+	assert.Equal(t, 1795003*time.Millisecond, fake.position)
+
+	_, resp = apiRequest(router, "GET", "/status")
+	assert.Equal(t, "Aftermath", resp["chapter"])
+
+	assert.Equal(t, -1, currentChapter(nil, 100))
+	assert.Equal(t, -1, currentChapter([]Chapter{{Start: 10}}, 5))
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Number of trailing error output lines kept for error reporting
+const stderrTailSize = 10
+
+// How long to wait for the player to report playback position before
+// considering the playback started
+var startupTimeout = 10 * time.Second
+
+var (
+	ErrPlayerActive   = errors.New("Player is already running")
+	ErrPlayerInactive = errors.New("Player is not running")
+	ErrInvalidCommand = errors.New("Invalid command")
+	ErrNotSupported   = errors.New("Not supported by the player")
+)
+
+// Player event types
+const (
+	EventStarted  = "started"  // Playback has started
+	EventFinished = "finished" // Playback reached the end of the file
+	EventStopped  = "stopped"  // Playback was stopped by the user
+	EventFailed   = "failed"   // Player exited with an error
+)
+
+// Player is a media player backend controlled by the remote
+type Player interface {
+	// Start playback of the given file
+	Play(file string, opts PlayOptions) error
+
+	// Send a named command (see Commands) to the running player
+	Command(name string) error
+
+	// Get current playback status
+	Status() PlayerStatus
+
+	// Seek to absolute position
+	SetPosition(pos time.Duration) error
+
+	// Set volume in decibels
+	SetVolume(db float64) error
+
+	// Mute or unmute audio
+	SetMuted(muted bool) error
+
+	// Select audio track by index among audio streams
+	SelectAudio(index int) error
+
+	// Select subtitle track by index among subtitle streams, -1 disables subtitles
+	SelectSubtitle(index int) error
+
+	// Offset subtitle timing, positive delay shows subtitles later
+	SetSubtitleDelay(delay time.Duration) error
+
+	// Terminate playback
+	Stop() error
+
+	// Channel of playback lifecycle events
+	Events() <-chan PlayerEvent
+}
+
+// PlayOptions configure a single playback
+type PlayOptions struct {
+	Position time.Duration // Start playback at the given position
+	Volume   float64       // Initial volume in decibels
+
+	// Audio output: hdmi, local, both or alsa:<device>. Only ALSA devices
+	// are supported by players other than omxplayer.
+	AudioDevice string
+
+	// Video output layout
+	Video VideoOptions
+
+	// Audio and subtitle tracks selected at start
+	Tracks TrackOptions
+
+	// External subtitles file in SRT format
+	Subtitles string
+
+	// Subtitle timing offset, positive delay shows subtitles later
+	SubtitleDelay time.Duration
+
+	// Subtitle rendering options
+	SubtitleStyle SubtitleStyle
+}
+
+// TrackOptions select audio and subtitle tracks when playback starts. Track
+// indexes count streams of the same type, languages are ISO 639 codes.
+type TrackOptions struct {
+	AudioLang    string // Preferred audio language, i.e. "jpn"
+	SubtitleLang string // Preferred subtitle language
+	Audio        *int   // Audio track index, takes precedence over language
+	Subtitle     *int   // Subtitle track index, takes precedence over language
+}
+
+type PlayerStatus struct {
+	Running  bool        // True if player is running
+	State    PlayerState // Current playback state
+	File     string      // Path to current media file
+	Position uint64      // Current position in seconds
+	Duration uint64      // Media duration in seconds
+	Error    string      // Last playback error
+}
+
+type PlayerEvent struct {
+	Type     string // Event type, i.e. "started"
+	File     string // Media file the event relates to
+	Error    error  // Exit error for "failed" events
+	Position uint64 // Last position in seconds for "stopped" events
+	Duration uint64 // Media duration in seconds for "stopped" events
+}
+
+// PlayError describes a player process that failed to start or exited abnormally
+type PlayError struct {
+	Err      error    // Underlying process error
+	ExitCode int      // Process exit code, -1 if unknown
+	Stderr   []string // Last lines of the player error output
+}
+
+func newPlayError(err error, stderr []string) *PlayError {
+	playErr := &PlayError{Err: err, ExitCode: -1, Stderr: stderr}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			playErr.ExitCode = status.ExitStatus()
+		}
+	}
+
+	return playErr
+}
+
+func (e *PlayError) Error() string {
+	return e.Err.Error()
+}
+
+// outputTail keeps the last lines of player output
+type outputTail struct {
+	sync.Mutex
+	lines   []string
+	partial string
+}
+
+// Write implements io.Writer so the tail can be used as process output
+func (t *outputTail) Write(data []byte) (int, error) {
+	t.Lock()
+	text := t.partial + string(data)
+	lines := strings.Split(text, "\n")
+	t.partial = lines[len(lines)-1]
+	t.Unlock()
+
+	for _, line := range lines[:len(lines)-1] {
+		t.Add(line)
+	}
+	return len(data), nil
+}
+
+// Add a single line of output
+func (t *outputTail) Add(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.lines = append(t.lines, line)
+	if len(t.lines) > stderrTailSize {
+		t.lines = t.lines[len(t.lines)-stderrTailSize:]
+	}
+}
+
+// Lines returns a copy of the kept lines
+func (t *outputTail) Lines() []string {
+	t.Lock()
+	defer t.Unlock()
+
+	lines := make([]string, len(t.lines))
+	copy(lines, t.lines)
+	return lines
+}
+
+// Find full path to the executable. Returns error if not found.
+func detectExecutable(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s is not installed", name)
+	}
+	return path, nil
+}
+
+// Supported player backends and their executables, in autodetection order
+var playerBackends = [][2]string{
+	{"omxplayer", "omxplayer"},
+	{"mpv", "mpv"},
+	{"vlc", "cvlc"},
+}
+
+// Create player backend by name. With "auto" the first installed player is used.
+func newPlayer(name string) (Player, error) {
+	if name == "auto" {
+		for _, backend := range playerBackends {
+			if _, err := detectExecutable(backend[1]); err == nil {
+				return newPlayer(backend[0])
+			}
+		}
+		return nil, errors.New("No supported media player is installed")
+	}
+
+	for _, backend := range playerBackends {
+		if backend[0] != name {
+			continue
+		}
+
+		path, err := detectExecutable(backend[1])
+		if err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "omxplayer":
+			// Make sure nothing is running
+			omxKill()
+			return NewOmxPlayer(path), nil
+		case "mpv":
+			return NewMpvPlayer(path), nil
+		case "vlc":
+			return NewVlcPlayer(path), nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unsupported player: %s", name)
+}
+
+// Deliver event to the listener without blocking the player if nobody is listening
+func sendEvent(events chan PlayerEvent, event PlayerEvent) {
+	select {
+	case events <- event:
+	default:
+	}
+}
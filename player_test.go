@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// fakePlayer records calls made by the HTTP API without spawning any processes
+type fakePlayer struct {
+	sync.Mutex
+
+	file     string
+	opts     PlayOptions
+	position time.Duration
+	volume   float64
+	muted    bool
+	audio    int
+	subtitle int
+	subDelay time.Duration
+	running  bool
+	commands []string
+	events   chan PlayerEvent
+	playErr  error
+}
+
+func newFakePlayer() *fakePlayer {
+	return &fakePlayer{events: make(chan PlayerEvent, 10)}
+}
+
+func (p *fakePlayer) Play(file string, opts PlayOptions) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.playErr != nil {
+		return p.playErr
+	}
+	if p.running {
+		return ErrPlayerActive
+	}
+
+	p.file = file
+	p.opts = opts
+	p.position = opts.Position
+	p.volume = opts.Volume
+	p.muted = false
+	p.running = true
+	sendEvent(p.events, PlayerEvent{Type: EventStarted, File: file})
+
+	return nil
+}
+
+func (p *fakePlayer) Command(name string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := Commands[name]; !ok {
+		return ErrInvalidCommand
+	}
+	if !p.running {
+		return ErrPlayerInactive
+	}
+
+	p.commands = append(p.commands, name)
+	return nil
+}
+
+func (p *fakePlayer) Status() PlayerStatus {
+	p.Lock()
+	defer p.Unlock()
+
+	state := StateIdle
+	if p.running {
+		state = StatePlaying
+	}
+
+	return PlayerStatus{
+		Running:  p.running,
+		State:    state,
+		File:     p.file,
+		Position: uint64(p.position.Seconds()),
+		Duration: 3600,
+	}
+}
+
+func (p *fakePlayer) SetPosition(pos time.Duration) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.running {
+		return ErrPlayerInactive
+	}
+
+	p.position = pos
+	return nil
+}
+
+func (p *fakePlayer) SetVolume(db float64) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.running {
+		return ErrPlayerInactive
+	}
+
+	p.volume = db
+	return nil
+}
+
+func (p *fakePlayer) SetMuted(muted bool) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.running {
+		return ErrPlayerInactive
+	}
+
+	p.muted = muted
+	return nil
+}
+
+func (p *fakePlayer) SelectAudio(index int) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.running {
+		return ErrPlayerInactive
+	}
+
+	p.audio = index
+	return nil
+}
+
+func (p *fakePlayer) SetSubtitleDelay(delay time.Duration) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.running {
+		return ErrPlayerInactive
+	}
+
+	p.subDelay = delay
+	return nil
+}
+
+func (p *fakePlayer) SelectSubtitle(index int) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.running {
+		return ErrPlayerInactive
+	}
+
+	p.subtitle = index
+	return nil
+}
+
+func (p *fakePlayer) Stop() error {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.running {
+		return ErrPlayerInactive
+	}
+
+	sendEvent(p.events, PlayerEvent{
+		Type:     EventStopped,
+		File:     p.file,
+		Position: uint64(p.position.Seconds()),
+		Duration: 3600,
+	})
+	p.running = false
+	p.file = ""
+
+	return nil
+}
+
+func (p *fakePlayer) Events() <-chan PlayerEvent {
+	return p.events
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Lines of the media information printed by `omxplayer --info`
+	probeInputRegexp    = regexp.MustCompile(`^Input #\d+, (.+), from '.*':$`)
+	probeDurationRegexp = regexp.MustCompile(`^\s*Duration: ([^,]+)(?:, start: ([-\d.]+))?(?:, bitrate: (\d+) kb/s)?`)
+	probeChapterRegexp  = regexp.MustCompile(`^\s*Chapter #\d+[:.](\d+): start ([-\d.]+), end ([-\d.]+)`)
+	probeStreamRegexp   = regexp.MustCompile(`^\s*Stream #\d+[:.](\d+)(?:\[0x[\da-f]+\])?(?:\(([^)]+)\))?: (\w+): (.*)$`)
+	probeMetadataRegexp = regexp.MustCompile(`^\s*([^:\s][^:]*?)\s*: ?(.*)$`)
+	probeFlagRegexp     = regexp.MustCompile(`\s*\(([a-z ]+)\)$`)
+
+	// Number of channels of common audio layouts
+	audioLayoutChannels = map[string]int{
+		"mono":   1,
+		"stereo": 2,
+		"2.1":    3,
+		"quad":   4,
+		"4.0":    4,
+		"4.1":    5,
+		"5.0":    5,
+		"5.1":    6,
+		"6.1":    7,
+		"7.1":    8,
+	}
+)
+
+// Path to ffprobe executable, used for media info when omxplayer is not installed
+var FFprobePath string
+
+// FileInfo describes a media file probed with omxplayer or ffprobe
+type FileInfo struct {
+	Duration string            `json:"duration"`           // Media duration, hh:mm:ss
+	Format   string            `json:"format"`             // Container format, i.e. "matroska,webm"
+	Bitrate  int               `json:"bitrate,omitempty"`  // Overall bitrate in kb/s
+	Metadata map[string]string `json:"metadata,omitempty"` // Container metadata, i.e. title
+
+	Video     []VideoStream    `json:"video"`
+	Audio     []AudioStream    `json:"audio"`
+	Subtitles []SubtitleStream `json:"subtitles"`
+	Chapters  []Chapter        `json:"chapters"`
+}
+
+// StreamInfo contains details shared by all stream types. Index is the
+// position among streams of the same type, which players use to select tracks.
+type StreamInfo struct {
+	Index    int    `json:"index"`              // Index among streams of the same type
+	Stream   int    `json:"stream"`             // Stream number in the container
+	Codec    string `json:"codec"`              // Codec name, i.e. "h264"
+	Language string `json:"language,omitempty"` // ISO 639 language code
+	Title    string `json:"title,omitempty"`    // Stream title
+	Default  bool   `json:"default"`            // Selected by default
+	Forced   bool   `json:"forced"`             // Forced subtitles
+}
+
+type VideoStream struct {
+	StreamInfo
+	Profile string  `json:"profile,omitempty"` // Codec profile, i.e. "High"
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	FPS     float64 `json:"fps,omitempty"`     // Frame rate
+	Bitrate int     `json:"bitrate,omitempty"` // Bitrate in kb/s
+}
+
+type AudioStream struct {
+	StreamInfo
+	SampleRate int    `json:"sample_rate,omitempty"` // Sample rate in Hz
+	Layout     string `json:"layout,omitempty"`      // Channel layout, i.e. "5.1(side)"
+	Channels   int    `json:"channels,omitempty"`    // Number of channels
+	Bitrate    int    `json:"bitrate,omitempty"`     // Bitrate in kb/s
+}
+
+type SubtitleStream struct {
+	StreamInfo
+}
+
+type Chapter struct {
+	Index int     `json:"index"`
+	Start float64 `json:"start"` // Start position in seconds
+	End   float64 `json:"end"`   // End position in seconds
+	Title string  `json:"title,omitempty"`
+}
+
+// Check if media info can be probed with omxplayer or ffprobe
+func canProbe() bool {
+	return OmxPath != "" || FFprobePath != ""
+}
+
+// Probe media file with omxplayer, or ffprobe if omxplayer is not installed
+func mediaInfo(file string) (*FileInfo, error) {
+	switch {
+	case OmxPath != "":
+		return omxInfo(file)
+	case FFprobePath != "":
+		return ffprobeInfo(file)
+	default:
+		return nil, errors.New("Media info requires omxplayer or ffprobe")
+	}
+}
+
+// Probe media file with ffprobe, which prints the same details as `omxplayer --info`
+func ffprobeInfo(file string) (*FileInfo, error) {
+	output, err := exec.Command(FFprobePath, "-hide_banner", file).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe returned error: %v", err)
+	}
+
+	return parseFileInfo(string(output)), nil
+}
+
+// Check if nothing was found by the probe, i.e. when the file can't be opened
+func (info *FileInfo) Empty() bool {
+	return info.Duration == "" && len(info.Video) == 0 && len(info.Audio) == 0 && len(info.Subtitles) == 0
+}
+
+// Parse media information printed by `omxplayer --info` or ffprobe
+func parseFileInfo(output string) *FileInfo {
+	info := &FileInfo{
+		Video:     []VideoStream{},
+		Audio:     []AudioStream{},
+		Subtitles: []SubtitleStream{},
+		Chapters:  []Chapter{},
+	}
+
+	// Metadata sections belong to the container, chapter or stream above them
+	var metadata func(key, value string)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if m := probeInputRegexp.FindStringSubmatch(line); m != nil {
+			info.Format = m[1]
+			metadata = func(key, value string) {
+				if info.Metadata == nil {
+					info.Metadata = map[string]string{}
+				}
+				info.Metadata[key] = value
+			}
+			continue
+		}
+
+		if m := probeDurationRegexp.FindStringSubmatch(line); m != nil {
+			if seconds, ok := parseProbeDuration(m[1]); ok {
+				info.Duration = durationFromSeconds(seconds)
+			}
+			info.Bitrate = atoi(m[3])
+			continue
+		}
+
+		if m := probeChapterRegexp.FindStringSubmatch(line); m != nil {
+			info.Chapters = append(info.Chapters, Chapter{
+				Index: len(info.Chapters),
+				Start: parseFloat(m[2]),
+				End:   parseFloat(m[3]),
+			})
+			chapter := &info.Chapters[len(info.Chapters)-1]
+			metadata = func(key, value string) {
+				if key == "title" {
+					chapter.Title = value
+				}
+			}
+			continue
+		}
+
+		if m := probeStreamRegexp.FindStringSubmatch(line); m != nil {
+			metadata = info.addStream(atoi(m[1]), m[2], m[3], m[4])
+			continue
+		}
+
+		// Multi-line metadata values continue with an empty key
+		if trimmed := strings.TrimSpace(line); trimmed == "Metadata:" || strings.HasPrefix(trimmed, ": ") {
+			continue
+		}
+
+		if m := probeMetadataRegexp.FindStringSubmatch(line); m != nil && metadata != nil && strings.HasPrefix(line, "    ") {
+			metadata(m[1], strings.TrimSpace(m[2]))
+			continue
+		}
+
+		// Anything else ends the metadata section
+		metadata = nil
+	}
+
+	return info
+}
+
+// Add stream described by the line, returns function applying stream metadata
+func (info *FileInfo) addStream(number int, language, kind, details string) func(key, value string) {
+	stream := StreamInfo{Stream: number}
+	if language != "und" {
+		stream.Language = language
+	}
+
+	// Disposition flags follow the stream details, i.e. "(default) (forced)"
+	for {
+		m := probeFlagRegexp.FindStringSubmatchIndex(details)
+		if m == nil {
+			break
+		}
+		switch details[m[2]:m[3]] {
+		case "default":
+			stream.Default = true
+		case "forced":
+			stream.Forced = true
+		}
+		details = details[:m[0]]
+	}
+
+	fields := splitProbeFields(details)
+	stream.Codec, _ = splitCodec(fields[0])
+
+	var title *string
+	switch kind {
+	case "Video":
+		video := VideoStream{StreamInfo: stream}
+		video.Index = len(info.Video)
+		_, video.Profile = splitCodec(fields[0])
+
+		for _, field := range fields[1:] {
+			switch {
+			case strings.HasSuffix(field, " fps"):
+				video.FPS = parseFloat(strings.TrimSuffix(field, " fps"))
+			case strings.HasSuffix(field, " tbr") && video.FPS == 0:
+				video.FPS = parseFloat(strings.TrimSuffix(field, " tbr"))
+			case strings.HasSuffix(field, " kb/s"):
+				video.Bitrate = atoi(strings.TrimSuffix(field, " kb/s"))
+			case video.Width == 0:
+				video.Width, video.Height = parseResolution(field)
+			}
+		}
+
+		info.Video = append(info.Video, video)
+		title = &info.Video[len(info.Video)-1].Title
+	case "Audio":
+		audio := AudioStream{StreamInfo: stream}
+		audio.Index = len(info.Audio)
+
+		for i, field := range fields[1:] {
+			switch {
+			case strings.HasSuffix(field, " Hz"):
+				audio.SampleRate = atoi(strings.TrimSuffix(field, " Hz"))
+			case strings.HasSuffix(field, " kb/s"):
+				audio.Bitrate = atoi(strings.TrimSuffix(field, " kb/s"))
+			case i == 1:
+				// Layout follows the sample rate
+				audio.Layout = field
+				audio.Channels = layoutChannels(field)
+			}
+		}
+
+		info.Audio = append(info.Audio, audio)
+		title = &info.Audio[len(info.Audio)-1].Title
+	case "Subtitle":
+		subtitle := SubtitleStream{StreamInfo: stream}
+		subtitle.Index = len(info.Subtitles)
+
+		info.Subtitles = append(info.Subtitles, subtitle)
+		title = &info.Subtitles[len(info.Subtitles)-1].Title
+	default:
+		return nil
+	}
+
+	return func(key, value string) {
+		if key == "title" {
+			*title = value
+		}
+	}
+}
+
+// Split stream details on commas outside of parentheses and brackets
+func splitProbeFields(details string) []string {
+	fields := []string{}
+	depth, start := 0, 0
+
+	for i, c := range details {
+		switch c {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				fields = append(fields, strings.TrimSpace(details[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(fields, strings.TrimSpace(details[start:]))
+}
+
+// Split codec field, i.e. "h264 (High) (avc1 / 0x31637661)", into codec and profile
+func splitCodec(field string) (string, string) {
+	codec := field
+	if i := strings.Index(field, " "); i > 0 {
+		codec = field[:i]
+	}
+
+	profile := ""
+	if start := strings.Index(field, "("); start > 0 {
+		if end := strings.Index(field[start:], ")"); end > 0 {
+			profile = field[start+1 : start+end]
+		}
+	}
+
+	// Codec tags, i.e. "(avc1 / 0x31637661)", are not profiles
+	if strings.Contains(profile, "/") {
+		profile = ""
+	}
+
+	return codec, profile
+}
+
+// Parse "1920x800 [SAR 1:1 DAR 12:5]" into width and height
+func parseResolution(field string) (int, int) {
+	if i := strings.Index(field, " "); i > 0 {
+		field = field[:i]
+	}
+
+	parts := strings.Split(field, "x")
+	if len(parts) != 2 {
+		return 0, 0
+	}
+
+	width, err1 := strconv.Atoi(parts[0])
+	height, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return 0, 0
+	}
+	return width, height
+}
+
+// Get number of channels for the audio layout, i.e. 6 for "5.1(side)"
+func layoutChannels(layout string) int {
+	if i := strings.Index(layout, "("); i > 0 {
+		layout = layout[:i]
+	}
+	if channels, ok := audioLayoutChannels[layout]; ok {
+		return channels
+	}
+	if strings.HasSuffix(layout, " channels") {
+		return atoi(strings.TrimSuffix(layout, " channels"))
+	}
+	return 0
+}
+
+// Parse "01:58:31.04" duration into seconds
+func parseProbeDuration(value string) (uint64, bool) {
+	if i := strings.Index(value, "."); i > 0 {
+		value = value[:i]
+	}
+
+	seconds, err := parseTimestamp(value)
+	return seconds, err == nil
+}
+
+func parseFloat(value string) float64 {
+	n, _ := strconv.ParseFloat(value, 64)
+	return n
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readFileInfo(t *testing.T, name string) *FileInfo {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseFileInfo(string(data))
+}
+
+func Test_parseFileInfo(t *testing.T) {
+	info := readFileInfo(t, "info_mkv.txt")
+
+	assert.Equal(t, "01:58:31", info.Duration)
+	assert.Equal(t, "matroska,webm", info.Format)
+	assert.Equal(t, 9815, info.Bitrate)
+	assert.Equal(t, map[string]string{
+		"title":         "Movie Name (2010)",
+		"encoder":       "libebml v1.3.0 + libmatroska v1.4.0",
+		"creation_time": "2013-11-02 17:21:05",
+	}, info.Metadata)
+
+	assert.Equal(t, []VideoStream{
+		{
+			StreamInfo: StreamInfo{Index: 0, Stream: 0, Codec: "h264", Language: "eng", Default: true},
+			Profile:    "High",
+			Width:      1920,
+			Height:     800,
+			FPS:        23.98,
+		},
+	}, info.Video)
+
+	assert.Equal(t, []AudioStream{
+		{
+			StreamInfo: StreamInfo{Index: 0, Stream: 1, Codec: "dts", Language: "eng", Title: "English DTS 5.1", Default: true},
+			SampleRate: 48000,
+			Layout:     "5.1(side)",
+			Channels:   6,
+			Bitrate:    1536,
+		},
+		{
+			StreamInfo: StreamInfo{Index: 1, Stream: 2, Codec: "ac3", Language: "rus", Title: "Russian"},
+			SampleRate: 48000,
+			Layout:     "stereo",
+			Channels:   2,
+			Bitrate:    192,
+		},
+	}, info.Audio)
+
+	assert.Equal(t, []SubtitleStream{
+		{StreamInfo{Index: 0, Stream: 3, Codec: "subrip", Language: "eng", Default: true}},
+		{StreamInfo{Index: 1, Stream: 4, Codec: "subrip", Language: "eng", Title: "Forced", Forced: true}},
+		{StreamInfo{Index: 2, Stream: 5, Codec: "hdmv_pgs_subtitle", Language: "fre"}},
+	}, info.Subtitles)
+
+	assert.Equal(t, []Chapter{
+		{Index: 0, Start: 0, End: 372.038, Title: "Opening"},
+		{Index: 1, Start: 372.038, End: 1795.003, Title: "The Heist"},
+		{Index: 2, Start: 1795.003, End: 7111.04, Title: "Aftermath"},
+	}, info.Chapters)
+}
+
+func Test_parseFileInfoAvi(t *testing.T) {
+	info := readFileInfo(t, "info_avi.txt")
+
+	assert.Equal(t, "01:41:12", info.Duration)
+	assert.Equal(t, "avi", info.Format)
+	assert.Equal(t, []VideoStream{
+		{
+			StreamInfo: StreamInfo{Codec: "mpeg4"},
+			Profile:    "Advanced Simple Profile",
+			Width:      640,
+			Height:     272,
+			FPS:        23.98,
+			Bitrate:    1370,
+		},
+	}, info.Video)
+	assert.Equal(t, []AudioStream{
+		{
+			StreamInfo: StreamInfo{Stream: 1, Codec: "mp3"},
+			SampleRate: 48000,
+			Layout:     "stereo",
+			Channels:   2,
+			Bitrate:    128,
+		},
+	}, info.Audio)
+	assert.Equal(t, []SubtitleStream{}, info.Subtitles)
+	assert.Equal(t, []Chapter{}, info.Chapters)
+}
+
+func Test_parseFileInfoMp4(t *testing.T) {
+	info := readFileInfo(t, "info_mp4.txt")
+
+	assert.Equal(t, "00:42:17", info.Duration)
+	assert.Equal(t, "isomiso2avc1mp41", info.Metadata["compatible_brands"])
+	assert.Equal(t, "First line", info.Metadata["comment"])
+	assert.Equal(t, "Lavf56.40.101", info.Metadata["encoder"])
+
+	assert.Len(t, info.Video, 1)
+	assert.Equal(t, "Main", info.Video[0].Profile)
+	assert.Equal(t, "", info.Video[0].Language)
+	assert.Equal(t, 1280, info.Video[0].Width)
+	assert.Equal(t, 720, info.Video[0].Height)
+	assert.Equal(t, 25.0, info.Video[0].FPS)
+
+	assert.Len(t, info.Audio, 1)
+	assert.Equal(t, "aac", info.Audio[0].Codec)
+	assert.Equal(t, 6, info.Audio[0].Channels)
+	assert.Equal(t, 44100, info.Audio[0].SampleRate)
+}
+
+func Test_parseFileInfoInvalid(t *testing.T) {
+	info := parseFileInfo("Invalid file\nhave a nice day ;)\n")
+	assert.Equal(t, "", info.Duration)
+	assert.Equal(t, []VideoStream{}, info.Video)
+}
+
+// Mimic ffprobe printing the media info fixture. Files named "broken" fail to open.
+func fakeFFprobe(args []string) {
+	file := args[len(args)-1]
+	if strings.Contains(file, "broken") {
+		fmt.Fprintln(os.Stderr, file+": Invalid data found when processing input")
+		os.Exit(1)
+	}
+
+	data, _ := ioutil.ReadFile("testdata/info_mkv.txt")
+	os.Stderr.Write(data)
+}
+
+func Test_mediaInfo(t *testing.T) {
+	defer func(omx, ffprobe string) { OmxPath, FFprobePath = omx, ffprobe }(OmxPath, FFprobePath)
+	OmxPath, FFprobePath = "", ""
+
+	assert.False(t, canProbe())
+	_, err := mediaInfo("movie.mkv")
+	assert.EqualError(t, err, "Media info requires omxplayer or ffprobe")
+
+	// ffprobe is used without omxplayer
+	FFprobePath = os.Args[0]
+	assert.True(t, canProbe())
+
+	info, err := mediaInfo("movie.mkv")
+	assert.NoError(t, err)
+	assert.Equal(t, "01:58:31", info.Duration)
+	assert.Len(t, info.Subtitles, 3)
+	assert.Len(t, info.Chapters, 3)
+
+	_, err = mediaInfo("broken.mkv")
+	assert.EqualError(t, err, "ffprobe returned error: exit status 1")
+}
+
+func Test_omxInfo(t *testing.T) {
+	defer func(path string) { OmxPath = path }(OmxPath)
+	OmxPath = os.Args[0]
+
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	probes = loadProbeCache(filepath.Join(dir, "probe.json"), 1, omxInfo)
+	defer probes.save()
+
+	info, err := omxInfo("movie.mkv")
+	assert.NoError(t, err)
+	assert.Equal(t, "01:58:31", info.Duration)
+	assert.Len(t, info.Audio, 2)
+	assert.Len(t, info.Chapters, 3)
+
+	// Duration is only probed for existing files
+	file := filepath.Join(dir, "movie.mkv")
+	assert.Equal(t, uint64(0), probeDuration(file))
+	ioutil.WriteFile(file, []byte("data"), 0644)
+	assert.Equal(t, uint64(7111), probeDuration(file))
+
+	// Failure to run omxplayer is not an empty result
+	OmxPath = "/nonexistent/omxplayer"
+	_, err = omxInfo("movie.mkv")
+	assert.Error(t, err)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	probeWorkers   = 2               // Maximum number of concurrent probes
+	preprobe       = false           // Probe files in the background when they are listed
+	probeQueueSize = 256             // Maximum number of files waiting for a background probe
+	probeSaveDelay = 5 * time.Second // Delay before probe results are written to disk
+)
+
+// Probe result of a single media file
+type probeEntry struct {
+	Path    string    `json:"path"`  // Full path to the media file
+	Size    int64     `json:"size"`  // File size, used to detect replaced files
+	ModTime time.Time `json:"mtime"` // File modification time
+	Info    *FileInfo `json:"info"`  // Probed media information
+}
+
+// Probe of a single file that other requests for the same file wait for
+type probeCall struct {
+	done chan struct{}
+	info *FileInfo
+	err  error
+}
+
+// ProbeCache keeps media probe results on disk. Probing is slow, so the
+// number of concurrent probes is limited and duplicate requests for the same
+// file share a single probe.
+type ProbeCache struct {
+	sync.Mutex
+	path     string
+	items    map[string]*probeEntry
+	inflight map[string]*probeCall
+	queued   map[string]bool // Files waiting in the prefetch queue
+	queue    chan string     // Files to probe in the background
+	workers  chan struct{}
+	probe    func(file string) (*FileInfo, error)
+	dirty    bool // Results not written to disk yet, a save is scheduled
+
+	saveLock      sync.Mutex // Serializes writes to disk
+	startPrefetch sync.Once
+}
+
+// Load probe cache, missing file results in empty cache
+func loadProbeCache(path string, workers int, probe func(file string) (*FileInfo, error)) *ProbeCache {
+	if workers < 1 {
+		workers = 1
+	}
+
+	cache := &ProbeCache{
+		path:     path,
+		items:    map[string]*probeEntry{},
+		inflight: map[string]*probeCall{},
+		queued:   map[string]bool{},
+		queue:    make(chan string, probeQueueSize),
+		workers:  make(chan struct{}, workers),
+		probe:    probe,
+	}
+
+	items := []*probeEntry{}
+	if err := loadJSON(path, &items); err != nil {
+		log.Println("Cant load probe cache:", err)
+	}
+	// Results of deleted files are dropped with the next save
+	for _, item := range items {
+		if !fileRemoved(item.Path) {
+			cache.items[item.Path] = item
+		}
+	}
+
+	return cache
+}
+
+// Get media information of the file, probing it if it is not cached or has
+// changed since
+func (c *ProbeCache) Get(file string) (*FileInfo, error) {
+	file = filepath.Clean(file)
+
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	if item, ok := c.items[file]; ok && item.matches(stat) {
+		c.Unlock()
+		return item.Info, nil
+	}
+	if call, ok := c.inflight[file]; ok {
+		c.Unlock()
+		<-call.done
+		return call.info, call.err
+	}
+
+	call := &probeCall{done: make(chan struct{})}
+	c.inflight[file] = call
+	c.Unlock()
+
+	c.workers <- struct{}{}
+	call.info, call.err = c.probe(file)
+	<-c.workers
+
+	c.Lock()
+	delete(c.inflight, file)
+	// Empty results of unreadable files are not cached, so they are probed again
+	if call.err == nil && !call.info.Empty() {
+		c.items[file] = &probeEntry{Path: file, Size: stat.Size(), ModTime: stat.ModTime(), Info: call.info}
+		c.scheduleSave()
+	}
+	c.Unlock()
+
+	close(call.done)
+	return call.info, call.err
+}
+
+// Check if the file has a valid cached result
+func (c *ProbeCache) Cached(file string) bool {
+	_, ok := c.Lookup(file)
+	return ok
+}
+
+// Get cached media information of the file without probing it
+func (c *ProbeCache) Lookup(file string) (*FileInfo, bool) {
+	file = filepath.Clean(file)
+
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	item, ok := c.items[file]
+	if !ok || !item.matches(stat) {
+		return nil, false
+	}
+	return item.Info, true
+}
+
+// Probe files that are not cached yet in the background. Files are probed by
+// a fixed number of workers, files that do not fit in the queue are skipped.
+func (c *ProbeCache) Prefetch(files []string) {
+	c.startPrefetch.Do(func() {
+		for i := 0; i < cap(c.workers); i++ {
+			go c.prefetchWorker()
+		}
+	})
+
+	for _, file := range files {
+		file = filepath.Clean(file)
+		if c.Cached(file) {
+			continue
+		}
+
+		c.Lock()
+		if !c.queued[file] {
+			select {
+			case c.queue <- file:
+				c.queued[file] = true
+			default:
+			}
+		}
+		c.Unlock()
+	}
+}
+
+func (c *ProbeCache) prefetchWorker() {
+	for file := range c.queue {
+		if _, err := c.Get(file); err != nil {
+			log.Println("Cant probe file:", err)
+		}
+
+		c.Lock()
+		delete(c.queued, file)
+		c.Unlock()
+	}
+}
+
+// Write the cache after a delay, so results of probes finishing in the
+// meantime are written at once. Lock must be held.
+func (c *ProbeCache) scheduleSave() {
+	if c.dirty {
+		return
+	}
+	c.dirty = true
+
+	time.AfterFunc(probeSaveDelay, func() {
+		if err := c.save(); err != nil {
+			log.Println("Cant save probe cache:", err)
+		}
+	})
+}
+
+// Write pending results to disk
+func (c *ProbeCache) save() error {
+	c.saveLock.Lock()
+	defer c.saveLock.Unlock()
+
+	c.Lock()
+	if !c.dirty {
+		c.Unlock()
+		return nil
+	}
+	c.dirty = false
+
+	items := make([]*probeEntry, 0, len(c.items))
+	for _, item := range c.items {
+		items = append(items, item)
+	}
+	c.Unlock()
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+	return saveJSON(c.path, items)
+}
+
+// Get media duration in seconds, 0 if unknown or media info is not available
+func probeDuration(file string) uint64 {
+	if !canProbe() {
+		return 0
+	}
+
+	info, err := probes.Get(file)
+	if err != nil || info.Duration == "" {
+		return 0
+	}
+
+	seconds, _ := parseTimestamp(info.Duration)
+	return seconds
+}
+
+// Get media duration in seconds from the cache, 0 if the file was not probed yet
+func cachedDuration(file string) uint64 {
+	if !canProbe() {
+		return 0
+	}
+
+	info, ok := probes.Lookup(file)
+	if !ok || info.Duration == "" {
+		return 0
+	}
+
+	seconds, _ := parseTimestamp(info.Duration)
+	return seconds
+}
+
+// Check if probe result belongs to the current version of the file
+func (e *probeEntry) matches(info os.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime.Equal(info.ModTime())
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Probe function counting calls and tracking the number of concurrent probes
+type countingProbe struct {
+	sync.Mutex
+	calls   map[string]int
+	running int
+	peak    int
+}
+
+func (p *countingProbe) probe(file string) (*FileInfo, error) {
+	p.Lock()
+	p.calls[filepath.Base(file)]++
+	p.running++
+	if p.running > p.peak {
+		p.peak = p.running
+	}
+	p.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	p.Lock()
+	p.running--
+	p.Unlock()
+
+	switch filepath.Base(file) {
+	case "broken.mkv":
+		return nil, errors.New("Invalid file")
+	case "empty.mkv":
+		return parseFileInfo("Invalid file\nhave a nice day ;)\n"), nil
+	}
+	return &FileInfo{Duration: "00:01:40"}, nil
+}
+
+func (p *countingProbe) count(name string) int {
+	p.Lock()
+	defer p.Unlock()
+	return p.calls[name]
+}
+
+func Test_ProbeCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{"a.mkv", "b.mkv", "c.mkv", "d.mkv", "broken.mkv", "empty.mkv"}
+	for _, name := range names {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+	}
+
+	counter := &countingProbe{calls: map[string]int{}}
+	path := filepath.Join(dir, "probe.json")
+	cache := loadProbeCache(path, 2, counter.probe)
+
+	// Concurrent requests for the same file share a single probe
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		for _, name := range names {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				cache.Get(filepath.Join(dir, name))
+			}(name)
+		}
+	}
+	wg.Wait()
+
+	for _, name := range names {
+		assert.Equal(t, 1, counter.count(name), name)
+	}
+	assert.Equal(t, 2, counter.peak)
+
+	info, err := cache.Get(filepath.Join(dir, "a.mkv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "00:01:40", info.Duration)
+	assert.Equal(t, 1, counter.count("a.mkv"))
+
+	// Failed probes are retried
+	_, err = cache.Get(filepath.Join(dir, "broken.mkv"))
+	assert.Error(t, err)
+	assert.Equal(t, 2, counter.count("broken.mkv"))
+
+	_, err = cache.Get(filepath.Join(dir, "missing.mkv"))
+	assert.Error(t, err)
+
+	// Empty results are returned but not cached
+	info, err = cache.Get(filepath.Join(dir, "empty.mkv"))
+	assert.NoError(t, err)
+	assert.True(t, info.Empty())
+	assert.False(t, cache.Cached(filepath.Join(dir, "empty.mkv")))
+	assert.Equal(t, 2, counter.count("empty.mkv"))
+
+	// Results are written together after a delay
+	assert.False(t, fileExists(path))
+	assert.NoError(t, cache.save())
+	cache = loadProbeCache(path, 2, counter.probe)
+	assert.True(t, cache.Cached(filepath.Join(dir, "b.mkv")))
+	_, err = cache.Get(filepath.Join(dir, "b.mkv"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, counter.count("b.mkv"))
+
+	// Changed files are probed again
+	ioutil.WriteFile(filepath.Join(dir, "b.mkv"), []byte("new data"), 0644)
+	assert.False(t, cache.Cached(filepath.Join(dir, "b.mkv")))
+	_, err = cache.Get(filepath.Join(dir, "b.mkv"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, counter.count("b.mkv"))
+
+	// Results of deleted files are dropped
+	assert.NoError(t, cache.save())
+	os.Remove(filepath.Join(dir, "c.mkv"))
+	cache = loadProbeCache(path, 2, counter.probe)
+	assert.NotContains(t, cache.items, filepath.Join(dir, "c.mkv"))
+	assert.Contains(t, cache.items, filepath.Join(dir, "d.mkv"))
+}
+
+func Test_ProbeCachePrefetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{filepath.Join(dir, "a.mkv"), filepath.Join(dir, "b.mkv")}
+	for _, file := range files {
+		ioutil.WriteFile(file, []byte("data"), 0644)
+	}
+
+	counter := &countingProbe{calls: map[string]int{}}
+	cache := loadProbeCache(filepath.Join(dir, "probe.json"), 1, counter.probe)
+	defer cache.save()
+
+	cache.Prefetch(files)
+
+	deadline := time.Now().Add(time.Second)
+	for !cache.Cached(files[0]) || !cache.Cached(files[1]) {
+		if time.Now().After(deadline) {
+			t.Fatal("files were not probed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cache.Prefetch(files)
+	_, err = cache.Get(files[1])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, counter.count("a.mkv"))
+	assert.Equal(t, 1, counter.count("b.mkv"))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// How often playback position is recorded
+	progressInterval = 10 * time.Second
+
+	// Fraction of the duration after which media is considered watched
+	watchedThreshold = 0.9
+
+	// Maximum number of files with saved progress, least recently played are dropped
+	progressLimit = 1000
+)
+
+// Progress is the saved playback state of a single media file
+type Progress struct {
+	Path       string    `json:"path"`        // Full path to the media file
+	Size       int64     `json:"size"`        // File size, used to detect replaced files
+	ModTime    time.Time `json:"mtime"`       // File modification time
+	Position   uint64    `json:"position"`    // Last position in seconds
+	Duration   uint64    `json:"duration"`    // Media duration in seconds
+	Watched    bool      `json:"watched"`     // Set when playback passed the threshold
+	LastPlayed time.Time `json:"last_played"` // Time of the last update
+
+	SubtitleDelay int64 `json:"subtitle_delay,omitempty"` // Subtitle timing offset in milliseconds
+}
+
+// ProgressStore keeps playback progress of media files on disk
+type ProgressStore struct {
+	sync.Mutex
+	path  string
+	items map[string]*Progress
+	dirty bool
+}
+
+// Load progress store, missing file results in empty store
+func loadProgressStore(path string) *ProgressStore {
+	store := &ProgressStore{path: path, items: map[string]*Progress{}}
+
+	items := []*Progress{}
+	if err := loadJSON(path, &items); err != nil {
+		log.Println("Cant load playback progress:", err)
+	}
+	for _, item := range items {
+		if fileRemoved(item.Path) {
+			store.dirty = true
+			continue
+		}
+		store.items[item.Path] = item
+	}
+
+	return store
+}
+
+// Get progress of the file. Progress is discarded if the file has changed since.
+func (s *ProgressStore) Get(file string) (Progress, bool) {
+	file = filepath.Clean(file)
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return Progress{}, false
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	item, ok := s.items[file]
+	if !ok || !item.matches(info) {
+		return Progress{}, false
+	}
+	return *item, true
+}
+
+// Record playback position of the file. Watched flag follows the position,
+// so it is cleared when the file is played again from the start.
+func (s *ProgressStore) Update(file string, position, duration uint64) error {
+	return s.update(file, func(item *Progress) {
+		item.Position = position
+		item.Duration = duration
+		item.Watched = duration > 0 && float64(position) >= float64(duration)*watchedThreshold
+	})
+}
+
+// Mark file as watched to the end
+func (s *ProgressStore) Finish(file string) error {
+	return s.update(file, func(item *Progress) {
+		item.Position = item.Duration
+		item.Watched = true
+	})
+}
+
+// Remember subtitle timing offset of the file
+func (s *ProgressStore) SetSubtitleDelay(file string, delay int64) error {
+	return s.update(file, func(item *Progress) {
+		item.SubtitleDelay = delay
+	})
+}
+
+func (s *ProgressStore) update(file string, fn func(item *Progress)) error {
+	file = filepath.Clean(file)
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	item, ok := s.items[file]
+	if !ok || !item.matches(info) {
+		item = &Progress{Path: file, Size: info.Size(), ModTime: info.ModTime()}
+		s.items[file] = item
+	}
+
+	fn(item)
+	item.LastPlayed = time.Now()
+	s.dirty = true
+
+	return nil
+}
+
+// Write store to disk if anything has changed
+func (s *ProgressStore) Save() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	items := make([]*Progress, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+
+	if len(items) > progressLimit {
+		sort.Slice(items, func(i, j int) bool { return items[i].LastPlayed.After(items[j].LastPlayed) })
+		for _, item := range items[progressLimit:] {
+			delete(s.items, item.Path)
+		}
+		items = items[:progressLimit]
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+
+	if err := saveJSON(s.path, items); err != nil {
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
+
+// Check if progress belongs to the current version of the file
+func (p *Progress) matches(info os.FileInfo) bool {
+	return p.Size == info.Size() && p.ModTime.Equal(info.ModTime())
+}
+
+// Periodically record playback position of the running player
+func trackProgress(store *ProgressStore, p Player) {
+	for range time.Tick(progressInterval) {
+		recordProgress(store, p)
+	}
+}
+
+func recordProgress(store *ProgressStore, p Player) {
+	status := p.Status()
+	if status.State != StatePlaying && status.State != StatePaused {
+		return
+	}
+	saveProgress(store, status.File, status.Position, status.Duration)
+}
+
+// Record playback position of the file and save the store
+func saveProgress(store *ProgressStore, file string, position, duration uint64) {
+	if position == 0 {
+		return
+	}
+
+	if err := store.Update(file, position, duration); err != nil {
+		// Streams and removed files are not tracked
+		return
+	}
+
+	if err := store.Save(); err != nil {
+		log.Println("Cant save playback progress:", err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ProgressStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "movie.mkv")
+	ioutil.WriteFile(file, []byte("data"), 0644)
+
+	store := loadProgressStore(filepath.Join(dir, "progress.json"))
+
+	_, ok := store.Get(file)
+	assert.False(t, ok)
+
+	assert.Error(t, store.Update(filepath.Join(dir, "missing.mkv"), 10, 100))
+	assert.NoError(t, store.Update(file, 600, 3600))
+
+	item, ok := store.Get(file)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(600), item.Position)
+	assert.Equal(t, uint64(3600), item.Duration)
+	assert.False(t, item.Watched)
+
+	// Progress survives restarts
+	assert.NoError(t, store.Save())
+	store = loadProgressStore(filepath.Join(dir, "progress.json"))
+	item, ok = store.Get(dir + "//movie.mkv")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(600), item.Position)
+
+	// Passing the threshold marks file as watched
+	assert.NoError(t, store.Update(file, 3300, 3600))
+	item, _ = store.Get(file)
+	assert.True(t, item.Watched)
+
+	// Playing it again clears the flag
+	assert.NoError(t, store.Update(file, 60, 3600))
+	item, _ = store.Get(file)
+	assert.False(t, item.Watched)
+
+	// Replaced file does not inherit progress
+	time.Sleep(10 * time.Millisecond)
+	ioutil.WriteFile(file, []byte("other data"), 0644)
+	_, ok = store.Get(file)
+	assert.False(t, ok)
+
+	assert.NoError(t, store.Finish(file))
+	item, _ = store.Get(file)
+	assert.True(t, item.Watched)
+	assert.Equal(t, uint64(0), item.Position)
+}
+
+func Test_ProgressStorePrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(limit int) { progressLimit = limit }(progressLimit)
+	progressLimit = 2
+
+	files := []string{}
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv"} {
+		file := filepath.Join(dir, name)
+		ioutil.WriteFile(file, []byte("data"), 0644)
+		files = append(files, file)
+	}
+
+	store := loadProgressStore(filepath.Join(dir, "progress.json"))
+	for _, file := range files {
+		assert.NoError(t, store.Update(file, 10, 100))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Least recently played file is dropped over the limit
+	assert.NoError(t, store.Save())
+	_, ok := store.Get(files[0])
+	assert.False(t, ok)
+
+	// Deleted files are dropped, files on missing drives are kept
+	progressLimit = 10
+	store.items["/missing/movie.mkv"] = &Progress{Path: "/missing/movie.mkv", LastPlayed: time.Now()}
+	store.dirty = true
+	assert.NoError(t, store.Save())
+	os.Remove(files[1])
+
+	store = loadProgressStore(filepath.Join(dir, "progress.json"))
+	assert.Len(t, store.items, 2)
+	assert.Contains(t, store.items, files[2])
+	assert.Contains(t, store.items, "/missing/movie.mkv")
+}
+
+func Test_recordProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "movie.mkv")
+	ioutil.WriteFile(file, []byte("data"), 0644)
+
+	store := loadProgressStore(filepath.Join(dir, "progress.json"))
+	fake := newFakePlayer()
+
+	recordProgress(store, fake)
+	assert.False(t, fileExists(store.path))
+
+	fake.Play(file, PlayOptions{Position: 90 * time.Second})
+	recordProgress(store, fake)
+
+	item, ok := loadProgressStore(store.path).Get(file)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(90), item.Position)
+	assert.Equal(t, uint64(3600), item.Duration)
+}
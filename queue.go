@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+)
+
+// Queue repeat modes
+const (
+	RepeatOff = "off" // Stop after the last item
+	RepeatOne = "one" // Play current item again
+	RepeatAll = "all" // Start over after the last item
+)
+
+var (
+	ErrQueueIndex = errors.New("Invalid queue index")
+	ErrQueueEnd   = errors.New("No more items in the queue")
+)
+
+// Queue is the server-owned list of media files to play one after another
+type Queue struct {
+	sync.Mutex
+	path    string
+	Items   []string `json:"items"`   // Media files relative to the media path
+	Current int      `json:"current"` // Index of the playing item, -1 if none
+	Repeat  string   `json:"repeat"`  // Repeat mode
+	Shuffle bool     `json:"shuffle"` // Set when items were shuffled
+}
+
+// Load queue saved by the previous server run
+func loadQueue(path string) *Queue {
+	q := &Queue{path: path, Items: []string{}, Current: -1, Repeat: RepeatOff}
+	if err := loadJSON(path, q); err != nil {
+		log.Println("Cant load queue:", err)
+	}
+	if q.Current >= len(q.Items) {
+		q.Current = -1
+	}
+	if validateRepeat(q.Repeat) != nil {
+		q.Repeat = RepeatOff
+	}
+	return q
+}
+
+// Get queued items and index of the current one
+func (q *Queue) List() ([]string, int) {
+	q.Lock()
+	defer q.Unlock()
+
+	items := make([]string, len(q.Items))
+	copy(items, q.Items)
+	return items, q.Current
+}
+
+// Insert item at the given index, negative index appends to the end
+func (q *Queue) Add(file string, index int) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if index < 0 {
+		index = len(q.Items)
+	}
+	if index > len(q.Items) {
+		return ErrQueueIndex
+	}
+
+	q.Items = append(q.Items, "")
+	copy(q.Items[index+1:], q.Items[index:])
+	q.Items[index] = file
+
+	if q.Current >= index {
+		q.Current++
+	}
+
+	return q.save()
+}
+
+// Remove item at the given index
+func (q *Queue) Remove(index int) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if index < 0 || index >= len(q.Items) {
+		return ErrQueueIndex
+	}
+
+	q.Items = append(q.Items[:index], q.Items[index+1:]...)
+
+	switch {
+	case q.Current == index:
+		q.Current = -1
+	case q.Current > index:
+		q.Current--
+	}
+
+	return q.save()
+}
+
+// Move item to another position
+func (q *Queue) Move(from, to int) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if from < 0 || from >= len(q.Items) || to < 0 || to >= len(q.Items) {
+		return ErrQueueIndex
+	}
+
+	item := q.Items[from]
+	q.Items = append(q.Items[:from], q.Items[from+1:]...)
+	q.Items = append(q.Items[:to], append([]string{item}, q.Items[to:]...)...)
+
+	// Keep pointing at the same item
+	switch {
+	case q.Current == from:
+		q.Current = to
+	case from < q.Current && q.Current <= to:
+		q.Current--
+	case to <= q.Current && q.Current < from:
+		q.Current++
+	}
+
+	return q.save()
+}
+
+// Replace all items, no item becomes current
+func (q *Queue) Replace(items []string) error {
+	q.Lock()
+	defer q.Unlock()
+
+	q.Items = items
+	q.Current = -1
+
+	if q.Shuffle {
+		q.shuffle()
+	}
+
+	return q.save()
+}
+
+// Get repeat mode and shuffle flag
+func (q *Queue) Mode() (string, bool) {
+	q.Lock()
+	defer q.Unlock()
+	return q.Repeat, q.Shuffle
+}
+
+// Change repeat mode and shuffle flag. Enabling shuffle randomizes order of
+// items after the current one, disabling it keeps the order.
+func (q *Queue) SetMode(repeat string, shuffle bool) error {
+	if err := validateRepeat(repeat); err != nil {
+		return err
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if shuffle && !q.Shuffle {
+		q.shuffle()
+	}
+
+	q.Repeat = repeat
+	q.Shuffle = shuffle
+
+	return q.save()
+}
+
+// Shuffle items after the current one. Caller must hold the lock.
+func (q *Queue) shuffle() {
+	rest := q.Items[q.Current+1:]
+	rand.Shuffle(len(rest), func(i, j int) {
+		rest[i], rest[j] = rest[j], rest[i]
+	})
+}
+
+// Remove all items
+func (q *Queue) Clear() error {
+	q.Lock()
+	defer q.Unlock()
+
+	q.Items = []string{}
+	q.Current = -1
+
+	return q.save()
+}
+
+// Get item at the given offset from the current one and make it current.
+// Without current item, next starts from the beginning of the queue.
+func (q *Queue) Step(offset int) (string, error) {
+	q.Lock()
+	defer q.Unlock()
+	return q.step(offset)
+}
+
+// Get item to play after the current one finished playing
+func (q *Queue) Advance() (string, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.Repeat == RepeatOne && q.Current >= 0 {
+		return q.Items[q.Current], nil
+	}
+	return q.step(1)
+}
+
+// Caller must hold the lock
+func (q *Queue) step(offset int) (string, error) {
+	index := q.Current + offset
+	if q.Current < 0 {
+		index = 0
+	}
+
+	// Wrap around both ends of the queue
+	if q.Repeat == RepeatAll && len(q.Items) > 0 {
+		index = (index%len(q.Items) + len(q.Items)) % len(q.Items)
+	}
+
+	if index < 0 || index >= len(q.Items) {
+		return "", ErrQueueEnd
+	}
+
+	q.Current = index
+	return q.Items[index], q.save()
+}
+
+// Make item at the given index current
+func (q *Queue) Select(index int) (string, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if index < 0 || index >= len(q.Items) {
+		return "", ErrQueueIndex
+	}
+
+	q.Current = index
+	return q.Items[index], q.save()
+}
+
+// Mark the first occurrence of the file as current, or reset current item
+// if the file is not queued
+func (q *Queue) SetCurrent(file string) error {
+	q.Lock()
+	defer q.Unlock()
+
+	q.Current = -1
+	for i, item := range q.Items {
+		if item == file {
+			q.Current = i
+			break
+		}
+	}
+
+	return q.save()
+}
+
+// Get current item, empty if none
+func (q *Queue) CurrentItem() string {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.Current < 0 {
+		return ""
+	}
+	return q.Items[q.Current]
+}
+
+// Caller must hold the lock
+func (q *Queue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	return saveJSON(q.path, q)
+}
+
+func validateRepeat(mode string) error {
+	switch mode {
+	case RepeatOff, RepeatOne, RepeatAll:
+		return nil
+	}
+	return fmt.Errorf("Invalid repeat mode: %s", mode)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Queue(t *testing.T) {
+	q := &Queue{Items: []string{}, Current: -1}
+
+	assert.NoError(t, q.Add("a.mkv", -1))
+	assert.NoError(t, q.Add("c.mkv", -1))
+	assert.NoError(t, q.Add("b.mkv", 1))
+	assert.Equal(t, ErrQueueIndex, q.Add("d.mkv", 5))
+
+	items, current := q.List()
+	assert.Equal(t, []string{"a.mkv", "b.mkv", "c.mkv"}, items)
+	assert.Equal(t, -1, current)
+
+	file, err := q.Step(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "a.mkv", file)
+
+	_, err = q.Step(-1)
+	assert.Equal(t, ErrQueueEnd, err)
+
+	file, _ = q.Step(1)
+	assert.Equal(t, "b.mkv", file)
+
+	// Current item follows reordering
+	assert.NoError(t, q.Move(1, 2))
+	assert.Equal(t, 2, q.Current)
+	assert.NoError(t, q.Move(0, 2))
+	assert.Equal(t, 1, q.Current)
+	assert.NoError(t, q.Move(2, 0))
+	assert.Equal(t, 2, q.Current)
+	assert.Equal(t, "b.mkv", q.CurrentItem())
+
+	items, _ = q.List()
+	assert.Equal(t, []string{"a.mkv", "c.mkv", "b.mkv"}, items)
+
+	assert.NoError(t, q.Add("d.mkv", 0))
+	assert.Equal(t, "b.mkv", q.CurrentItem())
+
+	assert.NoError(t, q.Remove(0))
+	assert.Equal(t, "b.mkv", q.CurrentItem())
+	assert.NoError(t, q.Remove(2))
+	assert.Equal(t, "", q.CurrentItem())
+	assert.Equal(t, ErrQueueIndex, q.Remove(2))
+
+	assert.NoError(t, q.SetCurrent("c.mkv"))
+	assert.Equal(t, 1, q.Current)
+	assert.NoError(t, q.SetCurrent("x.mkv"))
+	assert.Equal(t, -1, q.Current)
+
+	assert.NoError(t, q.Clear())
+	items, current = q.List()
+	assert.Equal(t, []string{}, items)
+	assert.Equal(t, -1, current)
+}
+
+func Test_QueueRepeat(t *testing.T) {
+	q := &Queue{Items: []string{"a.mkv", "b.mkv", "c.mkv"}, Current: -1, Repeat: RepeatAll}
+
+	file, _ := q.Step(-1)
+	assert.Equal(t, "a.mkv", file)
+	file, _ = q.Step(-1)
+	assert.Equal(t, "c.mkv", file)
+	file, _ = q.Advance()
+	assert.Equal(t, "a.mkv", file)
+
+	assert.NoError(t, q.SetMode(RepeatOne, false))
+	file, _ = q.Advance()
+	assert.Equal(t, "a.mkv", file)
+	file, _ = q.Step(1)
+	assert.Equal(t, "b.mkv", file)
+
+	assert.NoError(t, q.SetMode(RepeatOff, false))
+	q.Select(2)
+	_, err := q.Advance()
+	assert.Equal(t, ErrQueueEnd, err)
+
+	assert.EqualError(t, q.SetMode("twice", false), "Invalid repeat mode: twice")
+
+	// Shuffle keeps played items in place
+	q.Replace([]string{"1", "2", "3", "4", "5", "6", "7", "8"})
+	q.Select(1)
+	assert.NoError(t, q.SetMode(RepeatOff, true))
+	items, _ := q.List()
+	assert.Equal(t, []string{"1", "2"}, items[:2])
+	assert.ElementsMatch(t, []string{"3", "4", "5", "6", "7", "8"}, items[2:])
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SeekRequest describes a seek target. Exactly one of the fields must be set.
+type SeekRequest struct {
+	Position *string  `json:"position"` // Timestamp, i.e. "01:12:30"
+	Seconds  *float64 `json:"seconds"`  // Absolute position in seconds
+	Percent  *float64 `json:"percent"`  // Position relative to duration
+	Offset   *float64 `json:"offset"`   // Offset in seconds from the current position
+}
+
+// Parse "hh:mm:ss", "mm:ss" or "ss" timestamp into seconds
+func parseTimestamp(value string) (uint64, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("Invalid timestamp: %s", value)
+	}
+
+	var seconds uint64
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid timestamp: %s", value)
+		}
+		seconds = seconds*60 + n
+	}
+
+	return seconds, nil
+}
+
+// Resolve seek request into absolute position in seconds, validated against
+// the current playback position and duration
+func (r SeekRequest) Target(status PlayerStatus) (uint64, error) {
+	set := 0
+	for _, field := range []bool{r.Position != nil, r.Seconds != nil, r.Percent != nil, r.Offset != nil} {
+		if field {
+			set++
+		}
+	}
+	if set != 1 {
+		return 0, errors.New("Exactly one of position, seconds, percent or offset is required")
+	}
+
+	var target float64
+
+	switch {
+	case r.Position != nil:
+		seconds, err := parseTimestamp(*r.Position)
+		if err != nil {
+			return 0, err
+		}
+		target = float64(seconds)
+	case r.Seconds != nil:
+		target = *r.Seconds
+	case r.Percent != nil:
+		if status.Duration == 0 {
+			return 0, errors.New("Duration is unknown")
+		}
+		if *r.Percent < 0 || *r.Percent > 100 {
+			return 0, errors.New("Percent must be between 0 and 100")
+		}
+		target = float64(status.Duration) * *r.Percent / 100
+	case r.Offset != nil:
+		// Relative seeks are clamped to the media boundaries
+		target = float64(status.Position) + *r.Offset
+		if target < 0 {
+			target = 0
+		}
+		if status.Duration > 0 && target > float64(status.Duration) {
+			target = float64(status.Duration)
+		}
+	}
+
+	if target < 0 {
+		return 0, errors.New("Position must not be negative")
+	}
+	if status.Duration > 0 && target > float64(status.Duration) {
+		return 0, fmt.Errorf("Position is beyond duration %s", durationFromSeconds(status.Duration))
+	}
+
+	return uint64(target), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseTimestamp(t *testing.T) {
+	examples := map[string]uint64{
+		"01:12:30": 4350,
+		"12:30":    750,
+		"90":       90,
+		" 0:00:05": 5,
+	}
+
+	for input, expected := range examples {
+		value, err := parseTimestamp(input)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, value)
+	}
+
+	for _, input := range []string{"", "1:2:3:4", "aa:bb", "-10"} {
+		_, err := parseTimestamp(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func Test_SeekRequestTarget(t *testing.T) {
+	status := PlayerStatus{Position: 600, Duration: 5400}
+
+	str := func(v string) *string { return &v }
+	num := func(v float64) *float64 { return &v }
+
+	examples := []struct {
+		req    SeekRequest
+		target uint64
+		err    string
+	}{
+		{SeekRequest{Position: str("01:12:30")}, 4350, ""},
+		{SeekRequest{Seconds: num(4350)}, 4350, ""},
+		{SeekRequest{Percent: num(42)}, 2268, ""},
+		{SeekRequest{Offset: num(-10)}, 590, ""},
+		{SeekRequest{Offset: num(-1000)}, 0, ""},
+		{SeekRequest{Offset: num(10000)}, 5400, ""},
+		{SeekRequest{}, 0, "Exactly one of position, seconds, percent or offset is required"},
+		{SeekRequest{Seconds: num(1), Offset: num(1)}, 0, "Exactly one of position, seconds, percent or offset is required"},
+		{SeekRequest{Position: str("x")}, 0, "Invalid timestamp: x"},
+		{SeekRequest{Seconds: num(-1)}, 0, "Position must not be negative"},
+		{SeekRequest{Seconds: num(6000)}, 0, "Position is beyond duration 01:30:00"},
+		{SeekRequest{Percent: num(101)}, 0, "Percent must be between 0 and 100"},
+	}
+
+	for _, example := range examples {
+		target, err := example.req.Target(status)
+		if example.err != "" {
+			assert.EqualError(t, err, example.err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, example.target, target)
+	}
+
+	// Percent requires known duration
+	_, err := SeekRequest{Percent: num(50)}.Target(PlayerStatus{})
+	assert.EqualError(t, err, "Duration is unknown")
+
+	// Without duration absolute positions are not limited
+	target, err := SeekRequest{Seconds: num(6000)}.Target(PlayerStatus{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(6000), target)
+}
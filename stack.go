@@ -0,0 +1,337 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Part marker at the end of the filename, i.e. "Movie.cd1" or "Movie (Part 2)"
+var RegexStackPart = regexp.MustCompile(`(?i)[ ._-]*[\[(]?\b(?:cd|dvd|part|pt|disc|disk)[ ._-]?(\d{1,2})[\])]?$`)
+
+// Split filename into a key shared by all parts of the movie and the part number
+func stackPart(name string) (string, int, bool) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	loc := RegexStackPart.FindStringSubmatchIndex(base)
+	if loc == nil || loc[0] == 0 {
+		return "", 0, false
+	}
+
+	return strings.ToLower(base[:loc[0]] + ext), atoi(base[loc[2]:loc[3]]), true
+}
+
+// Group parts of multi-part movies. Result maps the first part of every movie
+// to all of its parts ordered by part number.
+func groupStacks(names []string) map[string][]string {
+	type part struct {
+		name   string
+		number int
+	}
+
+	groups := map[string][]part{}
+	for _, name := range names {
+		if key, number, ok := stackPart(name); ok {
+			groups[key] = append(groups[key], part{name, number})
+		}
+	}
+
+	stacks := map[string][]string{}
+	for _, parts := range groups {
+		if len(parts) < 2 {
+			continue
+		}
+
+		sort.Slice(parts, func(i, j int) bool { return parts[i].number < parts[j].number })
+
+		names := make([]string, len(parts))
+		for i, p := range parts {
+			names[i] = p.name
+		}
+		stacks[names[0]] = names
+	}
+
+	return stacks
+}
+
+// Find all parts of the multi-part movie the file belongs to, nil if the file is not stacked
+func findStack(path string) []string {
+	key, _, ok := stackPart(filepath.Base(path))
+	if !ok {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if k, _, ok := stackPart(file.Name()); ok && k == key && omxCanPlay(file.Name()) {
+			names = append(names, file.Name())
+		}
+	}
+
+	for _, parts := range groupStacks(names) {
+		result := make([]string, len(parts))
+		for i, name := range parts {
+			result[i] = filepath.Join(dir, name)
+		}
+		return result
+	}
+
+	return nil
+}
+
+// StackPlayback tracks playback of a multi-part movie, so parts are played
+// back-to-back and reported as a single movie
+type StackPlayback struct {
+	sync.Mutex
+	parts     []string    // Full paths of all parts
+	durations []uint64    // Duration of every part in seconds, 0 if unknown
+	current   int         // Index of the playing part
+	opts      PlayOptions // Options used for all parts
+}
+
+// Setup stack playback for the file. Playing a part of the current stack
+// only changes the current part.
+func (s *StackPlayback) Prepare(file string, opts PlayOptions) {
+	opts.Position = 0
+	// External subtitles and their timing belong to a single part
+	opts.Subtitles = ""
+	opts.SubtitleDelay = 0
+
+	s.Lock()
+	for i, part := range s.parts {
+		if part == file {
+			s.current = i
+			s.opts = opts
+			s.Unlock()
+			return
+		}
+	}
+	s.Unlock()
+
+	parts := findStack(file)
+
+	// Part durations are needed to report position in the whole movie. Parts
+	// that are not probed yet are probed in the background and picked up later.
+	durations := make([]uint64, len(parts))
+	missing := []string{}
+	for i, part := range parts {
+		if durations[i] = cachedDuration(part); durations[i] > 0 {
+			continue
+		}
+		if item, ok := progress.Get(part); ok {
+			durations[i] = item.Duration
+		}
+		if durations[i] == 0 && canProbe() {
+			missing = append(missing, part)
+		}
+	}
+	probes.Prefetch(missing)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.parts = parts
+	s.durations = durations
+	s.current = 0
+	s.opts = opts
+
+	for i, part := range parts {
+		if part == file {
+			s.current = i
+		}
+	}
+}
+
+// Get the part following the finished one
+func (s *StackPlayback) Next(finished string) (string, PlayOptions, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.parts) == 0 || s.parts[s.current] != finished || s.current == len(s.parts)-1 {
+		return "", PlayOptions{}, false
+	}
+
+	return s.parts[s.current+1], s.opts, true
+}
+
+// Get the first part of the stack the file belongs to, or the file itself
+func (s *StackPlayback) First(file string) string {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, part := range s.parts {
+		if part == file {
+			return s.parts[0]
+		}
+	}
+	return file
+}
+
+// Get part number of the file and number of parts, zero if the file is not
+// part of the played stack
+func (s *StackPlayback) Part(file string) (int, int) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, part := range s.parts {
+		if part == file {
+			return i + 1, len(s.parts)
+		}
+	}
+	return 0, 0
+}
+
+// Convert status of the playing part into status of the whole movie. Total
+// duration is only reported when durations of all parts are known.
+func (s *StackPlayback) Adjust(status PlayerStatus) PlayerStatus {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.parts) == 0 || status.File != s.parts[s.current] {
+		return status
+	}
+
+	if status.Duration > 0 {
+		s.durations[s.current] = status.Duration
+	}
+	s.fillDurations()
+
+	var offset, total uint64
+	known := true
+
+	for i, duration := range s.durations {
+		if i < s.current {
+			offset += duration
+		}
+		if duration == 0 {
+			known = false
+		}
+		total += duration
+	}
+
+	status.Position += offset
+	status.Duration = 0
+	if known {
+		status.Duration = total
+	}
+
+	return status
+}
+
+// Find the part containing the position of the whole movie. Returns the part,
+// its index and position within the part.
+func (s *StackPlayback) Locate(position uint64) (string, int, uint64, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.parts) == 0 {
+		return "", 0, 0, false
+	}
+	s.fillDurations()
+
+	for i, duration := range s.durations {
+		if duration == 0 {
+			return "", 0, 0, false
+		}
+		if position < duration || i == len(s.parts)-1 {
+			return s.parts[i], i, position, true
+		}
+		position -= duration
+	}
+
+	return "", 0, 0, false
+}
+
+// Pick up durations of parts probed since the stack was prepared, lock must be held
+func (s *StackPlayback) fillDurations() {
+	for i, duration := range s.durations {
+		if duration == 0 {
+			s.durations[i] = cachedDuration(s.parts[i])
+		}
+	}
+}
+
+// Current part index and playback options
+func (s *StackPlayback) Current() (int, PlayOptions) {
+	s.Lock()
+	defer s.Unlock()
+	return s.current, s.opts
+}
+
+// Combine saved progress of all parts in the directory. Duration is only
+// known when all parts were played once.
+func stackProgress(dir string, parts []string) (Progress, bool) {
+	result := Progress{}
+	found, known := false, true
+
+	for _, part := range parts {
+		item, ok := progress.Get(filepath.Join(dir, part))
+		if !ok || item.Duration == 0 {
+			known = false
+		}
+		if !ok {
+			continue
+		}
+
+		// Position is reported in the last part played
+		if !found || item.LastPlayed.After(result.LastPlayed) {
+			result.Position = result.Duration + item.Position
+			result.Watched = item.Watched && part == parts[len(parts)-1]
+			result.LastPlayed = item.LastPlayed
+		}
+
+		result.Duration += item.Duration
+		found = true
+	}
+
+	if !known {
+		result.Duration = 0
+	}
+
+	return result, found
+}
+
+// Find the part and position within it to resume the stack from. Playback
+// continues in the last part played, or from the start of the following part
+// when it was watched to the end.
+func stackResume(parts []string) (string, uint64, bool) {
+	var last Progress
+	index := -1
+
+	for i, part := range parts {
+		if item, ok := progress.Get(part); ok && (index < 0 || item.LastPlayed.After(last.LastPlayed)) {
+			last, index = item, i
+		}
+	}
+
+	switch {
+	case index < 0:
+		return "", 0, false
+	case !last.Watched:
+		return parts[index], last.Position, true
+	case index < len(parts)-1:
+		return parts[index+1], 0, true
+	}
+	return "", 0, false
+}
+
+// Get filename without the part marker, i.e. "Movie.avi" for "Movie.cd1.avi"
+func stackName(name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	if loc := RegexStackPart.FindStringIndex(base); loc != nil && loc[0] > 0 {
+		return base[:loc[0]] + ext
+	}
+	return name
+}
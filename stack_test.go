@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_stackPart(t *testing.T) {
+	examples := map[string][2]interface{}{
+		"Movie.cd1.avi":              {"movie.avi", 1},
+		"Movie.CD2.avi":              {"movie.avi", 2},
+		"Movie (2001) part1.mkv":     {"movie (2001).mkv", 1},
+		"Movie - Part 2.mp4":         {"movie.mp4", 2},
+		"Movie.pt3.avi":              {"movie.avi", 3},
+		"Movie [Disc 1].mkv":         {"movie.mkv", 1},
+		"Movie.2003.DVDRip-dvd2.avi": {"movie.2003.dvdrip.avi", 2},
+	}
+
+	for name, expected := range examples {
+		key, number, ok := stackPart(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, expected[0], key, name)
+		assert.Equal(t, expected[1], number, name)
+	}
+
+	for _, name := range []string{"Movie.avi", "cd1.avi", "Movie.Apart2.avi", "Movie.S01E02.mkv"} {
+		_, _, ok := stackPart(name)
+		assert.False(t, ok, name)
+	}
+
+	assert.Equal(t, "Movie.avi", stackName("Movie.cd1.avi"))
+	assert.Equal(t, "Movie.avi", stackName("Movie.avi"))
+}
+
+func Test_groupStacks(t *testing.T) {
+	stacks := groupStacks([]string{
+		"Movie.cd2.avi",
+		"Movie.cd1.avi",
+		"Other part1.mkv",
+		"Single.cd1.avi",
+		"Another.avi",
+	})
+
+	assert.Equal(t, map[string][]string{
+		"Movie.cd1.avi": {"Movie.cd1.avi", "Movie.cd2.avi"},
+	}, stacks)
+}
+
+func Test_findStack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"Movie.cd1.avi", "Movie.cd2.avi", "Movie.cd1.srt", "Single.cd1.avi"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+	}
+
+	expected := []string{filepath.Join(dir, "Movie.cd1.avi"), filepath.Join(dir, "Movie.cd2.avi")}
+	assert.Equal(t, expected, findStack(filepath.Join(dir, "Movie.cd2.avi")))
+	assert.Nil(t, findStack(filepath.Join(dir, "Single.cd1.avi")))
+	assert.Nil(t, findStack(filepath.Join(dir, "Other.avi")))
+
+	files, err := scanMediaFiles(dir, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Movie.cd1.avi", "Single.cd1.avi"}, files)
+}
+
+func Test_StackPlayback(t *testing.T) {
+	s := &StackPlayback{
+		parts:     []string{"a.cd1.avi", "a.cd2.avi", "a.cd3.avi"},
+		durations: []uint64{600, 0, 300},
+		current:   1,
+	}
+
+	// Total duration is unknown until all parts were probed
+	status := s.Adjust(PlayerStatus{File: "a.cd2.avi", Position: 100})
+	assert.Equal(t, uint64(700), status.Position)
+	assert.Equal(t, uint64(0), status.Duration)
+
+	status = s.Adjust(PlayerStatus{File: "a.cd2.avi", Position: 100, Duration: 900})
+	assert.Equal(t, uint64(700), status.Position)
+	assert.Equal(t, uint64(1800), status.Duration)
+
+	// Other files are reported as is
+	status = s.Adjust(PlayerStatus{File: "b.avi", Position: 100, Duration: 900})
+	assert.Equal(t, uint64(100), status.Position)
+
+	examples := map[uint64][2]interface{}{
+		0:    {"a.cd1.avi", uint64(0)},
+		599:  {"a.cd1.avi", uint64(599)},
+		600:  {"a.cd2.avi", uint64(0)},
+		1600: {"a.cd3.avi", uint64(100)},
+		1900: {"a.cd3.avi", uint64(400)},
+	}
+	for target, expected := range examples {
+		part, _, position, ok := s.Locate(target)
+		assert.True(t, ok)
+		assert.Equal(t, expected[0], part, target)
+		assert.Equal(t, expected[1], position, target)
+	}
+
+	part, number := s.Part("a.cd3.avi")
+	assert.Equal(t, 3, part)
+	assert.Equal(t, 3, number)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+)
+
+type PlayerState string
+
+// Playback states
+const (
+	StateIdle     PlayerState = "idle"     // Nothing is playing
+	StateStarting PlayerState = "starting" // Player process is being launched
+	StatePlaying  PlayerState = "playing"  // Media is playing
+	StatePaused   PlayerState = "paused"   // Playback is paused
+	StateStopping PlayerState = "stopping" // Stop was requested, waiting for the process to exit
+	StateFailed   PlayerState = "failed"   // Player failed to start or exited with an error
+)
+
+// Allowed transitions between playback states
+var stateTransitions = map[PlayerState][]PlayerState{
+	StateIdle:     {StateStarting},
+	StateStarting: {StatePlaying, StateStopping, StateFailed},
+	StatePlaying:  {StatePaused, StateStopping, StateIdle, StateFailed},
+	StatePaused:   {StatePlaying, StateStopping, StateIdle, StateFailed},
+	StateStopping: {StateIdle},
+	StateFailed:   {StateStarting, StateIdle},
+}
+
+// Returns true if the player has a running process
+func (s PlayerState) Active() bool {
+	return s == StateStarting || s == StatePlaying || s == StatePaused || s == StateStopping
+}
+
+// Returns true if transition to the given state is allowed
+func (s PlayerState) CanTransition(to PlayerState) bool {
+	for _, state := range stateTransitions[s] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the state machine into a new state. Caller must hold the player lock.
+func (s *PlayerState) Transition(to PlayerState) error {
+	if !s.CanTransition(to) {
+		return fmt.Errorf("Invalid player state transition: %s -> %s", *s, to)
+	}
+	*s = to
+	return nil
+}
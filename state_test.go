@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PlayerStateTransition(t *testing.T) {
+	state := StateIdle
+
+	assert.Error(t, state.Transition(StatePlaying))
+	assert.Equal(t, StateIdle, state)
+
+	assert.NoError(t, state.Transition(StateStarting))
+	assert.NoError(t, state.Transition(StatePlaying))
+	assert.NoError(t, state.Transition(StatePaused))
+	assert.NoError(t, state.Transition(StatePlaying))
+	assert.NoError(t, state.Transition(StateStopping))
+	assert.Error(t, state.Transition(StatePlaying))
+	assert.NoError(t, state.Transition(StateIdle))
+
+	assert.NoError(t, state.Transition(StateStarting))
+	assert.NoError(t, state.Transition(StateFailed))
+	assert.NoError(t, state.Transition(StateStarting))
+}
+
+func Test_PlayerStateActive(t *testing.T) {
+	assert.False(t, StateIdle.Active())
+	assert.False(t, StateFailed.Active())
+	assert.True(t, StateStarting.Active())
+	assert.True(t, StatePlaying.Active())
+	assert.True(t, StatePaused.Active())
+	assert.True(t, StateStopping.Active())
+}
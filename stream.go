@@ -7,6 +7,7 @@ import (
 	"log"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 var durationRegexp = regexp.MustCompile(`\s?Duration: ([\d]+:[\d]+:[\d]+)`)
@@ -33,32 +34,76 @@ func (p *Position) String() string {
 	return durationFromSeconds(p.seconds)
 }
 
+// Stream tracks playback progress reported by omxplayer. Fields are updated
+// from the output reader goroutines, so access goes through the lock.
 type Stream struct {
+	sync.Mutex
 	duration uint64
 	pos      Position
+	stderr   *outputTail   // Last lines of STDERR output
+	ready    chan struct{} // Closed when the first position is reported
+	started  bool
 }
 
 func NewStream() *Stream {
 	return &Stream{
 		pos:      Position{},
 		duration: 0,
+		stderr:   &outputTail{},
+		ready:    make(chan struct{}),
 	}
 }
 
+// Ready returns a channel that is closed once playback position is reported
+func (s *Stream) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Last lines of STDERR output
+func (s *Stream) Stderr() []string {
+	return s.stderr.Lines()
+}
+
+// Duration in seconds
+func (s *Stream) Duration() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.duration
+}
+
+// Current position in seconds
+func (s *Stream) Position() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.pos.seconds
+}
+
+// Read player output until both streams are closed
 func (s *Stream) Start(stdout, stderr io.Reader) {
-	s.duration = 0
-	s.pos.Set(0)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
 
 	// Most meta information comes from STDERR
 	go func() {
+		defer wg.Done()
+
 		scanner := bufio.NewScanner(stderr)
+		found := false
 
+		// Keep draining the stream after duration is found so the player does not block
 		for scanner.Scan() {
 			line := scanner.Text()
+			s.stderr.Add(line)
+
+			if found {
+				continue
+			}
 
-			if duration, found := parseDuration(line); found {
+			if duration, ok := parseDuration(line); ok {
+				s.Lock()
 				s.duration = duration
-				break
+				s.Unlock()
+				found = true
 			}
 		}
 	}()
@@ -68,18 +113,21 @@ func (s *Stream) Start(stdout, stderr io.Reader) {
 	for {
 		data, err := progress.ReadBytes('\r')
 		if err != nil {
-			log.Println("ERROR:", err)
+			if err != io.EOF {
+				log.Println("ERROR:", err)
+			}
 			break
 		}
 		s.parsePosition(string(data))
 	}
+
+	wg.Wait()
 }
 
 func parseDuration(line string) (uint64, bool) {
 	// It must match the output format "Duration: hh:mm:ss"
 	matches := durationRegexp.FindAllStringSubmatch(line, 1)
 	if len(matches) == 0 {
-		log.Println("duration matches:", matches)
 		return 0, false
 	}
 
@@ -101,6 +149,12 @@ func (s *Stream) parsePosition(line string) {
 
 	var posNanos uint64
 	if n, _ := fmt.Sscanf(line, "M:%d", &posNanos); n == 1 {
+		s.Lock()
 		s.pos.Set(posNanos)
+		if !s.started {
+			s.started = true
+			close(s.ready)
+		}
+		s.Unlock()
 	}
 }
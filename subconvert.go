@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// WebVTT cue timing, i.e. "00:01.000 --> 00:04.000 align:start"
+	vttTimingRegexp = regexp.MustCompile(`^((?:\d+:)?\d{2}:\d{2}\.\d{3})\s+-->\s+((?:\d+:)?\d{2}:\d{2}\.\d{3})`)
+
+	// Markup not supported by SRT: WebVTT voice and class tags, ASS override blocks
+	vttTagRegexp = regexp.MustCompile(`</?(?:c|v|lang|ruby|rt)(?:[ .][^>]*)?>|<\d{2}:[\d:.]+>`)
+	assTagRegexp = regexp.MustCompile(`\{[^}]*\}`)
+)
+
+// Single subtitle cue
+type subtitleCue struct {
+	start string // Start time in SRT format, i.e. "00:01:02,500"
+	end   string // End time in SRT format
+	text  string
+}
+
+// Format cues as SRT
+func formatSRT(cues []subtitleCue) []byte {
+	out := &strings.Builder{}
+	for i, cue := range cues {
+		fmt.Fprintf(out, "%d\n%s --> %s\n%s\n\n", i+1, cue.start, cue.end, cue.text)
+	}
+	return []byte(out.String())
+}
+
+// Convert WebVTT subtitles to SRT
+func vttToSRT(text string) []byte {
+	cues := []subtitleCue{}
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		m := vttTimingRegexp.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+
+		// Cue text lasts until an empty line
+		body := []string{}
+		for i++; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+			body = append(body, vttTagRegexp.ReplaceAllString(lines[i], ""))
+		}
+
+		cues = append(cues, subtitleCue{
+			start: vttTime(m[1]),
+			end:   vttTime(m[2]),
+			text:  strings.Join(body, "\n"),
+		})
+	}
+
+	return formatSRT(cues)
+}
+
+// Convert "01:02.500" or "00:01:02.500" into "00:01:02,500"
+func vttTime(value string) string {
+	if strings.Count(value, ":") == 1 {
+		value = "00:" + value
+	}
+	if len(value) < 12 {
+		value = "0" + value
+	}
+	return strings.Replace(value, ".", ",", 1)
+}
+
+// Convert Advanced SubStation Alpha (ASS/SSA) subtitles to SRT. Styling and
+// positioning are dropped, events are ordered by start time.
+func assToSRT(text string) []byte {
+	cues := []subtitleCue{}
+	format := []string{}
+	events := false
+
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "[") {
+			events = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !events {
+			continue
+		}
+
+		if strings.HasPrefix(line, "Format:") {
+			format = strings.Split(strings.TrimPrefix(line, "Format:"), ",")
+			for i := range format {
+				format[i] = strings.ToLower(strings.TrimSpace(format[i]))
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "Dialogue:") || len(format) == 0 {
+			continue
+		}
+
+		// Text is the last field and may contain commas
+		fields := strings.SplitN(strings.TrimPrefix(line, "Dialogue:"), ",", len(format))
+		if len(fields) != len(format) {
+			continue
+		}
+
+		cue := subtitleCue{}
+		for i, name := range format {
+			value := strings.TrimSpace(fields[i])
+			switch name {
+			case "start":
+				cue.start = assTime(value)
+			case "end":
+				cue.end = assTime(value)
+			case "text":
+				cue.text = assText(fields[i])
+			}
+		}
+
+		if cue.start != "" && cue.end != "" && cue.text != "" {
+			cues = append(cues, cue)
+		}
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].start < cues[j].start })
+
+	return formatSRT(cues)
+}
+
+// Convert "0:01:02.50" into "00:01:02,500"
+func assTime(value string) string {
+	var hours, minutes, seconds, centis int
+	if _, err := fmt.Sscanf(value, "%d:%d:%d.%d", &hours, &minutes, &seconds, &centis); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", hours, minutes, seconds, centis*10)
+}
+
+// Remove override tags and convert ASS line breaks
+func assText(text string) string {
+	text = assTagRegexp.ReplaceAllString(text, "")
+	text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+	return strings.TrimSpace(text)
+}
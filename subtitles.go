@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SRT cue timing, i.e. "00:01:02,500 --> 00:01:04,000"
+var srtTimingRegexp = regexp.MustCompile(`(?m)^(\d{2}):(\d{2}):(\d{2}),(\d{3}) --> (\d{2}):(\d{2}):(\d{2}),(\d{3})`)
+
+const (
+	// Largest accepted subtitle timing offset
+	subtitleDelayMax = 10 * time.Minute
+
+	// Subtitle timing change of subtitle delay commands
+	subtitleDelayStep = 250 * time.Millisecond
+)
+
+// SubtitleStyle controls subtitle rendering. Zero value uses player defaults,
+// only omxplayer supports these options.
+type SubtitleStyle struct {
+	FontSize int    // Font size in 1/1000 of screen height
+	Align    string // Alignment: left or center
+	Lines    int    // Number of lines in the subtitle buffer
+}
+
+// Check if style options are valid
+func (s *SubtitleStyle) Validate() error {
+	if s.FontSize < 0 || s.FontSize > 1000 {
+		return fmt.Errorf("Invalid font size: %d", s.FontSize)
+	}
+
+	switch s.Align {
+	case "", "left", "center":
+	default:
+		return fmt.Errorf("Invalid subtitle alignment: %s", s.Align)
+	}
+
+	if s.Lines < 0 {
+		return fmt.Errorf("Invalid subtitle lines: %d", s.Lines)
+	}
+
+	return nil
+}
+
+// Subtitle file formats by extension
+var subtitleFormats = map[string]string{
+	".srt": "srt",
+	".ass": "ass",
+	".ssa": "ass",
+	".vtt": "vtt",
+}
+
+// Folders next to the media file that commonly hold subtitles
+var subtitleFolders = []string{"subs", "subtitles"}
+
+// ISO 639-1 codes and English language names mapped to ISO 639-2 codes
+var subtitleLanguages = map[string]string{
+	"ar": "ara", "arabic": "ara",
+	"bg": "bul", "bulgarian": "bul",
+	"cs": "cze", "czech": "cze",
+	"da": "dan", "danish": "dan",
+	"de": "ger", "german": "ger",
+	"el": "gre", "greek": "gre",
+	"en": "eng", "english": "eng",
+	"es": "spa", "spanish": "spa",
+	"fi": "fin", "finnish": "fin",
+	"fr": "fre", "french": "fre",
+	"he": "heb", "hebrew": "heb",
+	"hu": "hun", "hungarian": "hun",
+	"it": "ita", "italian": "ita",
+	"ja": "jpn", "japanese": "jpn",
+	"ko": "kor", "korean": "kor",
+	"nl": "dut", "dutch": "dut",
+	"no": "nor", "norwegian": "nor",
+	"pl": "pol", "polish": "pol",
+	"pt": "por", "portuguese": "por",
+	"ro": "rum", "romanian": "rum",
+	"ru": "rus", "russian": "rus",
+	"sv": "swe", "swedish": "swe",
+	"tr": "tur", "turkish": "tur",
+	"uk": "ukr", "ukrainian": "ukr",
+	"zh": "chi", "chinese": "chi",
+}
+
+// SubtitleFile is an external subtitle file found next to the media file
+type SubtitleFile struct {
+	File     string `json:"file"`               // Path relative to media directory
+	Name     string `json:"name"`               // Filename
+	Language string `json:"language,omitempty"` // ISO 639-2 code, i.e. "eng"
+	Format   string `json:"format"`             // srt, ass or vtt
+}
+
+// Find external subtitles of the media file: files in the same directory named
+// after the media file, i.e. "Movie.en.srt", and any subtitles in "Subs" or
+// "Subtitles" folders, including "Subs/Movie/English.srt".
+func findSubtitles(path string) []SubtitleFile {
+	dir := filepath.Dir(path)
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	result := []SubtitleFile{}
+	add := func(file string, suffix string) {
+		format, ok := subtitleFormats[strings.ToLower(filepath.Ext(file))]
+		if !ok {
+			return
+		}
+
+		rel, err := filepath.Rel(MediaPath, file)
+		if err != nil {
+			rel = file
+		}
+
+		result = append(result, SubtitleFile{
+			File:     rel,
+			Name:     filepath.Base(file),
+			Language: subtitleLanguage(suffix),
+			Format:   format,
+		})
+	}
+
+	for _, name := range readDirNames(dir) {
+		if suffix, ok := sidecarSuffix(strings.TrimSuffix(name, filepath.Ext(name)), base); ok {
+			add(filepath.Join(dir, name), suffix)
+		}
+	}
+
+	for _, folder := range subfolders(dir, subtitleFolders...) {
+		for _, name := range readDirNames(folder) {
+			add(filepath.Join(folder, name), strings.TrimSuffix(name, filepath.Ext(name)))
+		}
+		for _, sub := range subfolders(folder, base) {
+			for _, name := range readDirNames(sub) {
+				add(filepath.Join(sub, name), strings.TrimSuffix(name, filepath.Ext(name)))
+			}
+		}
+	}
+
+	return result
+}
+
+// Get the part of the filename stem that follows the media filename, i.e. ".en"
+// for "Movie.en". Stem must be the media filename alone or followed by ".", "_"
+// or "-", so "Movie 2" does not belong to "Movie".
+func sidecarSuffix(stem, base string) (string, bool) {
+	if len(stem) < len(base) || !strings.EqualFold(stem[:len(base)], base) {
+		return "", false
+	}
+
+	suffix := stem[len(base):]
+	if suffix != "" && !strings.ContainsRune("._-", rune(suffix[0])) {
+		return "", false
+	}
+	return suffix, true
+}
+
+// Sorted names of regular files in the directory
+func readDirNames(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Subdirectories of the directory matching any of the names, case-insensitive
+func subfolders(dir string, names ...string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	result := []string{}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		for _, name := range names {
+			if strings.EqualFold(file.Name(), name) {
+				result = append(result, filepath.Join(dir, file.Name()))
+			}
+		}
+	}
+	return result
+}
+
+// Detect language from the filename suffix, i.e. ".en", ".eng.forced" or "2_English"
+func subtitleLanguage(suffix string) string {
+	words := strings.FieldsFunc(strings.ToLower(suffix), func(r rune) bool {
+		return r == '.' || r == '_' || r == '-' || r == ' ' || r == '[' || r == ']' || r == '(' || r == ')'
+	})
+
+	for _, word := range words {
+		word = strings.TrimLeft(word, "0123456789")
+		if code, ok := subtitleLanguages[word]; ok {
+			return code
+		}
+		if RegexLanguage.MatchString(word) {
+			for _, code := range subtitleLanguages {
+				if code == word {
+					return code
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Resolve subtitles file relative to the media directory
+func subtitlesFile(file string) (string, error) {
+	path := filepath.Join(MediaPath, filepath.Clean("/"+file))
+
+	if !fileExists(path) {
+		return "", errors.New("Subtitles file does not exist")
+	}
+	if _, ok := subtitleFormats[strings.ToLower(filepath.Ext(path))]; !ok {
+		return "", errors.New("Unsupported subtitles format")
+	}
+
+	return path, nil
+}
+
+// Convert subtitles to UTF-8 encoded SRT that every player can load. Converted
+// files are cached in the data directory, UTF-8 SRT files are used as is.
+func prepareSubtitles(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha1.Sum([]byte(fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano())))
+	cached := filepath.Join(DataPath, "subtitles", hex.EncodeToString(hash[:])+".srt")
+	if fileExists(cached) {
+		return cached, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	text := toUTF8(data)
+	switch subtitleFormats[strings.ToLower(filepath.Ext(path))] {
+	case "vtt":
+		text = vttToSRT(string(text))
+	case "ass":
+		text = assToSRT(string(text))
+	default:
+		if bytes.Equal(text, data) {
+			return path, nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(cached, text, 0644); err != nil {
+		return "", err
+	}
+
+	return cached, nil
+}
+
+// Shift all cues of SRT subtitles. Cues moved before the start are clamped to zero.
+func shiftSRT(text string, delay time.Duration) []byte {
+	shift := func(parts []string) string {
+		pos := srtTime(parts) + delay
+		if pos < 0 {
+			pos = 0
+		}
+
+		ms := int64(pos / time.Millisecond)
+		return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+	}
+
+	return []byte(srtTimingRegexp.ReplaceAllStringFunc(text, func(timing string) string {
+		m := srtTimingRegexp.FindStringSubmatch(timing)
+		return shift(m[1:5]) + " --> " + shift(m[5:9])
+	}))
+}
+
+// Write a copy of SRT subtitles shifted by the delay into the cache. Every
+// source has a single shifted copy that is replaced when the delay changes.
+func shiftSubtitles(path string, delay time.Duration) (string, error) {
+	if delay == 0 {
+		return path, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha1.Sum([]byte(path))
+	shifted := filepath.Join(DataPath, "subtitles", hex.EncodeToString(hash[:])+".shifted.srt")
+
+	if err := os.MkdirAll(filepath.Dir(shifted), 0755); err != nil {
+		return "", err
+	}
+
+	// Running player may still read the previous copy, so it is replaced at once
+	tmp := shifted + ".tmp"
+	if err := ioutil.WriteFile(tmp, shiftSRT(string(data), delay), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, shifted); err != nil {
+		return "", err
+	}
+
+	return shifted, nil
+}
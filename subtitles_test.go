@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_toUTF8(t *testing.T) {
+	assert.Equal(t, "Привет", string(toUTF8([]byte("\xEF\xBB\xBFПривет"))))
+	assert.Equal(t, "Привет, мир!", string(toUTF8([]byte("\xCF\xF0\xE8\xE2\xE5\xF2, \xEC\xE8\xF0!"))))
+	assert.Equal(t, "Ça va très bien…", string(toUTF8([]byte("\xC7a va tr\xE8s bien\x85"))))
+	assert.Equal(t, "plain text", string(toUTF8([]byte("plain text"))))
+}
+
+func Test_vttToSRT(t *testing.T) {
+	vtt := "WEBVTT\n\nNOTE comment\n\n1\n00:01.000 --> 00:04.500 align:start\n<v Bob>Hello</v> <i>there</i>\n\n" +
+		"01:02:03.250 --> 01:02:05.000\nSecond\nline\n"
+
+	assert.Equal(t, "1\n00:00:01,000 --> 00:00:04,500\nHello <i>there</i>\n\n"+
+		"2\n01:02:03,250 --> 01:02:05,000\nSecond\nline\n\n", string(vttToSRT(vtt)))
+}
+
+func Test_assToSRT(t *testing.T) {
+	ass := "[Script Info]\r\nTitle: Test\r\n\r\n[Events]\r\n" +
+		"Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\r\n" +
+		"Dialogue: 0,0:00:05.10,0:00:07.00,Default,,0,0,0,,Second, with comma\r\n" +
+		"Comment: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Hidden\r\n" +
+		"Dialogue: 0,0:00:01.00,0:00:02.50,Default,,0,0,0,,{\\an8\\b1}First\\Nline\\hend\r\n"
+
+	assert.Equal(t, "1\n00:00:01,000 --> 00:00:02,500\nFirst\nline end\n\n"+
+		"2\n00:00:05,100 --> 00:00:07,000\nSecond, with comma\n\n", string(assToSRT(ass)))
+}
+
+func Test_findSubtitles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { MediaPath = path }(MediaPath)
+	MediaPath = dir
+
+	os.MkdirAll(filepath.Join(dir, "Movie", "Subs", "Movie"), 0755)
+	for _, name := range []string{
+		"Movie/Movie.mkv",
+		"Movie/Movie.srt",
+		"Movie/movie.en.srt",
+		"Movie/Movie.rus.forced.ass",
+		"Movie/Other.srt",
+		"Movie/Movie.nfo",
+		"Movie/Subs/2_English.srt",
+		"Movie/Subs/Movie/French.vtt",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+	}
+
+	assert.Equal(t, []SubtitleFile{
+		{File: "Movie/Movie.rus.forced.ass", Name: "Movie.rus.forced.ass", Language: "rus", Format: "ass"},
+		{File: "Movie/Movie.srt", Name: "Movie.srt", Format: "srt"},
+		{File: "Movie/movie.en.srt", Name: "movie.en.srt", Language: "eng", Format: "srt"},
+		{File: "Movie/Subs/2_English.srt", Name: "2_English.srt", Language: "eng", Format: "srt"},
+		{File: "Movie/Subs/Movie/French.vtt", Name: "French.vtt", Language: "fre", Format: "vtt"},
+	}, findSubtitles(filepath.Join(dir, "Movie", "Movie.mkv")))
+}
+
+func Test_findSubtitlesSharedPrefix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { MediaPath = path }(MediaPath)
+	MediaPath = dir
+
+	for _, name := range []string{
+		"Movie.mkv",
+		"Movie.en.srt",
+		"Movie_fr.srt",
+		"Movie 2.mkv",
+		"Movie 2.srt",
+		"Movie Extras.en.srt",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+	}
+
+	assert.Equal(t, []SubtitleFile{
+		{File: "Movie.en.srt", Name: "Movie.en.srt", Language: "eng", Format: "srt"},
+		{File: "Movie_fr.srt", Name: "Movie_fr.srt", Language: "fre", Format: "srt"},
+	}, findSubtitles(filepath.Join(dir, "Movie.mkv")))
+
+	assert.Equal(t, []SubtitleFile{
+		{File: "Movie 2.srt", Name: "Movie 2.srt", Format: "srt"},
+	}, findSubtitles(filepath.Join(dir, "Movie 2.mkv")))
+}
+
+func Test_prepareSubtitles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { DataPath = path }(DataPath)
+	DataPath = filepath.Join(dir, ".omxremote")
+
+	// UTF-8 SRT is used as is
+	srt := filepath.Join(dir, "movie.srt")
+	ioutil.WriteFile(srt, []byte("1\n00:00:01,000 --> 00:00:02,000\nПривет\n"), 0644)
+	path, err := prepareSubtitles(srt)
+	assert.NoError(t, err)
+	assert.Equal(t, srt, path)
+
+	// Legacy encodings are converted into the cache
+	legacy := filepath.Join(dir, "movie.ru.srt")
+	ioutil.WriteFile(legacy, []byte("1\n00:00:01,000 --> 00:00:02,000\n\xCF\xF0\xE8\xE2\xE5\xF2\n"), 0644)
+	path, err = prepareSubtitles(legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(DataPath, "subtitles"), filepath.Dir(path))
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "1\n00:00:01,000 --> 00:00:02,000\nПривет\n", string(data))
+
+	again, err := prepareSubtitles(legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, path, again)
+
+	vtt := filepath.Join(dir, "movie.vtt")
+	ioutil.WriteFile(vtt, []byte("WEBVTT\n\n00:01.000 --> 00:02.000\nHello\n"), 0644)
+	path, err = prepareSubtitles(vtt)
+	assert.NoError(t, err)
+	data, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "1\n00:00:01,000 --> 00:00:02,000\nHello\n\n", string(data))
+}
+
+func Test_shiftSRT(t *testing.T) {
+	srt := "1\n00:00:01,000 --> 00:00:02,500\nFirst\n\n2\n00:59:59,900 --> 01:00:00,100\nSecond\n"
+
+	assert.Equal(t, "1\n00:00:01,250 --> 00:00:02,750\nFirst\n\n2\n01:00:00,150 --> 01:00:00,350\nSecond\n",
+		string(shiftSRT(srt, 250*time.Millisecond)))
+	assert.Equal(t, "1\n00:00:00,000 --> 00:00:00,500\nFirst\n\n2\n00:59:57,900 --> 00:59:58,100\nSecond\n",
+		string(shiftSRT(srt, -2*time.Second)))
+}
+
+func Test_shiftSubtitles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "omxremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { DataPath = path }(DataPath)
+	DataPath = filepath.Join(dir, ".omxremote")
+
+	srt := filepath.Join(dir, "movie.srt")
+	ioutil.WriteFile(srt, []byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n"), 0644)
+
+	path, err := shiftSubtitles(srt, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, srt, path)
+
+	// Changing the delay replaces the shifted copy
+	first, err := shiftSubtitles(srt, 250*time.Millisecond)
+	assert.NoError(t, err)
+	path, err = shiftSubtitles(srt, 500*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, first, path)
+
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "1\n00:00:01,500 --> 00:00:02,500\nHello\n", string(data))
+
+	files, _ := ioutil.ReadDir(filepath.Join(DataPath, "subtitles"))
+	assert.Len(t, files, 1)
+}
+
+func Test_SubtitleStyleValidate(t *testing.T) {
+	assert.NoError(t, (&SubtitleStyle{}).Validate())
+	assert.NoError(t, (&SubtitleStyle{FontSize: 55, Align: "center", Lines: 3}).Validate())
+	assert.EqualError(t, (&SubtitleStyle{FontSize: -1}).Validate(), "Invalid font size: -1")
+	assert.EqualError(t, (&SubtitleStyle{Align: "right"}).Validate(), "Invalid subtitle alignment: right")
+	assert.EqualError(t, (&SubtitleStyle{Lines: -2}).Validate(), "Invalid subtitle lines: -2")
+}
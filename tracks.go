@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// ISO 639-2 language code used by media containers, i.e. "eng"
+var RegexLanguage = regexp.MustCompile(`^[a-z]{3}$`)
+
+// Validate track options
+func (o *TrackOptions) Validate() error {
+	if o.AudioLang != "" && !RegexLanguage.MatchString(o.AudioLang) {
+		return fmt.Errorf("Invalid audio language: %s", o.AudioLang)
+	}
+	if o.SubtitleLang != "" && !RegexLanguage.MatchString(o.SubtitleLang) {
+		return fmt.Errorf("Invalid subtitle language: %s", o.SubtitleLang)
+	}
+	if o.Audio != nil && *o.Audio < 0 {
+		return fmt.Errorf("Invalid audio track: %d", *o.Audio)
+	}
+	if o.Subtitle != nil && *o.Subtitle < 0 {
+		return fmt.Errorf("Invalid subtitle track: %d", *o.Subtitle)
+	}
+	return nil
+}
+
+// Override options with request parameters: alang, slang, audio_track, subtitle_track
+func (o TrackOptions) Merge(param func(string) string) (TrackOptions, error) {
+	var err error
+
+	parseIndex := func(name string, dest **int) {
+		value := param(name)
+		if value == "" || err != nil {
+			return
+		}
+		index, parseErr := strconv.Atoi(value)
+		if parseErr != nil {
+			err = fmt.Errorf("Invalid %s: %s", name, value)
+			return
+		}
+		*dest = &index
+	}
+
+	parseIndex("audio_track", &o.Audio)
+	parseIndex("subtitle_track", &o.Subtitle)
+	if err != nil {
+		return o, err
+	}
+
+	if value := param("alang"); value != "" {
+		o.AudioLang = value
+	}
+	if value := param("slang"); value != "" {
+		o.SubtitleLang = value
+	}
+
+	return o, o.Validate()
+}
+
+// TrackSelection remembers tracks chosen for the current playback, so the
+// selected tracks can be reported without asking the player
+type TrackSelection struct {
+	sync.Mutex
+	file string
+	opts TrackOptions
+}
+
+// Reset selection for a new playback
+func (s *TrackSelection) Start(file string, opts TrackOptions) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.file = file
+	s.opts = opts
+}
+
+// Record audio track selected during playback
+func (s *TrackSelection) SelectAudio(file string, index int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.file == file {
+		s.opts.Audio = &index
+	}
+}
+
+// Record subtitle track selected during playback, -1 if disabled
+func (s *TrackSelection) SelectSubtitle(file string, index int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.file == file {
+		s.opts.Subtitle = &index
+	}
+}
+
+// Get selected audio and subtitle track indexes. Without explicit selection
+// the track is picked by language, then by the default flag. Subtitle index
+// is -1 if subtitles are disabled.
+func (s *TrackSelection) Current(file string, info *FileInfo) (int, int) {
+	s.Lock()
+	opts := TrackOptions{}
+	if s.file == file {
+		opts = s.opts
+	}
+	s.Unlock()
+
+	audio := -1
+	if len(info.Audio) > 0 {
+		audio = 0
+	}
+	streams := make([]StreamInfo, len(info.Audio))
+	for i, stream := range info.Audio {
+		streams[i] = stream.StreamInfo
+	}
+	if index, ok := pickTrack(streams, opts.Audio, opts.AudioLang); ok {
+		audio = index
+	}
+
+	subtitle := -1
+	streams = make([]StreamInfo, len(info.Subtitles))
+	for i, stream := range info.Subtitles {
+		streams[i] = stream.StreamInfo
+	}
+	if index, ok := pickTrack(streams, opts.Subtitle, opts.SubtitleLang); ok {
+		subtitle = index
+	}
+
+	return audio, subtitle
+}
+
+// Pick track by explicit index, language or default flag
+func pickTrack(streams []StreamInfo, index *int, language string) (int, bool) {
+	if index != nil {
+		return *index, true
+	}
+
+	if language != "" {
+		for i, stream := range streams {
+			if stream.Language == language {
+				return i, true
+			}
+		}
+	}
+
+	for i, stream := range streams {
+		if stream.Default {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TrackOptionsMerge(t *testing.T) {
+	params := map[string]string{"alang": "jpn", "slang": "eng", "audio_track": "1"}
+	opts, err := TrackOptions{SubtitleLang: "rus"}.Merge(func(name string) string { return params[name] })
+	assert.NoError(t, err)
+	assert.Equal(t, "jpn", opts.AudioLang)
+	assert.Equal(t, "eng", opts.SubtitleLang)
+	assert.Equal(t, 1, *opts.Audio)
+	assert.Nil(t, opts.Subtitle)
+
+	examples := map[string]string{
+		"alang":          "Invalid audio language: english",
+		"slang":          "Invalid subtitle language: english",
+		"audio_track":    "Invalid audio_track: english",
+		"subtitle_track": "Invalid subtitle_track: english",
+	}
+	for name, message := range examples {
+		_, err := TrackOptions{}.Merge(func(param string) string {
+			if param == name {
+				return "english"
+			}
+			return ""
+		})
+		assert.EqualError(t, err, message)
+	}
+
+	_, err = TrackOptions{}.Merge(func(name string) string {
+		if name == "subtitle_track" {
+			return "-1"
+		}
+		return ""
+	})
+	assert.EqualError(t, err, "Invalid subtitle track: -1")
+}
+
+func Test_TrackSelection(t *testing.T) {
+	info := &FileInfo{
+		Audio: []AudioStream{
+			{StreamInfo: StreamInfo{Index: 0, Language: "eng"}},
+			{StreamInfo: StreamInfo{Index: 1, Language: "jpn", Default: true}},
+		},
+		Subtitles: []SubtitleStream{
+			{StreamInfo{Index: 0, Language: "eng"}},
+			{StreamInfo{Index: 1, Language: "rus"}},
+		},
+	}
+
+	s := &TrackSelection{}
+
+	// Default flag is used without preferences, subtitles are disabled
+	s.Start("movie.mkv", TrackOptions{})
+	audio, subtitle := s.Current("movie.mkv", info)
+	assert.Equal(t, 1, audio)
+	assert.Equal(t, -1, subtitle)
+
+	s.Start("movie.mkv", TrackOptions{AudioLang: "eng", SubtitleLang: "rus"})
+	audio, subtitle = s.Current("movie.mkv", info)
+	assert.Equal(t, 0, audio)
+	assert.Equal(t, 1, subtitle)
+
+	// Explicit selection wins
+	s.SelectAudio("movie.mkv", 1)
+	s.SelectSubtitle("movie.mkv", -1)
+	s.SelectSubtitle("other.mkv", 0)
+	audio, subtitle = s.Current("movie.mkv", info)
+	assert.Equal(t, 1, audio)
+	assert.Equal(t, -1, subtitle)
+
+	// Selection of other files is not used
+	audio, subtitle = s.Current("other.mkv", info)
+	assert.Equal(t, 1, audio)
+	assert.Equal(t, -1, subtitle)
+
+	audio, subtitle = s.Current("movie.mkv", &FileInfo{})
+	assert.Equal(t, 1, audio)
+	assert.Equal(t, -1, subtitle)
+}
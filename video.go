@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VideoOptions control video output layout. Zero value uses player defaults.
+type VideoOptions struct {
+	Display     int    // Display number, i.e. 2 and 7 for HDMI ports on Pi 4
+	AspectMode  string // Aspect mode: letterbox, fill or stretch
+	Window      string // Video window geometry as "x1,y1,x2,y2"
+	Orientation int    // Video rotation: 0, 90, 180 or 270
+	Layer       int    // Dispmanx layer of the video
+	NoRefresh   bool   // Do not adjust display refresh rate to the video
+}
+
+var videoAspectModes = map[string]bool{
+	"letterbox": true,
+	"fill":      true,
+	"stretch":   true,
+}
+
+// Check if options are valid and normalize window geometry
+func (o *VideoOptions) Validate() error {
+	if o.Display < 0 || o.Display > 7 {
+		return fmt.Errorf("Invalid display: %d", o.Display)
+	}
+
+	if o.AspectMode != "" && !videoAspectModes[o.AspectMode] {
+		return fmt.Errorf("Invalid aspect mode: %s", o.AspectMode)
+	}
+
+	switch o.Orientation {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("Invalid orientation: %d", o.Orientation)
+	}
+
+	if o.Window != "" {
+		window, err := parseVideoWindow(o.Window)
+		if err != nil {
+			return err
+		}
+		o.Window = window
+	}
+
+	return nil
+}
+
+// Apply request parameters on top of the options. Parameters are named
+// display, aspect, win, orientation, layer and refresh.
+func (o VideoOptions) Merge(param func(string) string) (VideoOptions, error) {
+	var err error
+
+	parseInt := func(name string, dest *int) {
+		value := param(name)
+		if value == "" || err != nil {
+			return
+		}
+		if *dest, err = strconv.Atoi(value); err != nil {
+			err = fmt.Errorf("Invalid %s: %s", name, value)
+		}
+	}
+
+	parseInt("display", &o.Display)
+	parseInt("orientation", &o.Orientation)
+	parseInt("layer", &o.Layer)
+	if err != nil {
+		return o, err
+	}
+
+	if value := param("aspect"); value != "" {
+		o.AspectMode = value
+	}
+
+	if value := param("win"); value != "" {
+		o.Window = value
+	}
+
+	if value := param("refresh"); value != "" {
+		refresh, err := strconv.ParseBool(value)
+		if err != nil {
+			return o, fmt.Errorf("Invalid refresh: %s", value)
+		}
+		o.NoRefresh = !refresh
+	}
+
+	return o, o.Validate()
+}
+
+// Parse window geometry in "x1 y1 x2 y2" or "x1,y1,x2,y2" format
+func parseVideoWindow(value string) (string, error) {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(fields) != 4 {
+		return "", fmt.Errorf("Invalid window: %s", value)
+	}
+
+	coords := make([]int, 4)
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("Invalid window: %s", value)
+		}
+		coords[i] = n
+	}
+
+	if coords[2] <= coords[0] || coords[3] <= coords[1] {
+		return "", fmt.Errorf("Invalid window: %s", value)
+	}
+
+	return strings.Join(fields, ","), nil
+}
+
+// Get window position and size from normalized geometry
+func videoWindowRect(window string) (x, y, width, height int) {
+	var x2, y2 int
+	fmt.Sscanf(window, "%d,%d,%d,%d", &x, &y, &x2, &y2)
+	return x, y, x2 - x, y2 - y
+}
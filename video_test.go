@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseVideoWindow(t *testing.T) {
+	window, err := parseVideoWindow("0 0 1280 720")
+	assert.NoError(t, err)
+	assert.Equal(t, "0,0,1280,720", window)
+
+	window, err = parseVideoWindow("100,50,740,530")
+	assert.NoError(t, err)
+	assert.Equal(t, "100,50,740,530", window)
+
+	for _, value := range []string{"", "0 0 1280", "a b c d", "0 0 -1 720", "100 100 50 200"} {
+		_, err := parseVideoWindow(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func Test_VideoOptionsMerge(t *testing.T) {
+	defaults := VideoOptions{Display: 2, AspectMode: "letterbox"}
+
+	video, err := defaults.Merge(url.Values{}.Get)
+	assert.NoError(t, err)
+	assert.Equal(t, defaults, video)
+
+	params := url.Values{
+		"display":     {"7"},
+		"aspect":      {"stretch"},
+		"win":         {"0 0 1920 1080"},
+		"orientation": {"180"},
+		"layer":       {"-1"},
+		"refresh":     {"false"},
+	}
+	video, err = defaults.Merge(params.Get)
+	assert.NoError(t, err)
+	assert.Equal(t, VideoOptions{
+		Display:     7,
+		AspectMode:  "stretch",
+		Window:      "0,0,1920,1080",
+		Orientation: 180,
+		Layer:       -1,
+		NoRefresh:   true,
+	}, video)
+
+	examples := map[string]string{
+		"display=9":        "Invalid display: 9",
+		"display=hdmi":     "Invalid display: hdmi",
+		"aspect=zoom":      "Invalid aspect mode: zoom",
+		"orientation=45":   "Invalid orientation: 45",
+		"win=0,0,10":       "Invalid window: 0,0,10",
+		"refresh=sometime": "Invalid refresh: sometime",
+	}
+	for query, message := range examples {
+		params, _ := url.ParseQuery(query)
+		_, err := defaults.Merge(params.Get)
+		assert.EqualError(t, err, message)
+	}
+}
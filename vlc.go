@@ -0,0 +1,914 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// How long to wait for RC interface response
+	vlcCallTimeout = 2 * time.Second
+
+	// How often to poll playback status
+	vlcPollInterval = 500 * time.Millisecond
+
+	// Subtitle delay change of a single hotkey press
+	vlcSubtitleDelayStep = 50 * time.Millisecond
+
+	errVlcClosed = errors.New("VLC RC connection is closed")
+
+	// Track list entry, i.e. "| 2 - Track 2 - [Japanese] *"
+	vlcTrackRegexp = regexp.MustCompile(`^\|\s*(-?\d+)\s+-\s+(.+?)(\s+\*)?$`)
+)
+
+// Mapping of remote commands to VLC RC commands. Seeking is handled separately
+// since RC interface only supports absolute positions.
+var vlcCommands = map[string]string{
+	"pause":            "pause",
+	"volume_up":        "volup 1",
+	"volume_down":      "voldown 1",
+	"speed_down":       "slower",
+	"speed_up":         "faster",
+	"chapter_previous": "chapter_p",
+	"chapter_next":     "chapter_n",
+}
+
+// Relative seek offsets in seconds
+var vlcSeekCommands = map[string]int{
+	"seek_back":         -30,
+	"seek_back_fast":    -600,
+	"seek_forward":      30,
+	"seek_forward_fast": 600,
+}
+
+// VlcPlayer plays media with cvlc, controlled over the RC interface socket.
+// Same as OmxPlayer, all playback state is guarded by the lock.
+type VlcPlayer struct {
+	sync.Mutex
+
+	path   string           // Path to cvlc executable
+	socket string           // Path to RC interface socket
+	state  PlayerState      // Current playback state
+	proc   *vlcProcess      // Running VLC process
+	file   string           // Currently playing media file
+	err    error            // Last playback error
+	events chan PlayerEvent // Playback lifecycle events
+}
+
+// A single VLC process execution
+type vlcProcess struct {
+	cmd      *exec.Cmd     // Child process
+	conn     *vlcConn      // RC connection, nil until connected
+	stderr   *outputTail   // Last lines of error output
+	done     chan struct{} // Closed when child process exits
+	ready    chan struct{} // Closed when playback is first reported
+	err      error         // Exit error, only valid after done is closed
+	started  bool          // Set when playback is first reported
+	position uint64        // Current position in seconds
+	duration uint64        // Media duration in seconds
+	volume   float64       // Volume in decibels
+	muted    bool          // Set when audio is muted
+	subDelay time.Duration // Subtitle delay
+}
+
+// Audio or subtitle track reported by the player
+type Track struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+func NewVlcPlayer(path string) *VlcPlayer {
+	return &VlcPlayer{
+		path:   path,
+		socket: filepath.Join(os.TempDir(), fmt.Sprintf("omxremote-vlc-%d.sock", os.Getpid())),
+		state:  StateIdle,
+		events: make(chan PlayerEvent, 10),
+	}
+}
+
+// Start VLC playback for a given file. Blocks until the player reports
+// playback, exits or the startup window expires.
+func (p *VlcPlayer) Play(file string, opts PlayOptions) error {
+	p.Lock()
+
+	if p.state.Active() {
+		p.Unlock()
+		return ErrPlayerActive
+	}
+	if err := p.state.Transition(StateStarting); err != nil {
+		p.Unlock()
+		return err
+	}
+
+	p.file = file
+	p.err = nil
+
+	// Remove stale socket left by a crashed process
+	os.Remove(p.socket)
+
+	cmd := exec.Command(p.path, vlcArgs(p.socket, file, opts)...)
+
+	proc := &vlcProcess{
+		cmd:    cmd,
+		stderr: &outputTail{},
+		done:   make(chan struct{}),
+		ready:  make(chan struct{}),
+		volume: opts.Volume,
+
+		// Startup delay is rounded to tenths of a second, see vlcArgs
+		subDelay: opts.SubtitleDelay.Round(100 * time.Millisecond),
+	}
+	cmd.Stderr = proc.stderr
+
+	if err := cmd.Start(); err != nil {
+		p.err = err
+		p.state.Transition(StateFailed)
+		p.Unlock()
+
+		sendEvent(p.events, PlayerEvent{Type: EventFailed, File: file, Error: err})
+		return err
+	}
+
+	p.proc = proc
+	go p.wait(proc)
+	p.Unlock()
+
+	timer := time.NewTimer(startupTimeout)
+	defer timer.Stop()
+
+	if err := p.connect(proc, timer.C); err != nil {
+		select {
+		case <-proc.done:
+			return proc.err
+		default:
+		}
+
+		log.Println("VLC RC connection failed:", err)
+		p.Stop()
+		return err
+	}
+
+	// RC interface has no startup volume option, apply it once connected
+	if opts.Volume != 0 {
+		if err := proc.conn.Exec(vlcVolumeCommand(opts.Volume)); err != nil {
+			log.Println("Cant set VLC volume:", err)
+		}
+	}
+
+	select {
+	case <-proc.ready:
+	case <-timer.C:
+		log.Println("VLC did not report playback in", startupTimeout)
+	case <-proc.done:
+		// Process exited during startup, nil error means it was stopped
+		return proc.err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	// Playback could be stopped while waiting
+	if p.proc != proc || p.state != StateStarting {
+		return nil
+	}
+
+	p.state.Transition(StatePlaying)
+	sendEvent(p.events, PlayerEvent{Type: EventStarted, File: file})
+
+	return nil
+}
+
+// Build cvlc command line arguments
+func vlcArgs(socket string, file string, opts PlayOptions) []string {
+	args := []string{
+		"--intf", "rc", // remote control interface
+		"--rc-unix", socket, // listen for commands on a unix socket
+		"--rc-fake-tty",   // do not require a terminal for RC interface
+		"--play-and-exit", // exit when playback is finished
+		"--fullscreen",    // use the whole screen
+		"--verbose", "0",  // only print errors
+	}
+
+	if opts.Position > 0 {
+		args = append(args, fmt.Sprintf("--start-time=%d", int(opts.Position.Seconds())))
+	}
+
+	if device := alsaDeviceName(opts.AudioDevice); device != "" {
+		args = append(args, "--aout=alsa", "--alsa-audio-device="+device)
+	}
+
+	tracks := opts.Tracks
+	if tracks.AudioLang != "" {
+		args = append(args, "--audio-language="+tracks.AudioLang)
+	}
+	if tracks.SubtitleLang != "" {
+		args = append(args, "--sub-language="+tracks.SubtitleLang)
+	}
+	if tracks.Audio != nil {
+		args = append(args, fmt.Sprintf("--audio-track=%d", *tracks.Audio))
+	}
+	if tracks.Subtitle != nil && *tracks.Subtitle >= 0 {
+		args = append(args, fmt.Sprintf("--sub-track=%d", *tracks.Subtitle))
+	}
+	if opts.Subtitles != "" {
+		args = append(args, "--sub-file="+opts.Subtitles)
+	}
+	if opts.SubtitleDelay != 0 {
+		// Delay is set in tenths of a second
+		args = append(args, fmt.Sprintf("--sub-delay=%d", int(math.Round(opts.SubtitleDelay.Seconds()*10))))
+	}
+
+	return append(args, file)
+}
+
+// Connect to RC socket once VLC creates it and start status polling
+func (p *VlcPlayer) connect(proc *vlcProcess, timeout <-chan time.Time) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		conn, err := vlcDial(p.socket)
+		if err == nil {
+			p.Lock()
+			proc.conn = conn
+			p.Unlock()
+
+			go p.poll(proc)
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-proc.done:
+			return errVlcClosed
+		case <-timeout:
+			return fmt.Errorf("Unable to connect to VLC: %v", err)
+		}
+	}
+}
+
+// Poll playback status until the process exits
+func (p *VlcPlayer) poll(proc *vlcProcess) {
+	ticker := time.NewTicker(vlcPollInterval)
+	defer ticker.Stop()
+
+	for {
+		position, errPos := proc.conn.Int("get_time")
+		duration, errLen := proc.conn.Int("get_length")
+		playing, errPlay := proc.conn.Int("is_playing")
+
+		if errPos == nil && errLen == nil && errPlay == nil {
+			p.update(proc, position, duration, playing == 1)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-proc.done:
+			return
+		}
+	}
+}
+
+// Update playback state from polled status
+func (p *VlcPlayer) update(proc *vlcProcess, position, duration int, playing bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if position >= 0 {
+		proc.position = uint64(position)
+	}
+	if duration >= 0 {
+		proc.duration = uint64(duration)
+	}
+
+	if !proc.started {
+		if !playing {
+			return
+		}
+		proc.started = true
+		close(proc.ready)
+	}
+
+	if p.proc != proc {
+		return
+	}
+
+	// VLC does not report pause state directly, stopped input is treated as paused
+	if !playing && p.state == StatePlaying {
+		p.state.Transition(StatePaused)
+	} else if playing && p.state == StatePaused {
+		p.state.Transition(StatePlaying)
+	}
+}
+
+// Wait until child process is finished and notify listeners
+func (p *VlcPlayer) wait(proc *vlcProcess) {
+	defer close(proc.done)
+
+	err := proc.cmd.Wait()
+	if err != nil {
+		log.Println("Process exited with error:", err)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	event := PlayerEvent{Type: EventFinished, File: p.file}
+
+	// Player exiting before it started playing is always a failure
+	if err == nil && p.state == StateStarting {
+		err = errors.New("Player exited before playback started")
+	}
+
+	switch {
+	case p.state == StateStopping:
+		event.Type = EventStopped
+		event.Position, event.Duration = proc.position, proc.duration
+		p.state.Transition(StateIdle)
+	case err != nil:
+		proc.err = newPlayError(err, proc.stderr.Lines())
+		event.Type = EventFailed
+		event.Error = proc.err
+		p.err = proc.err
+		p.state.Transition(StateFailed)
+	default:
+		p.state.Transition(StateIdle)
+	}
+
+	if proc.conn != nil {
+		proc.conn.Close()
+	}
+	os.Remove(p.socket)
+
+	p.proc = nil
+	p.file = ""
+
+	sendEvent(p.events, event)
+}
+
+// Send a command to VLC
+func (p *VlcPlayer) Command(name string) error {
+	if _, ok := Commands[name]; !ok {
+		return ErrInvalidCommand
+	}
+
+	switch name {
+	case "stop":
+		return p.Stop()
+	case "subtitles":
+		return p.toggleSubtitles()
+	case "audio_previous":
+		return p.cycleTrack("atrack", -1)
+	case "audio_next":
+		return p.cycleTrack("atrack", 1)
+	case "subtitle_previous":
+		return p.cycleTrack("strack", -1)
+	case "subtitle_next":
+		return p.cycleTrack("strack", 1)
+	case "subtitle_delay_down":
+		return p.stepSubtitleDelay(-subtitleDelayStep)
+	case "subtitle_delay_up":
+		return p.stepSubtitleDelay(subtitleDelayStep)
+	}
+
+	if offset, ok := vlcSeekCommands[name]; ok {
+		return p.Seek(time.Duration(offset) * time.Second)
+	}
+
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	command, ok := vlcCommands[name]
+	if !ok {
+		return ErrNotSupported
+	}
+
+	if err := conn.Exec(command); err != nil {
+		return err
+	}
+
+	// Update state right away instead of waiting for the next poll
+	if name == "pause" {
+		p.Lock()
+		if p.state == StatePlaying {
+			p.state.Transition(StatePaused)
+		} else if p.state == StatePaused {
+			p.state.Transition(StatePlaying)
+		}
+		p.Unlock()
+	}
+
+	return nil
+}
+
+// Get RC connection of the running player
+func (p *VlcPlayer) conn() (*vlcConn, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.state != StatePlaying && p.state != StatePaused {
+		return nil, ErrPlayerInactive
+	}
+	if p.proc.conn == nil {
+		return nil, errVlcClosed
+	}
+	return p.proc.conn, nil
+}
+
+// Seek relative to the current position
+func (p *VlcPlayer) Seek(offset time.Duration) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	position, err := conn.Int("get_time")
+	if err != nil {
+		return err
+	}
+
+	target := position + int(offset.Seconds())
+	if target < 0 {
+		target = 0
+	}
+
+	return p.SetPosition(time.Duration(target) * time.Second)
+}
+
+// Seek to absolute position
+func (p *VlcPlayer) SetPosition(pos time.Duration) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	if err := conn.Exec(fmt.Sprintf("seek %d", int(pos.Seconds()))); err != nil {
+		return err
+	}
+
+	p.Lock()
+	if p.proc != nil {
+		p.proc.position = uint64(pos.Seconds())
+	}
+	p.Unlock()
+
+	return nil
+}
+
+// Set volume in decibels. While muted the volume is applied on unmute.
+func (p *VlcPlayer) SetVolume(db float64) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	proc := p.proc
+	proc.volume = db
+	muted := proc.muted
+	p.Unlock()
+
+	if muted {
+		return nil
+	}
+	return conn.Exec(vlcVolumeCommand(db))
+}
+
+// Mute or unmute audio. RC interface has no mute command, so volume is set to zero.
+func (p *VlcPlayer) SetMuted(muted bool) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	proc := p.proc
+	proc.muted = muted
+	db := proc.volume
+	p.Unlock()
+
+	if muted {
+		return conn.Exec("volume 0")
+	}
+	return conn.Exec(vlcVolumeCommand(db))
+}
+
+// RC volume is linear where 256 is 100%
+func vlcVolumeCommand(db float64) string {
+	return fmt.Sprintf("volume %d", int(math.Round(256*volumePercent(db)/100)))
+}
+
+// List audio tracks
+func (p *VlcPlayer) AudioTracks() ([]Track, error) {
+	return p.tracks("atrack")
+}
+
+// List subtitle tracks
+func (p *VlcPlayer) SubtitleTracks() ([]Track, error) {
+	return p.tracks("strack")
+}
+
+// Select audio track by index among audio tracks
+func (p *VlcPlayer) SelectAudio(index int) error {
+	return p.selectTrackIndex("atrack", index)
+}
+
+// Select subtitle track by index among subtitle tracks, -1 disables subtitles
+func (p *VlcPlayer) SelectSubtitle(index int) error {
+	if index < 0 {
+		return p.selectTrack("strack", -1)
+	}
+	return p.selectTrackIndex("strack", index)
+}
+
+// Change subtitle delay with hotkeys, RC interface has no delay command
+func (p *VlcPlayer) SetSubtitleDelay(delay time.Duration) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	proc := p.proc
+	current := proc.subDelay
+	p.Unlock()
+
+	key, step := "key key-subdelay-up", vlcSubtitleDelayStep
+	steps := int(math.Round(float64(delay-current) / float64(vlcSubtitleDelayStep)))
+	if steps < 0 {
+		key, step = "key key-subdelay-down", -vlcSubtitleDelayStep
+		steps = -steps
+	}
+
+	for i := 0; i < steps; i++ {
+		if err := conn.Exec(key); err != nil {
+			return err
+		}
+
+		p.Lock()
+		proc.subDelay += step
+		p.Unlock()
+	}
+
+	return nil
+}
+
+// Change subtitle delay relative to the current one
+func (p *VlcPlayer) stepSubtitleDelay(offset time.Duration) error {
+	p.Lock()
+	if p.proc == nil {
+		p.Unlock()
+		return ErrPlayerInactive
+	}
+	delay := p.proc.subDelay + offset
+	p.Unlock()
+
+	return p.SetSubtitleDelay(delay)
+}
+
+// Select track by its position in the list. VLC identifies tracks by
+// elementary stream IDs, the "Disable" entry is not counted.
+func (p *VlcPlayer) selectTrackIndex(command string, index int) error {
+	tracks, err := p.tracks(command)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	for _, track := range tracks {
+		if track.Index == -1 {
+			continue
+		}
+		if n == index {
+			return p.selectTrack(command, track.Index)
+		}
+		n++
+	}
+
+	return fmt.Errorf("Track %d is not available", index)
+}
+
+func (p *VlcPlayer) tracks(command string) ([]Track, error) {
+	conn, err := p.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := conn.List(command)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseVlcTracks(lines), nil
+}
+
+func (p *VlcPlayer) selectTrack(command string, index int) error {
+	tracks, err := p.tracks(command)
+	if err != nil {
+		return err
+	}
+
+	for _, track := range tracks {
+		if track.Index == index {
+			conn, err := p.conn()
+			if err != nil {
+				return err
+			}
+			return conn.Exec(fmt.Sprintf("%s %d", command, index))
+		}
+	}
+
+	return fmt.Errorf("Track %d is not available", index)
+}
+
+// Switch to the next or previous track. Subtitles cycle through the "Disable"
+// entry, audio is never disabled.
+func (p *VlcPlayer) cycleTrack(command string, offset int) error {
+	tracks, err := p.tracks(command)
+	if err != nil {
+		return err
+	}
+
+	list := []Track{}
+	current := 0
+	for _, track := range tracks {
+		if track.Index == -1 && command == "atrack" {
+			continue
+		}
+		if track.Active {
+			current = len(list)
+		}
+		list = append(list, track)
+	}
+	if len(list) == 0 {
+		return errors.New("No tracks available")
+	}
+
+	next := list[(current+offset+len(list))%len(list)]
+	return p.selectTrack(command, next.Index)
+}
+
+// Disable subtitles if enabled, otherwise enable the first subtitle track
+func (p *VlcPlayer) toggleSubtitles() error {
+	tracks, err := p.SubtitleTracks()
+	if err != nil {
+		return err
+	}
+
+	for _, track := range tracks {
+		if track.Active && track.Index != -1 {
+			return p.selectTrack("strack", -1)
+		}
+	}
+
+	for _, track := range tracks {
+		if track.Index != -1 {
+			return p.selectTrack("strack", track.Index)
+		}
+	}
+
+	return nil
+}
+
+// Stop playback and wait until the player process exits
+func (p *VlcPlayer) Stop() error {
+	p.Lock()
+
+	if !p.state.Active() {
+		p.Unlock()
+		return ErrPlayerInactive
+	}
+
+	proc := p.proc
+
+	if p.state != StateStopping {
+		p.state.Transition(StateStopping)
+
+		if proc.conn == nil {
+			proc.cmd.Process.Kill()
+		} else {
+			go proc.conn.Exec("quit")
+
+			// Kill the process if it does not quit on its own
+			go func() {
+				select {
+				case <-proc.done:
+				case <-time.After(vlcCallTimeout):
+					proc.cmd.Process.Kill()
+				}
+			}()
+		}
+	}
+
+	p.Unlock()
+
+	<-proc.done
+	return nil
+}
+
+func (p *VlcPlayer) Status() PlayerStatus {
+	p.Lock()
+	defer p.Unlock()
+
+	status := PlayerStatus{
+		Running: p.state.Active(),
+		State:   p.state,
+		File:    p.file,
+	}
+
+	if p.err != nil {
+		status.Error = p.err.Error()
+	}
+
+	if p.proc != nil {
+		status.Position = p.proc.position
+		status.Duration = p.proc.duration
+	}
+
+	return status
+}
+
+func (p *VlcPlayer) Events() <-chan PlayerEvent {
+	return p.events
+}
+
+// Parse track list printed by "atrack" and "strack" commands
+func parseVlcTracks(lines []string) []Track {
+	tracks := []Track{}
+
+	for _, line := range lines {
+		matches := vlcTrackRegexp.FindStringSubmatch(line)
+		if len(matches) == 0 {
+			continue
+		}
+
+		index, _ := strconv.Atoi(matches[1])
+		tracks = append(tracks, Track{
+			Index:  index,
+			Name:   matches[2],
+			Active: matches[3] != "",
+		})
+	}
+
+	return tracks
+}
+
+// vlcConn is a client for VLC RC interface. Commands are executed one at a
+// time, responses are matched by their expected format since the interface
+// may also print prompts and status change notifications.
+type vlcConn struct {
+	sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	pending int           // Replies not read yet, including the greeting
+	timeout time.Duration // Time limit of a single command
+}
+
+func vlcDial(socket string) (*vlcConn, error) {
+	conn, err := net.DialTimeout("unix", socket, vlcCallTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vlcConn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		pending: 1,
+		timeout: vlcCallTimeout,
+	}, nil
+}
+
+func (c *vlcConn) Close() error {
+	return c.conn.Close()
+}
+
+// Execute command, its output is discarded
+func (c *vlcConn) Exec(command string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	lines, err := c.call(command)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "Unknown command") {
+			return fmt.Errorf("VLC error: %s", line)
+		}
+	}
+	return nil
+}
+
+// Execute command that responds with a single number
+func (c *vlcConn) Int(command string) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	lines, err := c.call(command)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range lines {
+		if value, err := strconv.Atoi(line); err == nil {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("Unexpected VLC reply to %s: %q", command, lines)
+}
+
+// Execute command that responds with a "+----[ ... ]" delimited list
+func (c *vlcConn) List(command string) ([]string, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	reply, err := c.call(command)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{}
+	started := false
+
+	for _, line := range reply {
+		if strings.HasPrefix(line, "+----[") {
+			if started {
+				return lines, nil
+			}
+			started = true
+			continue
+		}
+
+		if started {
+			lines = append(lines, line)
+		}
+	}
+	return nil, fmt.Errorf("Unexpected VLC reply to %s: %q", command, reply)
+}
+
+// Send command and read its output. Every reply ends with a "> " prompt, replies
+// left unread by earlier timed out calls are skipped first, so output is never
+// taken for the answer to another command. Caller must hold the lock.
+func (c *vlcConn) call(command string) ([]string, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	for c.pending > 0 {
+		if _, err := c.readReply(); err != nil {
+			return nil, err
+		}
+		c.pending--
+	}
+
+	if _, err := c.conn.Write([]byte(command + "\n")); err != nil {
+		return nil, err
+	}
+	c.pending++
+
+	lines, err := c.readReply()
+	if err != nil {
+		return nil, err
+	}
+	c.pending--
+
+	return lines, nil
+}
+
+// Read output lines up to the next prompt. Caller must hold the lock.
+func (c *vlcConn) readReply() ([]string, error) {
+	lines := []string{}
+	line := []byte{}
+
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case b == '\n':
+			if text := strings.TrimSpace(string(line)); text != "" {
+				lines = append(lines, text)
+			}
+			line = line[:0]
+		case b == ' ' && string(line) == ">":
+			return lines, nil
+		default:
+			line = append(line, b)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Mimic VLC RC interface. Files named "broken" fail to open, files named
+// "short" finish after a few seconds of playback.
+func fakeVlc(args []string) {
+	socket := args[3]
+	file := args[len(args)-1]
+
+	if strings.Contains(file, "broken") {
+		fmt.Fprintln(os.Stderr, "[00007f3c8c000c80] main input error: Your input can't be opened")
+		os.Exit(0)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.Exit(3)
+	}
+
+	lock := sync.Mutex{}
+	position := 0
+	playing := true
+	audio := 1
+	subtitle := -1
+
+	trackList := func(title string, active int, tracks [][2]string) string {
+		out := "+----[ " + title + " ]\r\n"
+		for _, track := range tracks {
+			line := "| " + track[0] + " - " + track[1]
+			if track[0] == fmt.Sprint(active) {
+				line += " *"
+			}
+			out += line + "\r\n"
+		}
+		return out + "+----[ end of " + title + " ]\r\n"
+	}
+
+	handle := func(conn net.Conn) {
+		conn.Write([]byte("VLC media player 3.0.16 Vetinari\nCommand Line Interface initialized. Type `help' for help.\n> "))
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 {
+				continue
+			}
+
+			lock.Lock()
+			out := ""
+
+			switch fields[0] {
+			case "get_time":
+				out = fmt.Sprintf("%d\r\n", position)
+			case "get_length":
+				out = "100\r\n"
+			case "is_playing":
+				if playing {
+					out = "1\r\n"
+				} else {
+					out = "0\r\n"
+				}
+			case "pause":
+				playing = !playing
+				out = "status change: ( pause state: 3 ): Pause\r\n"
+			case "seek":
+				fmt.Sscanf(fields[1], "%d", &position)
+			case "volup", "voldown":
+				out = "( audio volume: 281 )\r\n"
+			case "volume":
+				out = "( audio volume: " + fields[1] + " )\r\n"
+			case "atrack":
+				if len(fields) > 1 {
+					fmt.Sscanf(fields[1], "%d", &audio)
+				} else {
+					out = trackList("Audio Track", audio, [][2]string{{"-1", "Disable"}, {"1", "Track 1 - [English]"}, {"2", "Track 2 - [Japanese]"}})
+				}
+			case "strack":
+				if len(fields) > 1 {
+					fmt.Sscanf(fields[1], "%d", &subtitle)
+				} else {
+					out = trackList("Subtitle Track", subtitle, [][2]string{{"-1", "Disable"}, {"3", "Track 1 - [English]"}})
+				}
+			case "key":
+			case "quit":
+				conn.Write([]byte("Shutting down.\r\n"))
+				os.Exit(0)
+			default:
+				out = "Unknown command `" + fields[0] + "'. Type `help' for help.\r\n"
+			}
+
+			conn.Write([]byte(out + "> "))
+			lock.Unlock()
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+
+	ticks := 1000
+	if strings.Contains(file, "short") {
+		ticks = 5
+	}
+
+	for i := 0; i < ticks; i++ {
+		time.Sleep(10 * time.Millisecond)
+
+		lock.Lock()
+		if playing {
+			position++
+		}
+		lock.Unlock()
+	}
+
+	listener.Close()
+	os.Remove(socket)
+}
+
+func Test_parseVlcTracks(t *testing.T) {
+	lines := []string{
+		"| -1 - Disable",
+		"| 1 - Track 1 - [English]",
+		"| 2 - Track 2 - [Japanese] *",
+		"garbage",
+	}
+
+	assert.Equal(t, []Track{
+		{Index: -1, Name: "Disable"},
+		{Index: 1, Name: "Track 1 - [English]"},
+		{Index: 2, Name: "Track 2 - [Japanese]", Active: true},
+	}, parseVlcTracks(lines))
+}
+
+func Test_vlcArgs(t *testing.T) {
+	args := vlcArgs("/tmp/vlc.sock", "/media/movie.mkv", PlayOptions{AudioDevice: "alsa"})
+	assert.Equal(t, []string{"--aout=alsa", "--alsa-audio-device=default", "/media/movie.mkv"}, args[len(args)-3:])
+
+	audio, subtitle := 1, 0
+	args = vlcArgs("/tmp/vlc.sock", "/media/movie.mkv", PlayOptions{Tracks: TrackOptions{AudioLang: "jpn", Audio: &audio, Subtitle: &subtitle}})
+	assert.Equal(t, []string{"--audio-language=jpn", "--audio-track=1", "--sub-track=0", "/media/movie.mkv"}, args[len(args)-4:])
+
+	args = vlcArgs("/tmp/vlc.sock", "/media/movie.mkv", PlayOptions{Subtitles: "/data/subtitles/movie.srt"})
+	assert.Equal(t, []string{"--sub-file=/data/subtitles/movie.srt", "/media/movie.mkv"}, args[len(args)-2:])
+
+	args = vlcArgs("/tmp/vlc.sock", "/media/movie.mkv", PlayOptions{SubtitleDelay: 1500 * time.Millisecond})
+	assert.Equal(t, []string{"--sub-delay=15", "/media/movie.mkv"}, args[len(args)-2:])
+}
+
+func Test_VlcPlayer(t *testing.T) {
+	defer func(interval time.Duration) { vlcPollInterval = interval }(vlcPollInterval)
+	vlcPollInterval = 10 * time.Millisecond
+
+	p := NewVlcPlayer(os.Args[0])
+
+	assert.Equal(t, ErrPlayerInactive, p.Command("pause"))
+
+	assert.NoError(t, p.Play("/media/movie.mkv", PlayOptions{}))
+	assert.Equal(t, EventStarted, waitEvent(t, p).Type)
+	assert.Equal(t, ErrPlayerActive, p.Play("/media/movie.mkv", PlayOptions{}))
+
+	status := p.Status()
+	assert.Equal(t, StatePlaying, status.State)
+	assert.Equal(t, "/media/movie.mkv", status.File)
+	assert.Equal(t, uint64(100), status.Duration)
+
+	assert.NoError(t, p.Command("pause"))
+	assert.Equal(t, StatePaused, p.Status().State)
+	assert.NoError(t, p.Command("volume_up"))
+	assert.NoError(t, p.Command("pause"))
+	assert.Equal(t, StatePlaying, p.Status().State)
+
+	assert.NoError(t, p.SetVolume(-6))
+	assert.NoError(t, p.SetMuted(true))
+	assert.NoError(t, p.SetVolume(-3))
+	assert.Equal(t, -3.0, p.proc.volume)
+	assert.NoError(t, p.SetMuted(false))
+	assert.Equal(t, "volume 181", vlcVolumeCommand(-3))
+
+	assert.NoError(t, p.SetPosition(90*time.Second))
+	assert.True(t, p.Status().Position >= 90)
+	assert.NoError(t, p.Command("seek_back"))
+	assert.True(t, p.Status().Position < 90)
+
+	tracks, err := p.AudioTracks()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(tracks))
+	assert.True(t, tracks[1].Active)
+
+	// Tracks are selected by index, not by VLC track ID
+	assert.NoError(t, p.SelectAudio(1))
+	tracks, _ = p.AudioTracks()
+	assert.True(t, tracks[2].Active)
+	assert.Error(t, p.SelectAudio(2))
+
+	assert.NoError(t, p.SelectSubtitle(0))
+	tracks, _ = p.SubtitleTracks()
+	assert.True(t, tracks[1].Active)
+	assert.NoError(t, p.SelectSubtitle(-1))
+
+	assert.NoError(t, p.Command("subtitles"))
+	tracks, _ = p.SubtitleTracks()
+	assert.Equal(t, Track{Index: 3, Name: "Track 1 - [English]", Active: true}, tracks[1])
+	assert.NoError(t, p.Command("subtitles"))
+	tracks, _ = p.SubtitleTracks()
+	assert.True(t, tracks[0].Active)
+
+	// Tracks are cycled without probing, subtitles include "Disable"
+	assert.NoError(t, p.Command("audio_next"))
+	tracks, _ = p.AudioTracks()
+	assert.True(t, tracks[1].Active)
+	assert.NoError(t, p.Command("audio_previous"))
+	tracks, _ = p.AudioTracks()
+	assert.True(t, tracks[2].Active)
+	assert.NoError(t, p.Command("subtitle_previous"))
+	tracks, _ = p.SubtitleTracks()
+	assert.True(t, tracks[1].Active)
+	assert.NoError(t, p.Command("subtitle_next"))
+	tracks, _ = p.SubtitleTracks()
+	assert.True(t, tracks[0].Active)
+
+	// Published commands without RC equivalent are not supported
+	assert.Equal(t, ErrNotSupported, p.Command("info"))
+
+	// Subtitle delay is changed with 50ms hotkey steps
+	assert.NoError(t, p.Command("subtitle_delay_up"))
+	assert.NoError(t, p.SetSubtitleDelay(-100*time.Millisecond))
+	assert.Equal(t, -100*time.Millisecond, p.proc.subDelay)
+	assert.Equal(t, ErrInvalidCommand, p.Command("unknown"))
+
+	assert.NoError(t, p.Stop())
+	assert.Equal(t, EventStopped, waitEvent(t, p).Type)
+	assert.Equal(t, StateIdle, p.Status().State)
+}
+
+func Test_VlcPlayerFinished(t *testing.T) {
+	defer func(interval time.Duration) { vlcPollInterval = interval }(vlcPollInterval)
+	vlcPollInterval = 10 * time.Millisecond
+
+	p := NewVlcPlayer(os.Args[0])
+
+	assert.NoError(t, p.Play("/media/short.mkv", PlayOptions{}))
+	assert.Equal(t, EventStarted, waitEvent(t, p).Type)
+	assert.Equal(t, EventFinished, waitEvent(t, p).Type)
+	assert.Equal(t, StateIdle, p.Status().State)
+}
+
+func Test_VlcPlayerFailed(t *testing.T) {
+	p := NewVlcPlayer(os.Args[0])
+
+	err := p.Play("/media/broken.mkv", PlayOptions{})
+	assert.EqualError(t, err, "Player exited before playback started")
+	assert.Equal(t, EventFailed, waitEvent(t, p).Type)
+
+	playErr, ok := err.(*PlayError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"[00007f3c8c000c80] main input error: Your input can't be opened"}, playErr.Stderr)
+	assert.Equal(t, StateFailed, p.Status().State)
+}
+
+func Test_vlcConnReplies(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &vlcConn{conn: client, reader: bufio.NewReader(client), pending: 1, timeout: 300 * time.Millisecond}
+
+	go func() {
+		server.Write([]byte("Command Line Interface initialized.\r\n> "))
+
+		scanner := bufio.NewScanner(server)
+		for scanner.Scan() {
+			switch scanner.Text() {
+			case "seek 10":
+				server.Write([]byte("> "))
+			case "slow":
+				time.Sleep(450 * time.Millisecond)
+				server.Write([]byte("7\r\n> "))
+			case "get_time":
+				server.Write([]byte("status change: ( time: 10s )\r\n42\r\n> "))
+			default:
+				server.Write([]byte("Unknown command `" + scanner.Text() + "'.\r\n> "))
+			}
+		}
+	}()
+
+	assert.NoError(t, conn.Exec("seek 10"))
+	assert.Error(t, conn.Exec("foo"))
+
+	// Late reply of a timed out command is not taken for the next answer
+	_, err := conn.Int("slow")
+	assert.Error(t, err)
+	value, err := conn.Int("get_time")
+	assert.NoError(t, err)
+	assert.Equal(t, 42, value)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sync"
+)
+
+// Volume range in decibels. The 0-100 scale is linear amplitude where 100 is 0dB.
+const (
+	volumeMin  = -60.0
+	volumeMax  = 0.0
+	volumeStep = 3.0 // Volume change of volume_up and volume_down commands
+)
+
+var ErrVolumeRange = errors.New("Volume must be between -60 and 0 dB")
+
+// Volume is the output level tracked by the server across player sessions
+type Volume struct {
+	sync.Mutex
+	path  string
+	Level float64 `json:"level"` // Volume in decibels
+	Muted bool    `json:"muted"` // True if audio is muted
+}
+
+// Load last used volume, defaults to 0dB if nothing is saved
+func loadVolume(path string) *Volume {
+	v := &Volume{path: path}
+	if err := loadJSON(path, v); err != nil {
+		log.Println("Cant load volume:", err)
+	}
+	if v.Level < volumeMin || v.Level > volumeMax {
+		v.Level = 0
+	}
+	return v
+}
+
+// Get current level in decibels and muted flag
+func (v *Volume) Get() (float64, bool) {
+	v.Lock()
+	defer v.Unlock()
+	return v.Level, v.Muted
+}
+
+// Update and persist volume
+func (v *Volume) Set(level float64, muted bool) error {
+	if level < volumeMin || level > volumeMax {
+		return ErrVolumeRange
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	v.Level = level
+	v.Muted = muted
+
+	if v.path == "" {
+		return nil
+	}
+	return saveJSON(v.path, v)
+}
+
+// Convert decibels to 0-100 scale
+func volumePercent(db float64) float64 {
+	if db <= volumeMin {
+		return 0
+	}
+	return 100 * math.Pow(10, db/20)
+}
+
+// Convert 0-100 scale to decibels
+func volumeFromPercent(percent float64) float64 {
+	if percent <= 0 {
+		return volumeMin
+	}
+	return math.Max(volumeMin, 20*math.Log10(percent/100))
+}